@@ -19,15 +19,25 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/traefik/genconf/dynamic"
 	"github.com/traefik/genconf/dynamic/tls"
+	"github.com/traefik/hub-agent-traefik/pkg/acme"
+	hubacp "github.com/traefik/hub-agent-traefik/pkg/acp"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/ipfilter"
 	"github.com/traefik/hub-agent-traefik/pkg/certificate"
 	"github.com/traefik/hub-agent-traefik/pkg/edge"
 	"github.com/traefik/hub-agent-traefik/pkg/traefik"
@@ -37,6 +47,20 @@ const quotaExceededMiddleware = "quota-exceeded"
 
 const defaultHubTunnelEntrypoint = "traefikhub-tunl"
 
+// acmeChallenge is the name of the routers and services of the ACME challenges.
+const acmeChallenge = "acme-challenge"
+
+// defaultRouterPriority is the priority of the router of an edge ingress. Routers of the edge ingress routes have
+// a higher priority.
+const defaultRouterPriority = 60
+
+const (
+	// defaultPushDebounce is the window in which the triggers of a Traefik configuration update are coalesced.
+	defaultPushDebounce = 2 * time.Second
+	// certificateRefreshInterval is the interval at which the wildcard certificate is fetched again.
+	certificateRefreshInterval = time.Hour
+)
+
 // EdgeUpdater keep edge ingresses and Traefik configuration synchronized.
 type EdgeUpdater struct {
 	certClient    *certificate.Client
@@ -45,7 +69,27 @@ type EdgeUpdater struct {
 
 	authServerReachableAddr string
 	catchAllURL             string
-	maxSecuredRoute         int
+	// maxSecuredRoute is the maximum number of edge ingresses secured by an ACP. There is no limit when it is zero.
+	maxSecuredRoute int
+
+	acmeManager      *acme.Manager
+	acmeChallengeURL string
+
+	debounce time.Duration
+	trigger  chan struct{}
+	onSync   func()
+
+	mu        sync.Mutex
+	ingresses []edge.Ingress
+	acps      []edge.ACP
+	hasConfig bool
+
+	// pushMu protects the state of the last push, and the cached certificate.
+	pushMu        sync.Mutex
+	lastHash      [sha256.Size]byte
+	lastUnixNano  int64
+	cert          *certificate.Certificate
+	certFetchedAt time.Time
 }
 
 // NewEdgeUpdater creates EdgeUpdater.
@@ -57,11 +101,96 @@ func NewEdgeUpdater(certClient *certificate.Client, traefikClient *traefik.Clien
 		authServerReachableAddr: authServerReachableAddr,
 		catchAllURL:             catchAllURL,
 		maxSecuredRoute:         maxSecuredRoute,
+		debounce:                defaultPushDebounce,
+		trigger:                 make(chan struct{}, 1),
+		onSync:                  func() {},
+	}
+}
+
+// SetOnSync sets the function called each time the Traefik configuration is synchronized by Run.
+// It must be called before running the updater.
+func (e *EdgeUpdater) SetOnSync(fn func()) {
+	e.onSync = fn
+}
+
+// SetACME sets the manager obtaining the certificates of the custom domains, and the URL on which Traefik reaches
+// its challenge server. It must be called before running the updater.
+func (e *EdgeUpdater) SetACME(manager *acme.Manager, challengeURL string) {
+	e.acmeManager = manager
+	e.acmeChallengeURL = challengeURL
+}
+
+// SetConfig sets the edge ingresses and ACPs Traefik configuration is generated from, and triggers an update.
+func (e *EdgeUpdater) SetConfig(ingresses []edge.Ingress, acps []edge.ACP) {
+	e.mu.Lock()
+	e.ingresses = ingresses
+	e.acps = acps
+	e.hasConfig = true
+	e.mu.Unlock()
+
+	if e.acmeManager != nil {
+		e.acmeManager.SetDomains(customDomains(ingresses))
+	}
+
+	e.Trigger()
+}
+
+// Trigger triggers an update of the Traefik configuration. Triggers received within the debounce window
+// are coalesced into a single update.
+func (e *EdgeUpdater) Trigger() {
+	select {
+	case e.trigger <- struct{}{}:
+	default:
 	}
 }
 
-// Update updates Traefik configuration from edge ingresses and ACPs.
-func (e EdgeUpdater) Update(ctx context.Context, ingresses []edge.Ingress, acps []edge.ACP) error {
+// Run updates the Traefik configuration each time an update is triggered, until the given context is canceled.
+func (e *EdgeUpdater) Run(ctx context.Context) {
+	var (
+		timer  *time.Timer
+		timerC <-chan time.Time
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-e.trigger:
+			if timer == nil {
+				timer = time.NewTimer(e.debounce)
+				timerC = timer.C
+			}
+		case <-timerC:
+			timer, timerC = nil, nil
+
+			e.mu.Lock()
+			ingresses, acps, hasConfig := e.ingresses, e.acps, e.hasConfig
+			e.mu.Unlock()
+
+			// Provider events can be received before edge ingresses and ACPs are known.
+			if !hasConfig {
+				continue
+			}
+
+			if err := e.Update(ctx, ingresses, acps); err != nil {
+				log.Error().Err(err).Msg("Unable to update Traefik configuration")
+				continue
+			}
+
+			e.onSync()
+		}
+	}
+}
+
+// Update updates Traefik configuration from edge ingresses and ACPs. The configuration is pushed only when it
+// changed since the last push, or when Traefik lost it, after a restart for instance.
+func (e *EdgeUpdater) Update(ctx context.Context, ingresses []edge.Ingress, acps []edge.ACP) error {
+	e.pushMu.Lock()
+	defer e.pushMu.Unlock()
+
 	cfg := emptyDynamicConfiguration()
 
 	var err error
@@ -70,21 +199,72 @@ func (e EdgeUpdater) Update(ctx context.Context, ingresses []edge.Ingress, acps
 		return fmt.Errorf("acp to middleware: %w", err)
 	}
 
+	cfg.TLS.Options = acpToTLSOptions(acps)
+
 	err = e.appendEdgeToTraefikCfg(ctx, cfg, ingresses)
 	if err != nil {
 		return fmt.Errorf("append edge to traefik cfg: %w", err)
 	}
 
-	err = e.traefikClient.PushDynamic(ctx, time.Now().UnixNano(), cfg)
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("serialize configuration: %w", err)
+	}
+	hash := sha256.Sum256(b)
+
+	if e.lastUnixNano != 0 && hash == e.lastHash {
+		state, err := e.traefikClient.GetProviderState(ctx)
+		if err == nil && state.LastConfigUnixNano == e.lastUnixNano {
+			return nil
+		}
+
+		if err != nil {
+			log.Warn().Err(err).Msg("Unable to get Traefik provider state, pushing configuration")
+		} else {
+			log.Info().
+				Int64("last_config_unix_nano", state.LastConfigUnixNano).
+				Msg("Traefik lost its configuration, pushing it again")
+		}
+	}
+
+	unixNano := time.Now().UnixNano()
+	err = e.traefikClient.PushDynamic(ctx, unixNano, cfg)
 	if err != nil {
 		return fmt.Errorf("push dynamic: %w", err)
 	}
 
+	e.lastHash = hash
+	e.lastUnixNano = unixNano
+
 	return nil
 }
 
-func (e EdgeUpdater) appendEdgeToTraefikCfg(ctx context.Context, cfg *dynamic.Configuration, edgeIngresses []edge.Ingress) error {
+// getCertificate returns the wildcard certificate, which is cached for certificateRefreshInterval.
+// The cached certificate keeps being used while it is valid if it cannot be fetched again.
+func (e *EdgeUpdater) getCertificate(ctx context.Context) (certificate.Certificate, error) {
+	now := time.Now()
+	if e.cert != nil && now.Sub(e.certFetchedAt) < certificateRefreshInterval && now.Before(e.cert.NotAfter) {
+		return *e.cert, nil
+	}
+
 	cert, err := e.certClient.GetCertificate(ctx)
+	if err != nil {
+		if e.cert != nil && now.Before(e.cert.NotAfter) {
+			log.Warn().Err(err).Msg("Unable to get certificate, using the cached one")
+			return *e.cert, nil
+		}
+
+		return certificate.Certificate{}, err
+	}
+
+	e.cert = &cert
+	e.certFetchedAt = now
+
+	return cert, nil
+}
+
+func (e *EdgeUpdater) appendEdgeToTraefikCfg(ctx context.Context, cfg *dynamic.Configuration, edgeIngresses []edge.Ingress) error {
+	cert, err := e.getCertificate(ctx)
 	if err != nil {
 		return fmt.Errorf("get certificate: %w", err)
 	}
@@ -96,6 +276,12 @@ func (e EdgeUpdater) appendEdgeToTraefikCfg(ctx context.Context, cfg *dynamic.Co
 		},
 	})
 
+	if e.acmeManager != nil {
+		if err = e.appendACMEToTraefikCfg(cfg); err != nil {
+			return fmt.Errorf("append ACME to traefik cfg: %w", err)
+		}
+	}
+
 	cfg.HTTP.Middlewares["strip"] = &dynamic.Middleware{
 		StripPrefixRegex: &dynamic.StripPrefixRegex{
 			Regex: []string{".*"},
@@ -126,6 +312,19 @@ func (e EdgeUpdater) appendEdgeToTraefikCfg(ctx context.Context, cfg *dynamic.Co
 		},
 	}
 
+	overQuota := e.overQuota(edgeIngresses)
+
+	// The names generated for an edge ingress must not collide with the name of another edge ingress, nor with the
+	// names generated for it.
+	names := map[string]struct{}{"catch-all": {}, acmeChallenge: {}}
+	for _, ingress := range edgeIngresses {
+		names[ingress.Name] = struct{}{}
+	}
+	middlewareNames := make(map[string]struct{}, len(cfg.HTTP.Middlewares))
+	for name := range cfg.HTTP.Middlewares {
+		middlewareNames[name] = struct{}{}
+	}
+
 	for _, ingress := range edgeIngresses {
 		logger := log.With().Str("workspace_id", ingress.WorkspaceID).
 			Str("cluster_id", ingress.ClusterID).
@@ -134,58 +333,514 @@ func (e EdgeUpdater) appendEdgeToTraefikCfg(ctx context.Context, cfg *dynamic.Co
 			Str("service_network", ingress.Service.Network).
 			Logger()
 
-		ip, err := e.provider.GetIP(ctx, "/"+ingress.Service.Name, ingress.Service.Network)
-		if err != nil {
-			logger.Error().Err(err).Msg("unable to get IP")
+		// Domains are written as is in the router rules.
+		if !acme.ValidDomain(strings.ToLower(ingress.Domain)) {
+			logger.Error().Str("domain", ingress.Domain).Msg("Invalid domain, the edge ingress is ignored")
 			continue
 		}
 
-		if ip == "" {
+		services := make(map[string]*dynamic.Service)
+		// The service of an edge ingress is optional when it has routes.
+		if len(ingress.Routes) == 0 || len(ingress.Services) > 0 || ingress.Service.Name != "" {
+			services = e.ingressServices(logger.WithContext(ctx), ingress)
+		}
+
+		routes := e.routeServices(logger.WithContext(ctx), ingress)
+		if len(services) == 0 && len(routes) == 0 {
 			logger.Error().Msg("Unable to get service IP")
 			continue
 		}
 
+		generated := make([]string, 0, len(services)+len(routes))
+		for name := range services {
+			if name != ingress.Name {
+				generated = append(generated, name)
+			}
+		}
+		sort.Strings(generated)
+
+		var generatedMiddlewares []string
+		for _, route := range routes {
+			generated = append(generated, route.name)
+			if route.route.StripPrefix {
+				generatedMiddlewares = append(generatedMiddlewares, stripMiddlewareName(route))
+			}
+		}
+
+		colliding := collidingName(names, generated...)
+		if colliding == "" {
+			colliding = collidingName(middlewareNames, generatedMiddlewares...)
+		}
+		if colliding != "" {
+			logger.Error().Str("name", colliding).Msg("Generated name already used, the edge ingress is ignored")
+			continue
+		}
+		for _, name := range generated {
+			names[name] = struct{}{}
+		}
+		for _, name := range generatedMiddlewares {
+			middlewareNames[name] = struct{}{}
+		}
+
 		var middleware []string
+		routerTLS := &dynamic.RouterTLSConfig{}
 		if ingress.ACP != nil {
-			middleware = append(middleware, ingress.ACP.Name)
+			if _, ok := overQuota[ingress.Name]; ok {
+				logger.Warn().
+					Str("acp_name", ingress.ACP.Name).
+					Int("max_secured_routes", e.maxSecuredRoute).
+					Msg("Secured routes quota exceeded, the edge ingress is blocked")
+
+				middleware = append(middleware, quotaExceededMiddleware)
+			} else {
+				middleware = append(middleware, ingress.ACP.Name)
+
+				if _, ok := cfg.TLS.Options[ingress.ACP.Name]; ok {
+					routerTLS.Options = ingress.ACP.Name
+				}
+			}
+		}
+
+		hostRule := hostRule(logger.WithContext(ctx), ingress)
+
+		if len(services) > 0 {
+			cfg.HTTP.Routers[ingress.Name] = &dynamic.Router{
+				EntryPoints: []string{defaultHubTunnelEntrypoint},
+				Middlewares: middleware,
+				Service:     ingress.Name,
+				Rule:        hostRule,
+				Priority:    defaultRouterPriority,
+				TLS:         routerTLS,
+			}
+		}
+
+		for name, service := range services {
+			cfg.HTTP.Services[name] = service
+		}
+
+		for _, route := range routes {
+			routeMiddlewares := middleware
+			if route.route.StripPrefix {
+				stripName := stripMiddlewareName(route)
+				cfg.HTTP.Middlewares[stripName] = &dynamic.Middleware{
+					StripPrefix: &dynamic.StripPrefix{Prefixes: []string{route.route.PathPrefix}},
+				}
+
+				routeMiddlewares = append(append([]string{}, middleware...), stripName)
+			}
+
+			rule := routeRule(hostRule, route.route)
+
+			cfg.HTTP.Routers[route.name] = &dynamic.Router{
+				EntryPoints: []string{defaultHubTunnelEntrypoint},
+				Middlewares: routeMiddlewares,
+				Service:     route.name,
+				Rule:        rule,
+				// Like Traefik does by default, longer rules, which are more specific, are matched first.
+				Priority: defaultRouterPriority + len(rule),
+				TLS:      routerTLS,
+			}
+
+			cfg.HTTP.Services[route.name] = &dynamic.Service{LoadBalancer: route.loadBalancer}
+		}
+	}
+
+	return nil
+}
+
+// appendACMEToTraefikCfg adds the certificates of the custom domains, and the routing of their ACME challenges
+// to the challenge server.
+func (e *EdgeUpdater) appendACMEToTraefikCfg(cfg *dynamic.Configuration) error {
+	for _, cert := range e.acmeManager.Certificates() {
+		cfg.TLS.Certificates = append(cfg.TLS.Certificates, &tls.CertAndStores{
+			Certificate: tls.Certificate{
+				CertFile: string(cert.Certificate),
+				KeyFile:  string(cert.PrivateKey),
+			},
+		})
+	}
+
+	// TLS connections can only be passed through to the challenge server while a challenge is pending, as the
+	// connections of the clients would also be.
+	if domains := e.acmeManager.PendingTLSALPNDomains(); len(domains) > 0 {
+		challengeURL, err := url.Parse(e.acmeChallengeURL)
+		if err != nil {
+			return fmt.Errorf("parse challenge URL: %w", err)
 		}
 
-		cfg.HTTP.Routers[ingress.Name] = &dynamic.Router{
+		cfg.TCP.Routers[acmeChallenge] = &dynamic.TCPRouter{
 			EntryPoints: []string{defaultHubTunnelEntrypoint},
-			Middlewares: middleware,
-			Service:     ingress.Name,
-			Rule:        fmt.Sprintf("Host(`%s`)", ingress.Domain),
-			Priority:    60,
-			TLS:         &dynamic.RouterTLSConfig{},
-		}
-
-		cfg.HTTP.Services[ingress.Name] = &dynamic.Service{
-			LoadBalancer: &dynamic.ServersLoadBalancer{
-				Servers: []dynamic.Server{
-					{URL: "http://" + net.JoinHostPort(ip, strconv.Itoa(ingress.Service.Port))},
-				},
+			Service:     acmeChallenge,
+			Rule:        matcherRule("HostSNI", domains),
+			TLS:         &dynamic.RouterTCPTLSConfig{Passthrough: true},
+		}
+		cfg.TCP.Services[acmeChallenge] = &dynamic.TCPService{
+			LoadBalancer: &dynamic.TCPServersLoadBalancer{
+				Servers: []dynamic.TCPServer{{Address: challengeURL.Host}},
 			},
 		}
 	}
 
+	// Only the domains validated by the manager are written in the rule.
+	domains := e.acmeManager.HTTP01Domains()
+	if len(domains) == 0 {
+		return nil
+	}
+
+	// The router has no TLS configuration: challenges are validated over HTTP.
+	cfg.HTTP.Routers[acmeChallenge] = &dynamic.Router{
+		EntryPoints: []string{defaultHubTunnelEntrypoint},
+		Service:     acmeChallenge,
+		Rule:        matcherRule("Host", domains) + " && PathPrefix(`/.well-known/acme-challenge/`)",
+		Priority:    math.MaxInt32,
+	}
+	cfg.HTTP.Services[acmeChallenge] = &dynamic.Service{
+		LoadBalancer: &dynamic.ServersLoadBalancer{
+			Servers: []dynamic.Server{{URL: e.acmeChallengeURL}},
+		},
+	}
+
 	return nil
 }
 
-func (e EdgeUpdater) acpToMiddleware(acps []edge.ACP) (map[string]*dynamic.Middleware, error) {
+// customDomains returns the sorted custom domains of the given edge ingresses.
+func customDomains(ingresses []edge.Ingress) []string {
+	set := make(map[string]struct{})
+	for _, ingress := range ingresses {
+		for _, domain := range ingress.CustomDomains {
+			set[domain] = struct{}{}
+		}
+	}
+
+	domains := make([]string, 0, len(set))
+	for domain := range set {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	return domains
+}
+
+// routeService is the load balancer of an edge ingress route.
+type routeService struct {
+	name         string
+	route        edge.Route
+	loadBalancer *dynamic.ServersLoadBalancer
+}
+
+// routeServices returns the load balancers of the routes of the given edge ingress.
+// Invalid routes, and routes whose service has no IP, are ignored.
+func (e *EdgeUpdater) routeServices(ctx context.Context, ingress edge.Ingress) []routeService {
+	logger := log.Ctx(ctx)
+
+	var sticky *edge.Sticky
+	var healthCheck *edge.HealthCheck
+	if ingress.LoadBalancer != nil {
+		sticky = ingress.LoadBalancer.Sticky
+		healthCheck = ingress.LoadBalancer.HealthCheck
+	}
+
+	var routes []routeService
+	for i, route := range ingress.Routes {
+		// Path prefixes and headers are written as is in the router rules.
+		if !strings.HasPrefix(route.PathPrefix, "/") || strings.Contains(route.PathPrefix, "`") {
+			logger.Warn().Str("path_prefix", route.PathPrefix).Msg("Invalid route path prefix, the route is ignored")
+			continue
+		}
+		if !validHeaders(route.Headers) {
+			logger.Warn().Str("path_prefix", route.PathPrefix).Msg("Invalid route headers, the route is ignored")
+			continue
+		}
+
+		lb := e.serversLoadBalancer(ctx, route.Service, sticky, healthCheck)
+		if lb == nil {
+			logger.Warn().
+				Str("path_prefix", route.PathPrefix).
+				Str("service_name", route.Service.Name).
+				Str("service_network", route.Service.Network).
+				Msg("Unable to get service IP, the route is ignored")
+			continue
+		}
+
+		routes = append(routes, routeService{
+			name:         ingress.Name + "-route-" + strconv.Itoa(i),
+			route:        route,
+			loadBalancer: lb,
+		})
+	}
+
+	return routes
+}
+
+// stripMiddlewareName returns the name of the middleware stripping the path prefix of the given route.
+func stripMiddlewareName(route routeService) string {
+	return route.name + "-strip"
+}
+
+// validHeaders tells whether the given route headers can be written in a router rule.
+func validHeaders(headers map[string]string) bool {
+	for name, value := range headers {
+		if name == "" || strings.Contains(name, "`") || strings.Contains(value, "`") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hostRule returns the rule matching the domain and the valid custom domains of the given edge ingress.
+func hostRule(ctx context.Context, ingress edge.Ingress) string {
+	domains := []string{ingress.Domain}
+	for _, domain := range ingress.CustomDomains {
+		if !acme.ValidDomain(strings.ToLower(domain)) {
+			log.Ctx(ctx).Warn().Str("domain", domain).Msg("Invalid custom domain, it is ignored")
+			continue
+		}
+
+		domains = append(domains, domain)
+	}
+
+	return matcherRule("Host", domains)
+}
+
+// matcherRule returns the rule of the given matcher with the given values, such as Host(`a`, `b`).
+func matcherRule(matcher string, values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, fmt.Sprintf("`%s`", value))
+	}
+
+	return fmt.Sprintf("%s(%s)", matcher, strings.Join(quoted, ", "))
+}
+
+// routeRule returns the rule matching the requests of the given route.
+func routeRule(hostRule string, route edge.Route) string {
+	rule := fmt.Sprintf("%s && PathPrefix(`%s`)", hostRule, route.PathPrefix)
+
+	names := make([]string, 0, len(route.Headers))
+	for name := range route.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rule += fmt.Sprintf(" && Headers(`%s`, `%s`)", name, route.Headers[name])
+	}
+
+	return rule
+}
+
+// ingressServices returns the Traefik services of the given edge ingress, indexed by name. The service named after
+// the edge ingress load balances between all the replicas of its service or, when the edge ingress has several
+// services, between the weighted services it is split into.
+func (e *EdgeUpdater) ingressServices(ctx context.Context, ingress edge.Ingress) map[string]*dynamic.Service {
+	logger := log.Ctx(ctx)
+
+	var sticky *edge.Sticky
+	var healthCheck *edge.HealthCheck
+	if ingress.LoadBalancer != nil {
+		sticky = ingress.LoadBalancer.Sticky
+		healthCheck = ingress.LoadBalancer.HealthCheck
+	}
+
+	if len(ingress.Services) == 0 {
+		lb := e.serversLoadBalancer(ctx, ingress.Service, sticky, healthCheck)
+		if lb == nil {
+			return nil
+		}
+
+		return map[string]*dynamic.Service{ingress.Name: {LoadBalancer: lb}}
+	}
+
+	// Each weighted service sets its own cookie to keep sending a client to the same replica once the weighted
+	// round-robin cookie sent it to this service.
+	var serverSticky *edge.Sticky
+	if sticky != nil {
+		stickyCopy := *sticky
+		if stickyCopy.CookieName != "" {
+			stickyCopy.CookieName += "-server"
+		}
+		serverSticky = &stickyCopy
+	}
+
+	result := make(map[string]*dynamic.Service)
+	wrr := &dynamic.WeightedRoundRobin{Sticky: toDynamicSticky(sticky)}
+	if healthCheck != nil {
+		wrr.HealthCheck = &dynamic.HealthCheck{}
+	}
+
+	for i, service := range ingress.Services {
+		lb := e.serversLoadBalancer(ctx, service, serverSticky, healthCheck)
+		if lb == nil {
+			logger.Warn().
+				Str("service_name", service.Name).
+				Str("service_network", service.Network).
+				Msg("Unable to get service IP, the service is ignored")
+			continue
+		}
+
+		weight := service.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		name := ingress.Name + "-" + strconv.Itoa(i)
+		result[name] = &dynamic.Service{LoadBalancer: lb}
+		wrr.Services = append(wrr.Services, dynamic.WRRService{Name: name, Weight: &weight})
+	}
+
+	if len(wrr.Services) == 0 {
+		return nil
+	}
+
+	result[ingress.Name] = &dynamic.Service{Weighted: wrr}
+
+	return result
+}
+
+// serversLoadBalancer returns a load balancer between all the replicas of the given service,
+// or nil if none of them has an IP.
+func (e *EdgeUpdater) serversLoadBalancer(ctx context.Context, service edge.Service, sticky *edge.Sticky, healthCheck *edge.HealthCheck) *dynamic.ServersLoadBalancer {
+	logger := log.Ctx(ctx)
+
+	ips, err := e.provider.GetIPs(ctx, "/"+service.Name, service.Network)
+	if err != nil {
+		logger.Error().Err(err).Str("service_name", service.Name).Msg("unable to get IP")
+		return nil
+	}
+
+	if len(ips) == 0 {
+		return nil
+	}
+
+	lb := &dynamic.ServersLoadBalancer{
+		Sticky:      toDynamicSticky(sticky),
+		HealthCheck: toDynamicHealthCheck(healthCheck),
+	}
+	for _, ip := range ips {
+		lb.Servers = append(lb.Servers, dynamic.Server{URL: "http://" + net.JoinHostPort(ip, strconv.Itoa(service.Port))})
+	}
+
+	return lb
+}
+
+func toDynamicSticky(sticky *edge.Sticky) *dynamic.Sticky {
+	if sticky == nil {
+		return nil
+	}
+
+	return &dynamic.Sticky{
+		Cookie: &dynamic.Cookie{
+			Name:     sticky.CookieName,
+			Secure:   sticky.Secure,
+			HTTPOnly: sticky.HTTPOnly,
+			SameSite: sticky.SameSite,
+		},
+	}
+}
+
+func toDynamicHealthCheck(healthCheck *edge.HealthCheck) *dynamic.ServerHealthCheck {
+	if healthCheck == nil {
+		return nil
+	}
+
+	hc := &dynamic.ServerHealthCheck{
+		Path:     healthCheck.Path,
+		Port:     healthCheck.Port,
+		Hostname: healthCheck.Hostname,
+		Headers:  healthCheck.Headers,
+	}
+	if healthCheck.Interval > 0 {
+		hc.Interval = healthCheck.Interval.String()
+	}
+	if healthCheck.Timeout > 0 {
+		hc.Timeout = healthCheck.Timeout.String()
+	}
+
+	return hc
+}
+
+// overQuota returns the names of the edge ingresses secured by an ACP which exceed the secured routes quota.
+// The oldest edge ingresses are secured first, so creating an edge ingress never blocks existing ones.
+func (e *EdgeUpdater) overQuota(ingresses []edge.Ingress) map[string]struct{} {
+	if e.maxSecuredRoute <= 0 {
+		return nil
+	}
+
+	var secured []edge.Ingress
+	for _, ingress := range ingresses {
+		if ingress.ACP != nil {
+			secured = append(secured, ingress)
+		}
+	}
+
+	if len(secured) <= e.maxSecuredRoute {
+		return nil
+	}
+
+	sort.Slice(secured, func(i, j int) bool {
+		if !secured[i].CreatedAt.Equal(secured[j].CreatedAt) {
+			return secured[i].CreatedAt.Before(secured[j].CreatedAt)
+		}
+
+		return secured[i].Name < secured[j].Name
+	})
+
+	names := make(map[string]struct{}, len(secured)-e.maxSecuredRoute)
+	for _, ingress := range secured[e.maxSecuredRoute:] {
+		names[ingress.Name] = struct{}{}
+	}
+
+	return names
+}
+
+func (e *EdgeUpdater) acpToMiddleware(acps []edge.ACP) (map[string]*dynamic.Middleware, error) {
 	middlewares := make(map[string]*dynamic.Middleware)
+	acpsByName := indexACPs(acps)
+	acpNames := acpNameSet(acps)
 
 	for _, acp := range acps {
-		headerToFwd, err := headerToForward(acp)
+		headerToFwd, err := headerToForward(acp, acpsByName)
 		if err != nil {
 			return nil, err
 		}
+		if acp.Mode == edge.ACPModeAudit {
+			headerToFwd = append(headerToFwd, hubacp.DecisionHeader)
+		}
 
-		middlewares[acp.Name] = &dynamic.Middleware{
+		forwardAuth := &dynamic.Middleware{
 			ForwardAuth: &dynamic.ForwardAuth{
 				Address:             fmt.Sprintf("%s/%s", e.authServerReachableAddr, acp.Name),
 				AuthResponseHeaders: headerToFwd,
+				TrustForwardHeader:  trustForwardHeader(acp, acpsByName),
 			},
 		}
+
+		if !requiresClientCert(acp, acpsByName) {
+			middlewares[acp.Name] = forwardAuth
+			continue
+		}
+
+		// The client certificate must be passed to the auth server, which requires to chain both middlewares.
+		clientCertName, forwardAuthName := clientCertMiddlewareNames(acp)
+
+		// The middlewares of another ACP must not be overwritten. Without middleware, the routers of the skipped ACP
+		// are rejected by Traefik.
+		if colliding := collidingName(acpNames, clientCertName, forwardAuthName); colliding != "" {
+			log.Error().
+				Str("acp_name", acp.Name).
+				Str("colliding_acp_name", colliding).
+				Msg("ACP middleware name is already used by another ACP, the ACP is ignored")
+			continue
+		}
+
+		middlewares[clientCertName] = &dynamic.Middleware{
+			PassTLSClientCert: &dynamic.PassTLSClientCert{PEM: true},
+		}
+		middlewares[forwardAuthName] = forwardAuth
+		middlewares[acp.Name] = &dynamic.Middleware{
+			Chain: &dynamic.Chain{Middlewares: []string{clientCertName, forwardAuthName}},
+		}
 	}
 
 	middlewares[quotaExceededMiddleware] = &dynamic.Middleware{
@@ -200,6 +855,56 @@ func (e EdgeUpdater) acpToMiddleware(acps []edge.ACP) (map[string]*dynamic.Middl
 	return middlewares, nil
 }
 
+// clientCertMiddlewareNames returns the names of the middlewares chained by the middleware of an ACP requiring a
+// client certificate.
+func clientCertMiddlewareNames(acp edge.ACP) (clientCertName, forwardAuthName string) {
+	return acp.Name + "-client-cert", acp.Name + "-forward-auth"
+}
+
+// collidingName returns the first of the given names which is a key of the given map, or an empty string.
+func collidingName(taken map[string]struct{}, names ...string) string {
+	for _, name := range names {
+		if _, ok := taken[name]; ok {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// acpToTLSOptions returns the TLS options required by the given ACPs, indexed by ACP name.
+// mTLS ACPs require clients to present a certificate during the TLS handshake, its chain is
+// then verified by the auth server.
+func acpToTLSOptions(acps []edge.ACP) map[string]tls.Options {
+	options := make(map[string]tls.Options)
+	acpsByName := indexACPs(acps)
+	acpNames := acpNameSet(acps)
+
+	for _, acp := range acps {
+		if !requiresClientCert(acp, acpsByName) {
+			continue
+		}
+
+		// Like their middlewares, the options of ACPs whose middleware names collide with another ACP are ignored.
+		clientCertName, forwardAuthName := clientCertMiddlewareNames(acp)
+		if collidingName(acpNames, clientCertName, forwardAuthName) != "" {
+			continue
+		}
+
+		// ACPs in audit mode must not reject clients without certificate during the TLS handshake.
+		clientAuthType := "RequireAnyClientCert"
+		if acp.Mode == edge.ACPModeAudit {
+			clientAuthType = "RequestClientCert"
+		}
+
+		options[acp.Name] = tls.Options{
+			ClientAuth: tls.ClientAuth{ClientAuthType: clientAuthType},
+		}
+	}
+
+	return options
+}
+
 func emptyDynamicConfiguration() *dynamic.Configuration {
 	return &dynamic.Configuration{
 		HTTP: &dynamic.HTTPConfiguration{
@@ -223,14 +928,97 @@ func emptyDynamicConfiguration() *dynamic.Configuration {
 	}
 }
 
-func headerToForward(acp edge.ACP) ([]string, error) {
+func indexACPs(acps []edge.ACP) map[string]edge.ACP {
+	acpsByName := make(map[string]edge.ACP, len(acps))
+	for _, acp := range acps {
+		acpsByName[acp.Name] = acp
+	}
+
+	return acpsByName
+}
+
+func acpNameSet(acps []edge.ACP) map[string]struct{} {
+	names := make(map[string]struct{}, len(acps))
+	for _, acp := range acps {
+		names[acp.Name] = struct{}{}
+	}
+
+	return names
+}
+
+// policies returns the given ACP, or the ACPs it is composed of if it is a composite ACP.
+func policies(acp edge.ACP, acpsByName map[string]edge.ACP) []edge.ACP {
+	if acp.Composite == nil {
+		return []edge.ACP{acp}
+	}
+
+	var res []edge.ACP
+	for _, name := range acp.Composite.ACPs {
+		if child, ok := acpsByName[name]; ok {
+			res = append(res, child)
+		}
+	}
+
+	return res
+}
+
+// requiresClientCert returns whether the given ACP, or one of the ACPs it is composed of, is an mTLS ACP.
+func requiresClientCert(acp edge.ACP, acpsByName map[string]edge.ACP) bool {
+	for _, p := range policies(acp, acpsByName) {
+		if p.MTLS != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trustForwardHeader returns whether the given ACP, or one of the ACPs it is composed of, needs the X-Forwarded-For
+// header received by Traefik to find the client IP.
+func trustForwardHeader(acp edge.ACP, acpsByName map[string]edge.ACP) bool {
+	for _, p := range policies(acp, acpsByName) {
+		// An invalid strategy is rejected by the auth server, the header doesn't need to be trusted.
+		strategy, err := ipfilter.NewStrategy(ipStrategy(p))
+		if err == nil && strategy.ForwardedHeaderRequired() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ipStrategy returns the IP strategy configured in the given ACP, if any.
+func ipStrategy(acp edge.ACP) *edge.ACPIPStrategy {
+	switch {
+	case acp.IPFilter != nil:
+		return acp.IPFilter.IPStrategy
+	case acp.JWT != nil && acp.JWT.Lockout != nil:
+		return acp.JWT.Lockout.IPStrategy
+	case acp.BasicAuth != nil && acp.BasicAuth.Lockout != nil:
+		return acp.BasicAuth.Lockout.IPStrategy
+	default:
+		return nil
+	}
+}
+
+// sortedHeaderNames returns the names of the given forwarded headers, sorted so the generated configuration doesn't
+// change between two updates.
+func sortedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func headerToForward(acp edge.ACP, acpsByName map[string]edge.ACP) ([]string, error) {
 	var headerToFwd []string
 
 	switch {
 	case acp.JWT != nil:
-		for headerName := range acp.JWT.ForwardHeaders {
-			headerToFwd = append(headerToFwd, headerName)
-		}
+		headerToFwd = append(headerToFwd, sortedHeaderNames(acp.JWT.ForwardHeaders)...)
 		if acp.JWT.StripAuthorizationHeader {
 			headerToFwd = append(headerToFwd, "Authorization")
 		}
@@ -243,6 +1031,49 @@ func headerToForward(acp edge.ACP) ([]string, error) {
 			headerToFwd = append(headerToFwd, "Authorization")
 		}
 
+	case acp.OIDC != nil:
+		headerToFwd = append(headerToFwd, sortedHeaderNames(acp.OIDC.ForwardHeaders)...)
+
+	case acp.Introspection != nil:
+		headerToFwd = append(headerToFwd, sortedHeaderNames(acp.Introspection.ForwardHeaders)...)
+		if acp.Introspection.StripAuthorizationHeader {
+			headerToFwd = append(headerToFwd, "Authorization")
+		}
+
+	case acp.APIKey != nil:
+		headerToFwd = append(headerToFwd, sortedHeaderNames(acp.APIKey.ForwardHeaders)...)
+
+	case acp.MTLS != nil:
+		headerToFwd = append(headerToFwd, sortedHeaderNames(acp.MTLS.ForwardHeaders)...)
+
+	case acp.IPFilter != nil:
+		// IP filter ACPs don't forward any header.
+
+	case acp.Composite != nil:
+		seen := make(map[string]struct{})
+		for _, name := range acp.Composite.ACPs {
+			child, ok := acpsByName[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown ACP %q referenced by composite ACP %q", name, acp.Name)
+			}
+			if child.Composite != nil {
+				return nil, fmt.Errorf("composite ACP %q cannot reference composite ACP %q", acp.Name, name)
+			}
+
+			childHeaders, err := headerToForward(child, acpsByName)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, headerName := range childHeaders {
+				if _, ok := seen[headerName]; ok {
+					continue
+				}
+				seen[headerName] = struct{}{}
+				headerToFwd = append(headerToFwd, headerName)
+			}
+		}
+
 	default:
 		return nil, errors.New("unsupported ACP type")
 	}
@@ -19,15 +19,30 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/traefik/genconf/dynamic"
+	"github.com/traefik/genconf/dynamic/tls"
+	"github.com/traefik/hub-agent-traefik/pkg/acme"
 	"github.com/traefik/hub-agent-traefik/pkg/certificate"
 	"github.com/traefik/hub-agent-traefik/pkg/edge"
 	"github.com/traefik/hub-agent-traefik/pkg/traefik"
@@ -82,6 +97,953 @@ func TestEdgeUpdater_Update(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestEdgeUpdater_Update_mTLS(t *testing.T) {
+	certClient := setupCertClient(t)
+
+	ingresses := []edge.Ingress{
+		{
+			Name:    "name",
+			Domain:  "majestic-beaver-123.traefik-hub.io",
+			Service: edge.Service{Name: "service-name", Network: "foo_network", Port: 8080},
+			ACP:     &edge.ACPInfo{Name: "acp-name"},
+		},
+	}
+	acps := []edge.ACP{
+		{
+			Name: "acp-name",
+			MTLS: &edge.ACPMTLSConfig{ForwardHeaders: map[string]string{"X-Client-CN": "subject.commonName"}},
+		},
+	}
+
+	edgeUpdater := NewEdgeUpdater(certClient, nil, providerMock{}, "http://auth", "localhost", 2)
+
+	cfg := emptyDynamicConfiguration()
+
+	var err error
+	cfg.HTTP.Middlewares, err = edgeUpdater.acpToMiddleware(acps)
+	require.NoError(t, err)
+	cfg.TLS.Options = acpToTLSOptions(acps)
+
+	err = edgeUpdater.appendEdgeToTraefikCfg(context.Background(), cfg, ingresses)
+	require.NoError(t, err)
+
+	assert.Equal(t, &dynamic.Middleware{
+		Chain: &dynamic.Chain{Middlewares: []string{"acp-name-client-cert", "acp-name-forward-auth"}},
+	}, cfg.HTTP.Middlewares["acp-name"])
+	assert.Equal(t, &dynamic.Middleware{
+		PassTLSClientCert: &dynamic.PassTLSClientCert{PEM: true},
+	}, cfg.HTTP.Middlewares["acp-name-client-cert"])
+	assert.Equal(t, &dynamic.Middleware{
+		ForwardAuth: &dynamic.ForwardAuth{
+			Address:             "http://auth/acp-name",
+			AuthResponseHeaders: []string{"X-Client-CN"},
+		},
+	}, cfg.HTTP.Middlewares["acp-name-forward-auth"])
+
+	assert.Equal(t, map[string]tls.Options{
+		"acp-name": {ClientAuth: tls.ClientAuth{ClientAuthType: "RequireAnyClientCert"}},
+	}, cfg.TLS.Options)
+
+	require.Contains(t, cfg.HTTP.Routers, "name")
+	assert.Equal(t, []string{"acp-name"}, cfg.HTTP.Routers["name"].Middlewares)
+	assert.Equal(t, &dynamic.RouterTLSConfig{Options: "acp-name"}, cfg.HTTP.Routers["name"].TLS)
+}
+
+func TestEdgeUpdater_acpToMiddleware_collidingNames(t *testing.T) {
+	acps := []edge.ACP{
+		{Name: "acp", MTLS: &edge.ACPMTLSConfig{}},
+		{Name: "acp-client-cert", JWT: &edge.ACPJWTConfig{SigningSecret: "secret"}},
+		{Name: "other", MTLS: &edge.ACPMTLSConfig{}},
+	}
+
+	edgeUpdater := NewEdgeUpdater(nil, nil, providerMock{}, "http://auth", "localhost", 2)
+
+	middlewares, err := edgeUpdater.acpToMiddleware(acps)
+	require.NoError(t, err)
+
+	assert.NotContains(t, middlewares, "acp")
+	assert.NotContains(t, middlewares, "acp-forward-auth")
+	assert.Equal(t, &dynamic.Middleware{
+		ForwardAuth: &dynamic.ForwardAuth{Address: "http://auth/acp-client-cert"},
+	}, middlewares["acp-client-cert"])
+	assert.Contains(t, middlewares, "other-client-cert")
+
+	options := acpToTLSOptions(acps)
+	assert.NotContains(t, options, "acp")
+	assert.Contains(t, options, "other")
+}
+
+func TestEdgeUpdater_Update_maxSecuredRoutes(t *testing.T) {
+	certClient := setupCertClient(t)
+
+	now := time.Now()
+	ingresses := []edge.Ingress{
+		{Name: "newest", Domain: "newest.traefik-hub.io", ACP: &edge.ACPInfo{Name: "acp"}, CreatedAt: now},
+		{Name: "oldest", Domain: "oldest.traefik-hub.io", ACP: &edge.ACPInfo{Name: "acp"}, CreatedAt: now.Add(-2 * time.Hour)},
+		{Name: "public", Domain: "public.traefik-hub.io", CreatedAt: now.Add(-3 * time.Hour)},
+		{Name: "b", Domain: "b.traefik-hub.io", ACP: &edge.ACPInfo{Name: "acp"}, CreatedAt: now.Add(-time.Hour)},
+		{Name: "a", Domain: "a.traefik-hub.io", ACP: &edge.ACPInfo{Name: "acp"}, CreatedAt: now.Add(-time.Hour)},
+	}
+	acps := []edge.ACP{
+		{Name: "acp", JWT: &edge.ACPJWTConfig{SigningSecret: "secret"}},
+	}
+
+	tests := []struct {
+		desc            string
+		maxSecuredRoute int
+		wantMiddlewares map[string][]string
+	}{
+		{
+			desc:            "no limit",
+			maxSecuredRoute: 0,
+			wantMiddlewares: map[string][]string{
+				"newest": {"acp"},
+				"oldest": {"acp"},
+				"public": nil,
+				"b":      {"acp"},
+				"a":      {"acp"},
+			},
+		},
+		{
+			desc:            "quota exceeded",
+			maxSecuredRoute: 2,
+			wantMiddlewares: map[string][]string{
+				"newest": {quotaExceededMiddleware},
+				"oldest": {"acp"},
+				"public": nil,
+				"b":      {quotaExceededMiddleware},
+				"a":      {"acp"},
+			},
+		},
+		{
+			desc:            "quota reached",
+			maxSecuredRoute: 4,
+			wantMiddlewares: map[string][]string{
+				"newest": {"acp"},
+				"oldest": {"acp"},
+				"public": nil,
+				"b":      {"acp"},
+				"a":      {"acp"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			edgeUpdater := NewEdgeUpdater(certClient, nil, providerMock{}, "http://auth", "localhost", test.maxSecuredRoute)
+
+			cfg := emptyDynamicConfiguration()
+
+			var err error
+			cfg.HTTP.Middlewares, err = edgeUpdater.acpToMiddleware(acps)
+			require.NoError(t, err)
+
+			err = edgeUpdater.appendEdgeToTraefikCfg(context.Background(), cfg, ingresses)
+			require.NoError(t, err)
+
+			for name, wantMiddlewares := range test.wantMiddlewares {
+				require.Contains(t, cfg.HTTP.Routers, name)
+				assert.Equal(t, wantMiddlewares, cfg.HTTP.Routers[name].Middlewares, name)
+			}
+		})
+	}
+}
+
+func TestEdgeUpdater_Update_loadBalancing(t *testing.T) {
+	provider := providerMock{ips: map[string][]string{
+		"/whoami":  {"10.0.0.2", "10.0.0.3"},
+		"/canary":  {"10.0.0.4"},
+		"/stopped": nil,
+	}}
+
+	tests := []struct {
+		desc         string
+		ingress      edge.Ingress
+		wantServices map[string]*dynamic.Service
+	}{
+		{
+			desc: "all replicas",
+			ingress: edge.Ingress{
+				Name:    "name",
+				Domain:  "majestic-beaver-123.traefik-hub.io",
+				Service: edge.Service{Name: "whoami", Network: "foo_network", Port: 8080},
+			},
+			wantServices: map[string]*dynamic.Service{
+				"name": {
+					LoadBalancer: &dynamic.ServersLoadBalancer{
+						Servers: []dynamic.Server{
+							{URL: "http://10.0.0.2:8080"},
+							{URL: "http://10.0.0.3:8080"},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "sticky cookie and health check",
+			ingress: edge.Ingress{
+				Name:    "name",
+				Domain:  "majestic-beaver-123.traefik-hub.io",
+				Service: edge.Service{Name: "whoami", Network: "foo_network", Port: 8080},
+				LoadBalancer: &edge.LoadBalancer{
+					Sticky: &edge.Sticky{CookieName: "lb", Secure: true, HTTPOnly: true, SameSite: "strict"},
+					HealthCheck: &edge.HealthCheck{
+						Path:     "/health",
+						Port:     8081,
+						Interval: 10 * time.Second,
+						Timeout:  3 * time.Second,
+						Headers:  map[string]string{"X-Health": "true"},
+					},
+				},
+			},
+			wantServices: map[string]*dynamic.Service{
+				"name": {
+					LoadBalancer: &dynamic.ServersLoadBalancer{
+						Sticky: &dynamic.Sticky{Cookie: &dynamic.Cookie{Name: "lb", Secure: true, HTTPOnly: true, SameSite: "strict"}},
+						Servers: []dynamic.Server{
+							{URL: "http://10.0.0.2:8080"},
+							{URL: "http://10.0.0.3:8080"},
+						},
+						HealthCheck: &dynamic.ServerHealthCheck{
+							Path:     "/health",
+							Port:     8081,
+							Interval: "10s",
+							Timeout:  "3s",
+							Headers:  map[string]string{"X-Health": "true"},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "weighted services",
+			ingress: edge.Ingress{
+				Name:   "name",
+				Domain: "majestic-beaver-123.traefik-hub.io",
+				Services: []edge.Service{
+					{Name: "whoami", Network: "foo_network", Port: 8080, Weight: 9},
+					{Name: "stopped", Network: "foo_network", Port: 8080, Weight: 5},
+					{Name: "canary", Network: "foo_network", Port: 80},
+				},
+				LoadBalancer: &edge.LoadBalancer{
+					Sticky:      &edge.Sticky{CookieName: "lb"},
+					HealthCheck: &edge.HealthCheck{Path: "/health"},
+				},
+			},
+			wantServices: map[string]*dynamic.Service{
+				"name": {
+					Weighted: &dynamic.WeightedRoundRobin{
+						Services: []dynamic.WRRService{
+							{Name: "name-0", Weight: intPtr(9)},
+							{Name: "name-2", Weight: intPtr(1)},
+						},
+						Sticky:      &dynamic.Sticky{Cookie: &dynamic.Cookie{Name: "lb"}},
+						HealthCheck: &dynamic.HealthCheck{},
+					},
+				},
+				"name-0": {
+					LoadBalancer: &dynamic.ServersLoadBalancer{
+						Sticky: &dynamic.Sticky{Cookie: &dynamic.Cookie{Name: "lb-server"}},
+						Servers: []dynamic.Server{
+							{URL: "http://10.0.0.2:8080"},
+							{URL: "http://10.0.0.3:8080"},
+						},
+						HealthCheck: &dynamic.ServerHealthCheck{Path: "/health"},
+					},
+				},
+				"name-2": {
+					LoadBalancer: &dynamic.ServersLoadBalancer{
+						Sticky:      &dynamic.Sticky{Cookie: &dynamic.Cookie{Name: "lb-server"}},
+						Servers:     []dynamic.Server{{URL: "http://10.0.0.4:80"}},
+						HealthCheck: &dynamic.ServerHealthCheck{Path: "/health"},
+					},
+				},
+			},
+		},
+		{
+			desc: "no replicas",
+			ingress: edge.Ingress{
+				Name:    "name",
+				Domain:  "majestic-beaver-123.traefik-hub.io",
+				Service: edge.Service{Name: "stopped", Network: "foo_network", Port: 8080},
+			},
+			wantServices: map[string]*dynamic.Service{},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			edgeUpdater := NewEdgeUpdater(setupCertClient(t), nil, provider, "http://auth", "localhost", 2)
+
+			cfg := emptyDynamicConfiguration()
+
+			err := edgeUpdater.appendEdgeToTraefikCfg(context.Background(), cfg, []edge.Ingress{test.ingress})
+			require.NoError(t, err)
+
+			delete(cfg.HTTP.Services, "catch-all")
+			assert.Equal(t, test.wantServices, cfg.HTTP.Services)
+
+			if len(test.wantServices) == 0 {
+				assert.NotContains(t, cfg.HTTP.Routers, "name")
+				return
+			}
+
+			require.Contains(t, cfg.HTTP.Routers, "name")
+			assert.Equal(t, "name", cfg.HTTP.Routers["name"].Service)
+		})
+	}
+}
+
+func TestEdgeUpdater_Update_collidingServiceNames(t *testing.T) {
+	provider := providerMock{ips: map[string][]string{
+		"/whoami": {"10.0.0.2"},
+		"/canary": {"10.0.0.3"},
+	}}
+
+	ingresses := []edge.Ingress{
+		{
+			Name:   "name",
+			Domain: "majestic-beaver-123.traefik-hub.io",
+			Services: []edge.Service{
+				{Name: "whoami", Network: "foo_network", Port: 8080},
+				{Name: "canary", Network: "foo_network", Port: 8080},
+			},
+		},
+		{
+			Name:    "name-1",
+			Domain:  "brave-otter-456.traefik-hub.io",
+			Service: edge.Service{Name: "canary", Network: "foo_network", Port: 80},
+		},
+	}
+
+	edgeUpdater := NewEdgeUpdater(setupCertClient(t), nil, provider, "http://auth", "localhost", 2)
+
+	cfg := emptyDynamicConfiguration()
+
+	err := edgeUpdater.appendEdgeToTraefikCfg(context.Background(), cfg, ingresses)
+	require.NoError(t, err)
+
+	// The weighted service "name-1" of the first edge ingress would replace the service of the second one.
+	assert.NotContains(t, cfg.HTTP.Routers, "name")
+	assert.NotContains(t, cfg.HTTP.Services, "name-0")
+	assert.Equal(t, &dynamic.Service{
+		LoadBalancer: &dynamic.ServersLoadBalancer{Servers: []dynamic.Server{{URL: "http://10.0.0.3:80"}}},
+	}, cfg.HTTP.Services["name-1"])
+	assert.Contains(t, cfg.HTTP.Routers, "name-1")
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestEdgeUpdater_Update_routes(t *testing.T) {
+	provider := providerMock{ips: map[string][]string{
+		"/whoami":  {"10.0.0.2"},
+		"/api":     {"10.0.0.3"},
+		"/app":     {"10.0.0.4"},
+		"/stopped": nil,
+	}}
+
+	ingress := edge.Ingress{
+		Name:          "name",
+		Domain:        "majestic-beaver-123.traefik-hub.io",
+		CustomDomains: []string{"example.com"},
+		ACP:           &edge.ACPInfo{Name: "acp"},
+		Routes: []edge.Route{
+			{
+				PathPrefix:  "/api",
+				Headers:     map[string]string{"X-Version": "2", "Accept": "application/json"},
+				StripPrefix: true,
+				Service:     edge.Service{Name: "api", Network: "foo_network", Port: 8080},
+			},
+			{
+				PathPrefix: "/app",
+				Service:    edge.Service{Name: "app", Network: "foo_network", Port: 80},
+			},
+			{
+				PathPrefix: "/stopped",
+				Service:    edge.Service{Name: "stopped", Network: "foo_network", Port: 80},
+			},
+			{
+				PathPrefix: "invalid",
+				Service:    edge.Service{Name: "app", Network: "foo_network", Port: 80},
+			},
+		},
+	}
+
+	tests := []struct {
+		desc        string
+		service     edge.Service
+		wantRouters map[string]*dynamic.Router
+	}{
+		{
+			desc: "routes only",
+			wantRouters: map[string]*dynamic.Router{
+				"name-route-0": {
+					EntryPoints: []string{defaultHubTunnelEntrypoint},
+					Middlewares: []string{"acp", "name-route-0-strip"},
+					Service:     "name-route-0",
+					Rule:        "Host(`majestic-beaver-123.traefik-hub.io`, `example.com`) && PathPrefix(`/api`) && Headers(`Accept`, `application/json`) && Headers(`X-Version`, `2`)",
+					Priority:    209,
+					TLS:         &dynamic.RouterTLSConfig{},
+				},
+				"name-route-1": {
+					EntryPoints: []string{defaultHubTunnelEntrypoint},
+					Middlewares: []string{"acp"},
+					Service:     "name-route-1",
+					Rule:        "Host(`majestic-beaver-123.traefik-hub.io`, `example.com`) && PathPrefix(`/app`)",
+					Priority:    139,
+					TLS:         &dynamic.RouterTLSConfig{},
+				},
+			},
+		},
+		{
+			desc:    "routes and default service",
+			service: edge.Service{Name: "whoami", Network: "foo_network", Port: 8080},
+			wantRouters: map[string]*dynamic.Router{
+				"name": {
+					EntryPoints: []string{defaultHubTunnelEntrypoint},
+					Middlewares: []string{"acp"},
+					Service:     "name",
+					Rule:        "Host(`majestic-beaver-123.traefik-hub.io`, `example.com`)",
+					Priority:    60,
+					TLS:         &dynamic.RouterTLSConfig{},
+				},
+				"name-route-0": {
+					EntryPoints: []string{defaultHubTunnelEntrypoint},
+					Middlewares: []string{"acp", "name-route-0-strip"},
+					Service:     "name-route-0",
+					Rule:        "Host(`majestic-beaver-123.traefik-hub.io`, `example.com`) && PathPrefix(`/api`) && Headers(`Accept`, `application/json`) && Headers(`X-Version`, `2`)",
+					Priority:    209,
+					TLS:         &dynamic.RouterTLSConfig{},
+				},
+				"name-route-1": {
+					EntryPoints: []string{defaultHubTunnelEntrypoint},
+					Middlewares: []string{"acp"},
+					Service:     "name-route-1",
+					Rule:        "Host(`majestic-beaver-123.traefik-hub.io`, `example.com`) && PathPrefix(`/app`)",
+					Priority:    139,
+					TLS:         &dynamic.RouterTLSConfig{},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			edgeUpdater := NewEdgeUpdater(setupCertClient(t), nil, provider, "http://auth", "localhost", 2)
+
+			cfg := emptyDynamicConfiguration()
+			cfg.HTTP.Middlewares["acp"] = &dynamic.Middleware{}
+
+			ingress := ingress
+			ingress.Service = test.service
+
+			err := edgeUpdater.appendEdgeToTraefikCfg(context.Background(), cfg, []edge.Ingress{ingress})
+			require.NoError(t, err)
+
+			delete(cfg.HTTP.Routers, "catch-all")
+			assert.Equal(t, test.wantRouters, cfg.HTTP.Routers)
+
+			assert.Equal(t, &dynamic.Middleware{
+				StripPrefix: &dynamic.StripPrefix{Prefixes: []string{"/api"}},
+			}, cfg.HTTP.Middlewares["name-route-0-strip"])
+
+			assert.Equal(t, &dynamic.Service{
+				LoadBalancer: &dynamic.ServersLoadBalancer{Servers: []dynamic.Server{{URL: "http://10.0.0.3:8080"}}},
+			}, cfg.HTTP.Services["name-route-0"])
+			assert.Equal(t, &dynamic.Service{
+				LoadBalancer: &dynamic.ServersLoadBalancer{Servers: []dynamic.Server{{URL: "http://10.0.0.4:80"}}},
+			}, cfg.HTTP.Services["name-route-1"])
+		})
+	}
+}
+
+func TestEdgeUpdater_Update_ruleInjection(t *testing.T) {
+	provider := providerMock{ips: map[string][]string{
+		"/whoami": {"10.0.0.2"},
+		"/admin":  {"10.0.0.3"},
+	}}
+
+	ingresses := []edge.Ingress{
+		{
+			Name:          "name",
+			Domain:        "majestic-beaver-123.traefik-hub.io",
+			CustomDomains: []string{"example.com", "example.org`) || PathPrefix(`/"},
+			ACP:           &edge.ACPInfo{Name: "acp"},
+			Service:       edge.Service{Name: "whoami", Network: "foo_network", Port: 8080},
+			Routes: []edge.Route{
+				{
+					PathPrefix: "/api`) || PathPrefix(`/",
+					Service:    edge.Service{Name: "admin", Network: "foo_network", Port: 80},
+				},
+				{
+					PathPrefix: "/app",
+					Headers:    map[string]string{"X-Version": "2`) || PathPrefix(`/"},
+					Service:    edge.Service{Name: "admin", Network: "foo_network", Port: 80},
+				},
+			},
+		},
+		{
+			Name:    "injected",
+			Domain:  "example.net`) || PathPrefix(`/",
+			Service: edge.Service{Name: "admin", Network: "foo_network", Port: 80},
+		},
+	}
+
+	edgeUpdater := NewEdgeUpdater(setupCertClient(t), nil, provider, "http://auth", "localhost", 2)
+
+	cfg := emptyDynamicConfiguration()
+	cfg.HTTP.Middlewares["acp"] = &dynamic.Middleware{}
+
+	err := edgeUpdater.appendEdgeToTraefikCfg(context.Background(), cfg, ingresses)
+	require.NoError(t, err)
+
+	delete(cfg.HTTP.Routers, "catch-all")
+	assert.Equal(t, map[string]*dynamic.Router{
+		"name": {
+			EntryPoints: []string{defaultHubTunnelEntrypoint},
+			Middlewares: []string{"acp"},
+			Service:     "name",
+			Rule:        "Host(`majestic-beaver-123.traefik-hub.io`, `example.com`)",
+			Priority:    60,
+			TLS:         &dynamic.RouterTLSConfig{},
+		},
+	}, cfg.HTTP.Routers)
+}
+
+func TestEdgeUpdater_Update_collidingRouteNames(t *testing.T) {
+	provider := providerMock{ips: map[string][]string{
+		"/whoami": {"10.0.0.2"},
+		"/api":    {"10.0.0.3"},
+	}}
+
+	ingresses := []edge.Ingress{
+		{
+			Name:    "name",
+			Domain:  "majestic-beaver-123.traefik-hub.io",
+			Service: edge.Service{Name: "whoami", Network: "foo_network", Port: 8080},
+			Routes: []edge.Route{
+				{
+					PathPrefix:  "/api",
+					StripPrefix: true,
+					Service:     edge.Service{Name: "api", Network: "foo_network", Port: 80},
+				},
+			},
+		},
+		{
+			Name:    "other",
+			Domain:  "brave-otter-456.traefik-hub.io",
+			Service: edge.Service{Name: "whoami", Network: "foo_network", Port: 8080},
+		},
+	}
+
+	tests := []struct {
+		desc           string
+		otherName      string
+		middlewareName string
+	}{
+		{
+			desc:      "router and service",
+			otherName: "name-route-0",
+		},
+		{
+			desc:           "strip middleware",
+			otherName:      "other",
+			middlewareName: "name-route-0-strip",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			edgeUpdater := NewEdgeUpdater(setupCertClient(t), nil, provider, "http://auth", "localhost", 2)
+
+			cfg := emptyDynamicConfiguration()
+			if test.middlewareName != "" {
+				cfg.HTTP.Middlewares[test.middlewareName] = &dynamic.Middleware{}
+			}
+
+			ingresses := append([]edge.Ingress{}, ingresses...)
+			ingresses[1].Name = test.otherName
+
+			err := edgeUpdater.appendEdgeToTraefikCfg(context.Background(), cfg, ingresses)
+			require.NoError(t, err)
+
+			assert.NotContains(t, cfg.HTTP.Routers, "name")
+			assert.Equal(t, "Host(`brave-otter-456.traefik-hub.io`)", cfg.HTTP.Routers[test.otherName].Rule)
+
+			if test.middlewareName != "" {
+				assert.Equal(t, &dynamic.Middleware{}, cfg.HTTP.Middlewares[test.middlewareName])
+			}
+		})
+	}
+}
+
+func TestEdgeUpdater_Update_acme(t *testing.T) {
+	storageDir := t.TempDir()
+	certPEM, keyPEM := writeACMECertificate(t, storageDir, "example.com")
+
+	manager, err := acme.NewManager(acme.Config{
+		DirectoryURL: "https://acme.example.com/directory",
+		StorageDir:   storageDir,
+		Challenge:    acme.ChallengeHTTP01,
+	})
+	require.NoError(t, err)
+
+	edgeUpdater := NewEdgeUpdater(setupCertClient(t), nil, providerMock{}, "http://auth", "localhost", 2)
+	edgeUpdater.SetACME(manager, "http://10.0.0.1:8080")
+
+	ingresses := []edge.Ingress{
+		{
+			Name:          "name",
+			Domain:        "majestic-beaver-123.traefik-hub.io",
+			CustomDomains: []string{"example.com", "www.example.com", "example.org`) || PathPrefix(`/"},
+			Service:       edge.Service{Name: "whoami", Network: "foo_network", Port: 8080},
+		},
+	}
+	edgeUpdater.SetConfig(ingresses, nil)
+
+	cfg := emptyDynamicConfiguration()
+	err = edgeUpdater.appendEdgeToTraefikCfg(context.Background(), cfg, ingresses)
+	require.NoError(t, err)
+
+	assert.Contains(t, cfg.TLS.Certificates, &tls.CertAndStores{
+		Certificate: tls.Certificate{CertFile: string(certPEM), KeyFile: string(keyPEM)},
+	})
+
+	assert.Equal(t, &dynamic.Router{
+		EntryPoints: []string{defaultHubTunnelEntrypoint},
+		Service:     acmeChallenge,
+		Rule:        "Host(`example.com`, `www.example.com`) && PathPrefix(`/.well-known/acme-challenge/`)",
+		Priority:    math.MaxInt32,
+	}, cfg.HTTP.Routers[acmeChallenge])
+	assert.Equal(t, &dynamic.Service{
+		LoadBalancer: &dynamic.ServersLoadBalancer{Servers: []dynamic.Server{{URL: "http://10.0.0.1:8080"}}},
+	}, cfg.HTTP.Services[acmeChallenge])
+}
+
+func TestEdgeUpdater_Update_acmeTLSALPN01Renewal(t *testing.T) {
+	storageDir := t.TempDir()
+	writeACMECertificate(t, storageDir, "example.com")
+
+	manager, err := acme.NewManager(acme.Config{
+		DirectoryURL: "https://acme.example.com/directory",
+		StorageDir:   storageDir,
+		Challenge:    acme.ChallengeTLSALPN01,
+	})
+	require.NoError(t, err)
+
+	edgeUpdater := NewEdgeUpdater(setupCertClient(t), nil, providerMock{}, "http://auth", "localhost", 2)
+	edgeUpdater.SetACME(manager, "http://10.0.0.1:8080")
+
+	ingresses := []edge.Ingress{
+		{
+			Name:          "name",
+			Domain:        "majestic-beaver-123.traefik-hub.io",
+			CustomDomains: []string{"example.com", "www.example.com"},
+			Service:       edge.Service{Name: "whoami", Network: "foo_network", Port: 8080},
+		},
+	}
+	edgeUpdater.SetConfig(ingresses, nil)
+
+	cfg := emptyDynamicConfiguration()
+	err = edgeUpdater.appendEdgeToTraefikCfg(context.Background(), cfg, ingresses)
+	require.NoError(t, err)
+
+	// The domain with a valid certificate is renewed with the http-01 challenge, the other one has no pending challenge.
+	assert.NotContains(t, cfg.TCP.Routers, acmeChallenge)
+	require.Contains(t, cfg.HTTP.Routers, acmeChallenge)
+	assert.Equal(t, "Host(`example.com`) && PathPrefix(`/.well-known/acme-challenge/`)", cfg.HTTP.Routers[acmeChallenge].Rule)
+}
+
+// writeACMECertificate stores a self-signed certificate for the given domain the way the ACME manager does.
+func writeACMECertificate(t *testing.T, storageDir, domain string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	dir := filepath.Join(storageDir, "certificates")
+	require.NoError(t, os.MkdirAll(dir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, domain+".crt"), certPEM, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, domain+".key"), keyPEM, 0o600))
+
+	return certPEM, keyPEM
+}
+
+func TestEdgeUpdater_Update_unchangedConfiguration(t *testing.T) {
+	traefikSrv := newTraefikServerMock(t)
+	certClient, certCalls := setupCachableCertClient(t)
+
+	ingresses := []edge.Ingress{
+		{Name: "name", Domain: "majestic-beaver-123.traefik-hub.io", Service: edge.Service{Name: "whoami", Network: "foo_network", Port: 8080}},
+	}
+
+	edgeUpdater := NewEdgeUpdater(certClient, traefikSrv.client, providerMock{}, "http://auth", "localhost", 2)
+
+	err := edgeUpdater.Update(context.Background(), ingresses, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&traefikSrv.pushes))
+
+	// Nothing changed: the configuration is not pushed again.
+	err = edgeUpdater.Update(context.Background(), ingresses, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&traefikSrv.pushes))
+
+	// Traefik restarted and lost its configuration.
+	atomic.StoreInt64(&traefikSrv.lastConfigUnixNano, 0)
+
+	err = edgeUpdater.Update(context.Background(), ingresses, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&traefikSrv.pushes))
+
+	ingresses[0].Service.Port = 80
+
+	err = edgeUpdater.Update(context.Background(), ingresses, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&traefikSrv.pushes))
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(certCalls))
+}
+
+func TestEdgeUpdater_Update_unchangedForwardedHeaders(t *testing.T) {
+	traefikSrv := newTraefikServerMock(t)
+	certClient, _ := setupCachableCertClient(t)
+
+	ingresses := []edge.Ingress{
+		{Name: "name", Domain: "majestic-beaver-123.traefik-hub.io", Service: edge.Service{Name: "whoami", Network: "foo_network", Port: 8080}, ACP: &edge.ACPInfo{Name: "jwt"}},
+	}
+
+	edgeUpdater := NewEdgeUpdater(certClient, traefikSrv.client, providerMock{}, "http://auth", "localhost", 2)
+
+	// The ACPs are built on each update, so the forwarded headers are iterated in a different order each time.
+	for i := 0; i < 10; i++ {
+		acps := []edge.ACP{
+			{
+				Name: "jwt",
+				JWT: &edge.ACPJWTConfig{
+					ForwardHeaders: map[string]string{
+						"X-User":   "sub",
+						"X-Group":  "grp",
+						"X-Email":  "email",
+						"X-Tenant": "tenant",
+						"X-Scope":  "scope",
+					},
+					StripAuthorizationHeader: true,
+				},
+			},
+		}
+
+		err := edgeUpdater.Update(context.Background(), ingresses, acps)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&traefikSrv.pushes))
+}
+
+func TestEdgeUpdater_Run_debounce(t *testing.T) {
+	traefikSrv := newTraefikServerMock(t)
+	certClient, _ := setupCachableCertClient(t)
+
+	ingresses := []edge.Ingress{
+		{Name: "name", Domain: "majestic-beaver-123.traefik-hub.io", Service: edge.Service{Name: "whoami", Network: "foo_network", Port: 8080}},
+	}
+
+	edgeUpdater := NewEdgeUpdater(certClient, traefikSrv.client, providerMock{}, "http://auth", "localhost", 2)
+	edgeUpdater.debounce = 50 * time.Millisecond
+
+	synced := make(chan struct{}, 10)
+	edgeUpdater.SetOnSync(func() {
+		synced <- struct{}{}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go edgeUpdater.Run(ctx)
+
+	// Provider events received before the edge configuration are ignored.
+	edgeUpdater.Trigger()
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, synced, 0)
+
+	edgeUpdater.SetConfig(ingresses, nil)
+	edgeUpdater.Trigger()
+	edgeUpdater.Trigger()
+
+	select {
+	case <-synced:
+	case <-time.After(time.Second):
+		require.Fail(t, "configuration not synchronized")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, synced, 0)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&traefikSrv.pushes))
+}
+
+type traefikServerMock struct {
+	client *traefik.Client
+
+	pushes             int32
+	lastConfigUnixNano int64
+}
+
+func newTraefikServerMock(t *testing.T) *traefikServerMock {
+	t.Helper()
+
+	m := &traefikServerMock{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", func(rw http.ResponseWriter, req *http.Request) {
+		var payload struct {
+			UnixNano int64 `json:"unixNano"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		atomic.AddInt32(&m.pushes, 1)
+		atomic.StoreInt64(&m.lastConfigUnixNano, payload.UnixNano)
+	})
+	mux.HandleFunc("/state", func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode(traefik.ProviderState{LastConfigUnixNano: atomic.LoadInt64(&m.lastConfigUnixNano)})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	var err error
+	m.client, err = traefik.NewClient(srv.URL, true, "", "", "")
+	require.NoError(t, err)
+
+	return m
+}
+
+// setupCachableCertClient returns a certificate client serving a valid certificate, and the number of times it was fetched.
+func setupCachableCertClient(t *testing.T) (*certificate.Client, *int32) {
+	t.Helper()
+
+	var calls int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/wildcard-certificate", func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		_ = json.NewEncoder(rw).Encode(certificate.Certificate{
+			Domains:     []string{"example.com"},
+			NotAfter:    time.Now().Add(24 * time.Hour),
+			Certificate: []byte("cert"),
+			PrivateKey:  []byte("key"),
+		})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	client, err := certificate.NewClient(srv.URL, "token")
+	require.NoError(t, err)
+
+	return client, &calls
+}
+
+func TestHeaderToForward_composite(t *testing.T) {
+	acps := []edge.ACP{
+		{Name: "jwt", JWT: &edge.ACPJWTConfig{ForwardHeaders: map[string]string{"User": "sub"}, StripAuthorizationHeader: true}},
+		{Name: "basic", BasicAuth: &edge.ACPBasicAuthConfig{ForwardUsernameHeader: "User", StripAuthorizationHeader: true}},
+		{Name: "cert", MTLS: &edge.ACPMTLSConfig{}},
+		{Name: "jwt-or-basic", Composite: &edge.ACPCompositeConfig{Operator: "or", ACPs: []string{"jwt", "basic"}}},
+		{Name: "cert-and-jwt", Composite: &edge.ACPCompositeConfig{Operator: "and", ACPs: []string{"cert", "jwt"}}},
+		{Name: "nested", Composite: &edge.ACPCompositeConfig{Operator: "and", ACPs: []string{"jwt-or-basic", "cert"}}},
+		{Name: "unknown", Composite: &edge.ACPCompositeConfig{Operator: "and", ACPs: []string{"jwt", "missing"}}},
+	}
+	acpsByName := indexACPs(acps)
+
+	headers, err := headerToForward(acpsByName["jwt-or-basic"], acpsByName)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"User", "Authorization"}, headers)
+
+	_, err = headerToForward(acpsByName["nested"], acpsByName)
+	assert.Error(t, err)
+
+	_, err = headerToForward(acpsByName["unknown"], acpsByName)
+	assert.Error(t, err)
+
+	assert.False(t, requiresClientCert(acpsByName["jwt-or-basic"], acpsByName))
+	assert.True(t, requiresClientCert(acpsByName["cert-and-jwt"], acpsByName))
+}
+
+func TestTrustForwardHeader(t *testing.T) {
+	acps := []edge.ACP{
+		{Name: "jwt", JWT: &edge.ACPJWTConfig{}},
+		{Name: "office", IPFilter: &edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}}},
+		{Name: "office-behind-lb", IPFilter: &edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}, IPStrategy: &edge.ACPIPStrategy{Depth: 1}}},
+		{Name: "office-behind-proxies", IPFilter: &edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}, IPStrategy: &edge.ACPIPStrategy{ExcludedIPs: []string{"172.16.0.0/12"}}}},
+		{Name: "office-and-jwt", Composite: &edge.ACPCompositeConfig{Operator: "and", ACPs: []string{"office-behind-lb", "jwt"}}},
+		{Name: "basic-auth-behind-lb", BasicAuth: &edge.ACPBasicAuthConfig{Lockout: &edge.ACPLockoutConfig{MaxAttempts: 5, IPStrategy: &edge.ACPIPStrategy{Depth: 1}}}},
+	}
+	acpsByName := indexACPs(acps)
+
+	assert.False(t, trustForwardHeader(acpsByName["jwt"], acpsByName))
+	assert.False(t, trustForwardHeader(acpsByName["office"], acpsByName))
+	assert.True(t, trustForwardHeader(acpsByName["office-behind-lb"], acpsByName))
+	assert.True(t, trustForwardHeader(acpsByName["office-behind-proxies"], acpsByName))
+	assert.True(t, trustForwardHeader(acpsByName["office-and-jwt"], acpsByName))
+	assert.True(t, trustForwardHeader(acpsByName["basic-auth-behind-lb"], acpsByName))
+
+	headers, err := headerToForward(acpsByName["office"], acpsByName)
+	require.NoError(t, err)
+	assert.Empty(t, headers)
+}
+
+func TestEdgeUpdater_acpToMiddleware_auditMode(t *testing.T) {
+	acps := []edge.ACP{
+		{Name: "jwt", Mode: edge.ACPModeAudit, JWT: &edge.ACPJWTConfig{ForwardHeaders: map[string]string{"User": "sub"}}},
+		{Name: "cert", Mode: edge.ACPModeAudit, MTLS: &edge.ACPMTLSConfig{}},
+	}
+
+	edgeUpdater := NewEdgeUpdater(nil, nil, providerMock{}, "http://auth", "localhost", 2)
+
+	middlewares, err := edgeUpdater.acpToMiddleware(acps)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"User", "X-Hub-Acp-Decision"}, middlewares["jwt"].ForwardAuth.AuthResponseHeaders)
+	assert.Equal(t, []string{"X-Hub-Acp-Decision"}, middlewares["cert-forward-auth"].ForwardAuth.AuthResponseHeaders)
+
+	assert.Equal(t, map[string]tls.Options{
+		"cert": {ClientAuth: tls.ClientAuth{ClientAuthType: "RequestClientCert"}},
+	}, acpToTLSOptions(acps))
+}
+
 func setupTraefikClient(t *testing.T) *traefik.Client {
 	t.Helper()
 
@@ -33,11 +33,27 @@ import (
 const (
 	flagAuthServerListenAddr               = "auth-server.listen-addr"
 	flagAuthServerAdvertiseURL             = "auth-server.advertise-url"
+	flagAuthServerCacheSize                = "auth-server.cache.size"
+	flagAuthServerCacheTTL                 = "auth-server.cache.ttl"
+	flagAuthServerAuditEnabled             = "auth-server.audit.enabled"
+	flagAuthServerAuditOutput              = "auth-server.audit.output"
+	flagAuthServerAuditSuccessSampleRate   = "auth-server.audit.success-sample-rate"
+	flagAuthServerAuditMaxSize             = "auth-server.audit.max-size"
+	flagAuthServerAuditMaxBackups          = "auth-server.audit.max-backups"
+	flagACMEEnabled                        = "acme.enabled"
+	flagACMEDirectoryURL                   = "acme.directory-url"
+	flagACMEDirectoryCA                    = "acme.directory-ca"
+	flagACMEEmail                          = "acme.email"
+	flagACMEStorageDir                     = "acme.storage-dir"
+	flagACMEChallenge                      = "acme.challenge"
+	flagACMEListenAddr                     = "acme.listen-addr"
+	flagACMEAdvertiseURL                   = "acme.advertise-url"
 	flagHubToken                           = "hub.token"
 	flagHubURL                             = "hub.url"
 	flagHubUIURL                           = "hub.ui.url"
 	flagLogLevel                           = "log.level"
 	flagLogFormat                          = "log.format"
+	flagMetricsListenAddr                  = "metrics.listen-addr"
 	flagTraefikHost                        = "traefik.host"
 	flagTraefikAPIPort                     = "traefik.api-port"
 	flagTraefikTunnelPort                  = "traefik.tunnel-port"
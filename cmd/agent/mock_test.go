@@ -23,12 +23,19 @@ import (
 	"github.com/traefik/hub-agent-traefik/pkg/topology"
 )
 
-type providerMock struct{}
+// providerMock resolves services to the configured IPs, or to "127.0.0.1" when no IPs are configured.
+type providerMock struct {
+	ips map[string][]string
+}
 
 func (m providerMock) Watch(ctx context.Context, clusterID string, fn func(map[string]*topology.Service)) error {
 	return nil
 }
 
-func (m providerMock) GetIP(ctx context.Context, containerName, network string) (string, error) {
-	return "127.0.0.1", nil
+func (m providerMock) GetIPs(ctx context.Context, serviceName, network string) ([]string, error) {
+	if m.ips == nil {
+		return []string{"127.0.0.1"}, nil
+	}
+
+	return m.ips[serviceName], nil
 }
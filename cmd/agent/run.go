@@ -26,13 +26,17 @@ import (
 
 	"github.com/ettle/strcase"
 	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/acme"
 	"github.com/traefik/hub-agent-traefik/pkg/acp"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/audit"
 	"github.com/traefik/hub-agent-traefik/pkg/certificate"
 	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"github.com/traefik/hub-agent-traefik/pkg/health"
 	"github.com/traefik/hub-agent-traefik/pkg/heartbeat"
 	"github.com/traefik/hub-agent-traefik/pkg/logger"
 	"github.com/traefik/hub-agent-traefik/pkg/platform"
 	"github.com/traefik/hub-agent-traefik/pkg/provider"
+	"github.com/traefik/hub-agent-traefik/pkg/telemetry"
 	"github.com/traefik/hub-agent-traefik/pkg/topology"
 	topostore "github.com/traefik/hub-agent-traefik/pkg/topology/store"
 	"github.com/traefik/hub-agent-traefik/pkg/traefik"
@@ -42,10 +46,18 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// Components reported by the readiness endpoint.
+const (
+	componentACPs          = "acps"
+	componentTraefik       = "traefik"
+	componentTraefikConfig = "traefik-config"
+	componentTunnels       = "tunnels"
+)
+
 // ProviderWatcher watches provider changes.
 type ProviderWatcher interface {
 	Watch(ctx context.Context, clusterID string, fn func(map[string]*topology.Service)) error
-	GetIP(ctx context.Context, containerName, network string) (string, error)
+	GetIPs(ctx context.Context, serviceName, network string) ([]string, error)
 }
 
 type runCmd struct {
@@ -116,6 +128,95 @@ func newRunCmd() runCmd {
 				Usage:   "Address on which Traefik can reach the Agent auth server. Required when the automatic IP discovery fails",
 				EnvVars: []string{strcase.ToSNAKE(flagAuthServerAdvertiseURL)},
 			},
+			&cli.IntFlag{
+				Name:    flagAuthServerCacheSize,
+				Usage:   "Maximum number of auth decisions cached by the auth server. The cache is disabled when set to 0",
+				EnvVars: []string{strcase.ToSNAKE(flagAuthServerCacheSize)},
+			},
+			&cli.DurationFlag{
+				Name:    flagAuthServerCacheTTL,
+				Usage:   "Duration for which the auth server caches an auth decision",
+				EnvVars: []string{strcase.ToSNAKE(flagAuthServerCacheTTL)},
+				Value:   30 * time.Second,
+			},
+			&cli.BoolFlag{
+				Name:    flagAuthServerAuditEnabled,
+				Usage:   "Enable the audit log of the decisions taken by the JWT and basic auth ACPs",
+				EnvVars: []string{strcase.ToSNAKE(flagAuthServerAuditEnabled)},
+			},
+			&cli.StringFlag{
+				Name:    flagAuthServerAuditOutput,
+				Usage:   "Output of the audit log: either `stderr` or the path of a file",
+				EnvVars: []string{strcase.ToSNAKE(flagAuthServerAuditOutput)},
+				Value:   "stderr",
+			},
+			&cli.Float64Flag{
+				Name:    flagAuthServerAuditSuccessSampleRate,
+				Usage:   "Ratio, between 0 and 1, of allowed requests written to the audit log. Denied requests are always written",
+				EnvVars: []string{strcase.ToSNAKE(flagAuthServerAuditSuccessSampleRate)},
+				Value:   1,
+			},
+			&cli.IntFlag{
+				Name:    flagAuthServerAuditMaxSize,
+				Usage:   "Size in megabytes after which the audit log file is rotated. The file is never rotated when set to 0",
+				EnvVars: []string{strcase.ToSNAKE(flagAuthServerAuditMaxSize)},
+				Value:   100,
+			},
+			&cli.IntFlag{
+				Name:    flagAuthServerAuditMaxBackups,
+				Usage:   "Number of rotated audit log files kept",
+				EnvVars: []string{strcase.ToSNAKE(flagAuthServerAuditMaxBackups)},
+				Value:   5,
+			},
+			&cli.BoolFlag{
+				Name:    flagACMEEnabled,
+				Usage:   "Enable obtaining the certificates of the custom domains of edge ingresses with ACME",
+				EnvVars: []string{strcase.ToSNAKE(flagACMEEnabled)},
+			},
+			&cli.StringFlag{
+				Name:    flagACMEDirectoryURL,
+				Usage:   "URL of the ACME directory",
+				EnvVars: []string{strcase.ToSNAKE(flagACMEDirectoryURL)},
+				Value:   acme.LetsEncryptURL,
+			},
+			&cli.StringFlag{
+				Name:    flagACMEDirectoryCA,
+				Usage:   "Path to the PEM encoded CA used to verify the ACME directory, in addition to the system ones",
+				EnvVars: []string{strcase.ToSNAKE(flagACMEDirectoryCA)},
+			},
+			&cli.StringFlag{
+				Name:    flagACMEEmail,
+				Usage:   "Email address of the ACME account",
+				EnvVars: []string{strcase.ToSNAKE(flagACMEEmail)},
+			},
+			&cli.StringFlag{
+				Name:    flagACMEStorageDir,
+				Usage:   "Directory in which the ACME account key and certificates are stored",
+				EnvVars: []string{strcase.ToSNAKE(flagACMEStorageDir)},
+				Value:   "/var/lib/hub-agent-traefik/acme",
+			},
+			&cli.StringFlag{
+				Name:    flagACMEChallenge,
+				Usage:   "ACME challenge used to validate the custom domains: either http-01 or tls-alpn-01. Certificates still valid are renewed with http-01",
+				EnvVars: []string{strcase.ToSNAKE(flagACMEChallenge)},
+				Value:   acme.ChallengeHTTP01,
+			},
+			&cli.StringFlag{
+				Name:    flagACMEListenAddr,
+				Usage:   "Address on which the agent serves the ACME challenges",
+				EnvVars: []string{strcase.ToSNAKE(flagACMEListenAddr)},
+				Value:   "0.0.0.0:8080",
+			},
+			&cli.StringFlag{
+				Name:    flagACMEAdvertiseURL,
+				Usage:   "Address on which Traefik can reach the ACME challenges served by the Agent. Required when the automatic IP discovery fails",
+				EnvVars: []string{strcase.ToSNAKE(flagACMEAdvertiseURL)},
+			},
+			&cli.StringFlag{
+				Name:    flagMetricsListenAddr,
+				Usage:   "Address on which the agent exposes its own metrics in the Prometheus format. Disabled when empty",
+				EnvVars: []string{strcase.ToSNAKE(flagMetricsListenAddr)},
+			},
 			&cli.StringFlag{
 				Name:     flagTraefikTLSCA,
 				Usage:    "Path to the certificate authority which signed TLS credentials",
@@ -249,7 +350,32 @@ func (r runCmd) runAgent(cliCtx *cli.Context) error {
 
 	log.Info().Str("addr", reachableURL).Msg("Using Agent reachable address")
 
-	acpServer := acp.NewServer(listenAddr)
+	checker := health.NewChecker()
+	checker.Register(componentACPs)
+	checker.Register(componentTraefikConfig)
+	checker.AddCheck(componentTraefik, func(ctx context.Context) error {
+		_, err := traefikClient.GetProviderState(ctx)
+		return err
+	})
+
+	var auditLogger *audit.Logger
+	if cliCtx.Bool(flagAuthServerAuditEnabled) {
+		auditLogger, err = audit.NewLogger(audit.Config{
+			Output:            cliCtx.String(flagAuthServerAuditOutput),
+			SuccessSampleRate: cliCtx.Float64(flagAuthServerAuditSuccessSampleRate),
+			MaxSize:           int64(cliCtx.Int(flagAuthServerAuditMaxSize)) * 1024 * 1024,
+			MaxBackups:        cliCtx.Int(flagAuthServerAuditMaxBackups),
+		})
+		if err != nil {
+			return fmt.Errorf("create audit logger: %w", err)
+		}
+		defer func() { _ = auditLogger.Close() }()
+	}
+
+	acpServer := acp.NewServer(listenAddr, acp.DecisionCacheConfig{
+		Size: cliCtx.Int(flagAuthServerCacheSize),
+		TTL:  cliCtx.Duration(flagAuthServerCacheTTL),
+	}, checker, auditLogger)
 
 	certClient, err := certificate.NewClient(platformURL, token)
 	if err != nil {
@@ -284,6 +410,7 @@ func (r runCmd) runAgent(cliCtx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	metricsMgr.SetScraperHeartbeat(checker.Loop("metrics-scraper", 3*time.Minute))
 
 	edgeClient, err := edge.NewClient(platformURL, token)
 	if err != nil {
@@ -292,12 +419,51 @@ func (r runCmd) runAgent(cliCtx *cli.Context) error {
 
 	hubUIURL := cliCtx.String(flagHubUIURL)
 	edgeUpdater := NewEdgeUpdater(certClient, traefikClient, dockerProvider, reachableURL, hubUIURL, agentCfg.AccessControl.MaxSecuredRoutes)
+	edgeUpdater.SetOnSync(func() {
+		checker.SetReady(componentTraefikConfig)
+	})
+
+	var acmeManager *acme.Manager
+	if cliCtx.Bool(flagACMEEnabled) {
+		acmeManager, err = acme.NewManager(acme.Config{
+			DirectoryURL: cliCtx.String(flagACMEDirectoryURL),
+			DirectoryCA:  cliCtx.String(flagACMEDirectoryCA),
+			Email:        cliCtx.String(flagACMEEmail),
+			StorageDir:   cliCtx.String(flagACMEStorageDir),
+			Challenge:    cliCtx.String(flagACMEChallenge),
+		})
+		if err != nil {
+			return fmt.Errorf("create ACME manager: %w", err)
+		}
+
+		acmeURL := cliCtx.String(flagACMEAdvertiseURL)
+		if acmeURL == "" {
+			acmeURL, err = getAgentReachableAddress(cliCtx.Context, traefikClient, cliCtx.String(flagACMEListenAddr))
+			if err != nil {
+				return fmt.Errorf("get agent ACME reachable address: %w. Consider using the `%s` flag", err, flagACMEAdvertiseURL)
+			}
+		}
+
+		acmeManager.SetOnChange(edgeUpdater.Trigger)
+		edgeUpdater.SetACME(acmeManager, acmeURL)
+	}
 
 	edgeWatcher := edge.NewWatcher(edgeClient, time.Minute)
+	edgeWatcher.SetHeartbeat(checker.Loop("edge-watcher", 3*time.Minute))
 
-	edgeWatcher.AddListener(edgeUpdater.Update)
+	// Once synchronized, ACPs and the Traefik configuration stay ready: on failure, the previous ones keep being used.
+	// The Traefik configuration is updated asynchronously by the edge updater.
+	edgeWatcher.AddListener(func(_ context.Context, ingresses []edge.Ingress, acps []edge.ACP) error {
+		edgeUpdater.SetConfig(ingresses, acps)
+		return nil
+	})
 	edgeWatcher.AddListener(func(_ context.Context, _ []edge.Ingress, acps []edge.ACP) error {
-		return acpServer.UpdateHandler(acps)
+		if err := acpServer.UpdateHandler(acps); err != nil {
+			return err
+		}
+
+		checker.SetReady(componentACPs)
+		return nil
 	})
 
 	tunnelClient, err := tunnel.NewClient(platformURL, token)
@@ -306,6 +472,10 @@ func (r runCmd) runAgent(cliCtx *cli.Context) error {
 	}
 
 	tunnelManager := tunnel.NewManager(tunnelClient, tunnelAddr, token, time.Minute)
+	tunnelManager.SetHeartbeat(checker.Loop("tunnel-manager", 3*time.Minute))
+	checker.AddCheck(componentTunnels, func(_ context.Context) error {
+		return tunnelManager.Ready()
+	})
 
 	heartBeater := heartbeat.NewHeartbeater(platformClient)
 
@@ -316,7 +486,12 @@ func (r runCmd) runAgent(cliCtx *cli.Context) error {
 	})
 
 	group.Go(func() error {
-		return listenDocker(ctx, dockerProvider, store, clusterID)
+		return listenDocker(ctx, dockerProvider, store, clusterID, edgeUpdater.Trigger)
+	})
+
+	group.Go(func() error {
+		edgeUpdater.Run(ctx)
+		return nil
 	})
 
 	group.Go(func() error {
@@ -328,6 +503,27 @@ func (r runCmd) runAgent(cliCtx *cli.Context) error {
 		return acpServer.Run(ctx)
 	})
 
+	if acmeManager != nil {
+		acmeServer := acme.NewServer(cliCtx.String(flagACMEListenAddr), acmeManager)
+
+		group.Go(func() error {
+			acmeManager.Run(ctx)
+			return nil
+		})
+
+		group.Go(func() error {
+			return acmeServer.Run(ctx)
+		})
+	}
+
+	if addr := cliCtx.String(flagMetricsListenAddr); addr != "" {
+		telemetryServer := telemetry.NewServer(addr, telemetry.DefaultRegistry)
+
+		group.Go(func() error {
+			return telemetryServer.Run(ctx)
+		})
+	}
+
 	group.Go(func() error {
 		return metricsMgr.Run(ctx, traefikHost)
 	})
@@ -368,8 +564,12 @@ func createDockerClientOpts(cliCtx *cli.Context) provider.DockerClientOpts {
 	return dcOpts
 }
 
-func listenDocker(ctx context.Context, dockerProvider ProviderWatcher, store *topostore.Store, clusterID string) error {
+// listenDocker writes the topology each time the services change, and calls onChange so the IPs of the edge
+// ingresses services are resolved again.
+func listenDocker(ctx context.Context, dockerProvider ProviderWatcher, store *topostore.Store, clusterID string, onChange func()) error {
 	err := dockerProvider.Watch(ctx, clusterID, func(services map[string]*topology.Service) {
+		onChange()
+
 		cluster := &topology.Cluster{
 			ID: clusterID,
 			Overview: topology.Overview{
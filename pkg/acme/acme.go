@@ -0,0 +1,589 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	xacme "golang.org/x/crypto/acme"
+)
+
+// Challenge types.
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// LetsEncryptURL is the URL of the Let's Encrypt production directory.
+const LetsEncryptURL = xacme.LetsEncryptURL
+
+const (
+	defaultRenewBefore      = 30 * 24 * time.Hour
+	defaultPropagationDelay = 10 * time.Second
+
+	// checkInterval is the interval at which certificates are checked for renewal.
+	checkInterval = 12 * time.Hour
+	// retryInterval is the interval after which obtaining the certificate of a domain is retried after a failure.
+	// It avoids hitting the rate limits of the CA when a domain cannot be validated.
+	retryInterval = time.Hour
+	obtainTimeout = 5 * time.Minute
+)
+
+// Config configures a Manager.
+type Config struct {
+	// DirectoryURL is the URL of the ACME directory. It defaults to the Let's Encrypt production directory.
+	DirectoryURL string
+	// DirectoryCA is the path of a PEM bundle of the CAs trusted to reach the ACME directory, in addition to
+	// the system ones. It allows using a local directory.
+	DirectoryCA string
+	Email       string
+	// StorageDir is the directory the account key and the certificates are stored in.
+	StorageDir string
+	// Challenge is the type of challenge used to validate domains: either http-01, the default, or tls-alpn-01.
+	// Domains with a valid certificate are always renewed with the http-01 challenge.
+	Challenge string
+	// RenewBefore is the duration before their expiry from which certificates are renewed.
+	RenewBefore time.Duration
+	// PropagationDelay is the delay given to Traefik to route a challenge to the agent before accepting it.
+	PropagationDelay time.Duration
+}
+
+// Certificate is a certificate obtained for a domain.
+type Certificate struct {
+	Domain string
+	// Certificate is the PEM encoded certificate chain.
+	Certificate []byte
+	// PrivateKey is the PEM encoded private key.
+	PrivateKey []byte
+	NotAfter   time.Time
+}
+
+// Manager obtains and renews the certificates of a set of domains.
+type Manager struct {
+	client           *xacme.Client
+	storage          storage
+	email            string
+	challenge        string
+	renewBefore      time.Duration
+	propagationDelay time.Duration
+
+	trigger  chan struct{}
+	onChange func()
+
+	mu          sync.RWMutex
+	domains     map[string]struct{}
+	certs       map[string]*Certificate
+	nextAttempt map[string]time.Time
+	registered  bool
+	// httpTokens are the key authorizations of the pending http-01 challenges, indexed by token.
+	httpTokens map[string]string
+	// alpnCerts are the certificates of the pending tls-alpn-01 challenges, indexed by domain.
+	alpnCerts map[string]*tls.Certificate
+}
+
+// NewManager returns a new Manager.
+func NewManager(cfg Config) (*Manager, error) {
+	switch cfg.Challenge {
+	case "":
+		cfg.Challenge = ChallengeHTTP01
+	case ChallengeHTTP01, ChallengeTLSALPN01:
+	default:
+		return nil, fmt.Errorf("unsupported challenge %q", cfg.Challenge)
+	}
+
+	if cfg.StorageDir == "" {
+		return nil, errors.New("a storage directory is required")
+	}
+	if cfg.DirectoryURL == "" {
+		cfg.DirectoryURL = LetsEncryptURL
+	}
+	if cfg.RenewBefore <= 0 {
+		cfg.RenewBefore = defaultRenewBefore
+	}
+	if cfg.PropagationDelay < 0 {
+		return nil, errors.New("propagation delay must not be negative")
+	}
+
+	httpClient, err := newHTTPClient(cfg.DirectoryCA)
+	if err != nil {
+		return nil, fmt.Errorf("create HTTP client: %w", err)
+	}
+
+	store := storage{dir: cfg.StorageDir}
+
+	key, err := store.accountKey()
+	if err != nil {
+		return nil, fmt.Errorf("load account key: %w", err)
+	}
+
+	certs, err := store.loadCertificates()
+	if err != nil {
+		return nil, fmt.Errorf("load certificates: %w", err)
+	}
+
+	return &Manager{
+		client: &xacme.Client{
+			Key:          key,
+			HTTPClient:   httpClient,
+			DirectoryURL: cfg.DirectoryURL,
+			UserAgent:    "hub-agent-traefik",
+		},
+		storage:          store,
+		email:            cfg.Email,
+		challenge:        cfg.Challenge,
+		renewBefore:      cfg.RenewBefore,
+		propagationDelay: cfg.PropagationDelay,
+		trigger:          make(chan struct{}, 1),
+		onChange:         func() {},
+		domains:          make(map[string]struct{}),
+		certs:            certs,
+		nextAttempt:      make(map[string]time.Time),
+		httpTokens:       make(map[string]string),
+		alpnCerts:        make(map[string]*tls.Certificate),
+	}, nil
+}
+
+// SetOnChange sets the function called each time the certificates or the pending tls-alpn-01 challenges change.
+// It must be called before running the manager.
+func (m *Manager) SetOnChange(fn func()) {
+	m.onChange = fn
+}
+
+// Challenge returns the type of challenge used to validate domains.
+func (m *Manager) Challenge() string {
+	return m.challenge
+}
+
+// SetDomains sets the domains certificates must be obtained for.
+func (m *Manager) SetDomains(domains []string) {
+	set := make(map[string]struct{}, len(domains))
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if !ValidDomain(domain) {
+			log.Warn().Str("domain", domain).Msg("Invalid domain, no certificate is obtained for it")
+			continue
+		}
+
+		set[domain] = struct{}{}
+	}
+
+	m.mu.Lock()
+	changed := len(set) != len(m.domains)
+	for domain := range set {
+		if _, ok := m.domains[domain]; !ok {
+			changed = true
+		}
+	}
+	m.domains = set
+	m.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+
+	// Certificates of removed domains must not be served anymore.
+	m.onChange()
+}
+
+// Certificates returns the valid certificates of the domains, sorted by domain.
+func (m *Manager) Certificates() []Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+
+	var certs []Certificate
+	for domain := range m.domains {
+		cert, ok := m.certs[domain]
+		if !ok || !now.Before(cert.NotAfter) {
+			continue
+		}
+
+		certs = append(certs, *cert)
+	}
+
+	sort.Slice(certs, func(i, j int) bool {
+		return certs[i].Domain < certs[j].Domain
+	})
+
+	return certs
+}
+
+// HTTP01Domains returns the domains whose http-01 challenges must be routed to the challenge server, sorted.
+// With the tls-alpn-01 challenge, these are the domains with a valid certificate: they are renewed with the http-01
+// challenge, as passing their TLS connections through to the challenge server would cut their clients off.
+func (m *Manager) HTTP01Domains() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+
+	var domains []string
+	for domain := range m.domains {
+		if m.challengeType(domain, now) == ChallengeHTTP01 {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+
+	return domains
+}
+
+// challengeType returns the type of challenge used to validate the given domain. It must be called with the lock held.
+func (m *Manager) challengeType(domain string, now time.Time) string {
+	if cert, ok := m.certs[domain]; ok && m.challenge == ChallengeTLSALPN01 && now.Before(cert.NotAfter) {
+		return ChallengeHTTP01
+	}
+
+	return m.challenge
+}
+
+// PendingTLSALPNDomains returns the domains with a pending tls-alpn-01 challenge, sorted.
+// TLS connections to these domains must be passed through to the challenge server.
+func (m *Manager) PendingTLSALPNDomains() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	domains := make([]string, 0, len(m.alpnCerts))
+	for domain := range m.alpnCerts {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	return domains
+}
+
+// Run obtains the certificates of new domains, and renews the ones expiring soon, until the given context is canceled.
+func (m *Manager) Run(ctx context.Context) {
+	t := time.NewTicker(checkInterval)
+	defer t.Stop()
+
+	for {
+		m.obtainCertificates(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.trigger:
+		case <-t.C:
+		}
+	}
+}
+
+func (m *Manager) obtainCertificates(ctx context.Context) {
+	now := time.Now()
+
+	m.mu.RLock()
+	var domains []string
+	for domain := range m.domains {
+		if cert, ok := m.certs[domain]; ok && now.Before(cert.NotAfter.Add(-m.renewBefore)) {
+			continue
+		}
+		if now.Before(m.nextAttempt[domain]) {
+			continue
+		}
+
+		domains = append(domains, domain)
+	}
+	m.mu.RUnlock()
+
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		logger := log.With().Str("domain", domain).Logger()
+		logger.Info().Msg("Obtaining certificate")
+
+		cert, err := m.obtain(logger.WithContext(ctx), domain)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			logger.Error().Err(err).Msg("Unable to obtain certificate")
+
+			m.mu.Lock()
+			m.nextAttempt[domain] = time.Now().Add(retryInterval)
+			m.mu.Unlock()
+			continue
+		}
+
+		if err = m.storage.saveCertificate(cert); err != nil {
+			logger.Error().Err(err).Msg("Unable to store certificate")
+		}
+
+		m.mu.Lock()
+		m.certs[domain] = cert
+		delete(m.nextAttempt, domain)
+		m.mu.Unlock()
+
+		logger.Info().Time("not_after", cert.NotAfter).Msg("Certificate obtained")
+
+		m.onChange()
+	}
+}
+
+// obtain obtains a certificate for the given domain.
+func (m *Manager) obtain(ctx context.Context, domain string) (*Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, obtainTimeout)
+	defer cancel()
+
+	if err := m.register(ctx); err != nil {
+		return nil, fmt.Errorf("register account: %w", err)
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, xacme.DomainIDs(domain))
+	if err != nil {
+		return nil, fmt.Errorf("authorize order: %w", err)
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		if err = m.authorize(ctx, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("wait order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate private key: %w", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate request: %w", err)
+	}
+
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	return &Certificate{
+		Domain:      domain,
+		Certificate: certPEM,
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		NotAfter:    leaf.NotAfter,
+	}, nil
+}
+
+// register registers the ACME account, unless it is already registered.
+func (m *Manager) register(ctx context.Context) error {
+	m.mu.RLock()
+	registered := m.registered
+	m.mu.RUnlock()
+
+	if registered {
+		return nil
+	}
+
+	var contact []string
+	if m.email != "" {
+		contact = []string{"mailto:" + m.email}
+	}
+
+	_, err := m.client.Register(ctx, &xacme.Account{Contact: contact}, xacme.AcceptTOS)
+	if err != nil && !errors.Is(err, xacme.ErrAccountAlreadyExists) {
+		return err
+	}
+
+	m.mu.Lock()
+	m.registered = true
+	m.mu.Unlock()
+
+	return nil
+}
+
+// authorize fulfills the challenge of the given authorization, unless it is already valid.
+func (m *Manager) authorize(ctx context.Context, authzURL string) error {
+	authz, err := m.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("get authorization: %w", err)
+	}
+
+	if authz.Status == xacme.StatusValid {
+		return nil
+	}
+
+	m.mu.RLock()
+	challengeType := m.challengeType(authz.Identifier.Value, time.Now())
+	m.mu.RUnlock()
+
+	var challenge *xacme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == challengeType {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no %s challenge offered for %q", challengeType, authz.Identifier.Value)
+	}
+
+	cleanup, err := m.setChallenge(challengeType, authz.Identifier.Value, challenge.Token)
+	if err != nil {
+		return fmt.Errorf("set challenge: %w", err)
+	}
+	defer cleanup()
+
+	if m.propagationDelay > 0 {
+		timer := time.NewTimer(m.propagationDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if _, err = m.client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("accept challenge: %w", err)
+	}
+
+	if _, err = m.client.WaitAuthorization(ctx, authzURL); err != nil {
+		return fmt.Errorf("wait authorization: %w", err)
+	}
+
+	return nil
+}
+
+// setChallenge makes the challenge server respond to the given challenge. It returns a function removing it.
+func (m *Manager) setChallenge(challengeType, domain, token string) (func(), error) {
+	switch challengeType {
+	case ChallengeTLSALPN01:
+		cert, err := m.client.TLSALPN01ChallengeCert(token, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		m.mu.Lock()
+		m.alpnCerts[domain] = &cert
+		m.mu.Unlock()
+
+		// Traefik must pass the TLS connections of the domain through to the challenge server.
+		m.onChange()
+
+		return func() {
+			m.mu.Lock()
+			delete(m.alpnCerts, domain)
+			m.mu.Unlock()
+
+			m.onChange()
+		}, nil
+	default:
+		keyAuth, err := m.client.HTTP01ChallengeResponse(token)
+		if err != nil {
+			return nil, err
+		}
+
+		m.mu.Lock()
+		m.httpTokens[token] = keyAuth
+		m.mu.Unlock()
+
+		return func() {
+			m.mu.Lock()
+			delete(m.httpTokens, token)
+			m.mu.Unlock()
+		}, nil
+	}
+}
+
+func newHTTPClient(caPath string) (*http.Client, error) {
+	if caPath == "" {
+		return http.DefaultClient, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	bundle, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("no valid certificate found in CA bundle")
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+		Timeout: 30 * time.Second,
+	}, nil
+}
+
+// ValidDomain tells whether the given lower case domain can be used in a certificate, as a file name and in a
+// Traefik rule.
+func ValidDomain(domain string) bool {
+	if domain == "" || strings.HasPrefix(domain, ".") || strings.HasPrefix(domain, "-") || strings.Contains(domain, "..") {
+		return false
+	}
+
+	for _, r := range domain {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '.' && r != '-' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,486 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xacme "golang.org/x/crypto/acme"
+)
+
+func TestNewManager(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cfg     Config
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			desc:    "defaults",
+			cfg:     Config{StorageDir: t.TempDir()},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "tls-alpn-01 challenge",
+			cfg:     Config{StorageDir: t.TempDir(), Challenge: ChallengeTLSALPN01},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "unsupported challenge",
+			cfg:     Config{StorageDir: t.TempDir(), Challenge: "dns-01"},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "no storage directory",
+			cfg:     Config{},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "invalid directory CA",
+			cfg:     Config{StorageDir: t.TempDir(), DirectoryCA: "missing.pem"},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewManager(test.cfg)
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestManager_http01(t *testing.T) {
+	srv := newACMEServerMock(t)
+	storageDir := t.TempDir()
+
+	manager, err := NewManager(Config{
+		DirectoryURL: srv.URL + "/directory",
+		DirectoryCA:  srv.caFile,
+		Email:        "admin@example.com",
+		StorageDir:   storageDir,
+	})
+	require.NoError(t, err)
+	srv.validate = httpValidator(manager)
+
+	var changes int
+	manager.SetOnChange(func() { changes++ })
+
+	manager.SetDomains([]string{"Example.com", "invalid/domain"})
+	manager.obtainCertificates(context.Background())
+
+	certs := manager.Certificates()
+	require.Len(t, certs, 1)
+	assert.Equal(t, "example.com", certs[0].Domain)
+	assert.Equal(t, 2, changes)
+
+	_, err = tls.X509KeyPair(certs[0].Certificate, certs[0].PrivateKey)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, srv.issued)
+
+	assert.FileExists(t, filepath.Join(storageDir, "account.key"))
+	assert.FileExists(t, filepath.Join(storageDir, "certificates", "example.com.crt"))
+	assert.FileExists(t, filepath.Join(storageDir, "certificates", "example.com.key"))
+
+	// Valid certificates are not obtained again.
+	manager.obtainCertificates(context.Background())
+	assert.Equal(t, []string{"example.com"}, srv.issued)
+
+	// Stored certificates are loaded on start.
+	manager, err = NewManager(Config{DirectoryURL: srv.URL + "/directory", DirectoryCA: srv.caFile, StorageDir: storageDir})
+	require.NoError(t, err)
+	assert.Empty(t, manager.Certificates())
+
+	manager.SetDomains([]string{"example.com"})
+	assert.Equal(t, certs, manager.Certificates())
+}
+
+func TestManager_tlsALPN01(t *testing.T) {
+	srv := newACMEServerMock(t)
+
+	manager, err := NewManager(Config{
+		DirectoryURL: srv.URL + "/directory",
+		DirectoryCA:  srv.caFile,
+		StorageDir:   t.TempDir(),
+		Challenge:    ChallengeTLSALPN01,
+	})
+	require.NoError(t, err)
+
+	var pendingDomains []string
+	srv.validate = func(domain, token string) bool {
+		pendingDomains = manager.PendingTLSALPNDomains()
+
+		cert, err := manager.getChallengeCertificate(&tls.ClientHelloInfo{
+			ServerName:      domain,
+			SupportedProtos: []string{xacme.ALPNProto},
+		})
+		if err != nil {
+			return false
+		}
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		return err == nil && len(leaf.DNSNames) == 1 && leaf.DNSNames[0] == domain
+	}
+
+	manager.SetDomains([]string{"example.com"})
+	manager.obtainCertificates(context.Background())
+
+	assert.Equal(t, []string{"example.com"}, pendingDomains)
+	assert.Empty(t, manager.PendingTLSALPNDomains())
+	require.Len(t, manager.Certificates(), 1)
+
+	_, err = manager.getChallengeCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	assert.Error(t, err)
+}
+
+func TestManager_renew(t *testing.T) {
+	srv := newACMEServerMock(t)
+	srv.validity = 24 * time.Hour
+
+	manager, err := NewManager(Config{
+		DirectoryURL: srv.URL + "/directory",
+		DirectoryCA:  srv.caFile,
+		StorageDir:   t.TempDir(),
+		RenewBefore:  48 * time.Hour,
+	})
+	require.NoError(t, err)
+	srv.validate = httpValidator(manager)
+
+	manager.SetDomains([]string{"example.com"})
+	manager.obtainCertificates(context.Background())
+	manager.obtainCertificates(context.Background())
+
+	assert.Equal(t, []string{"example.com", "example.com"}, srv.issued)
+}
+
+func TestManager_failedChallenge(t *testing.T) {
+	srv := newACMEServerMock(t)
+	srv.validate = func(_, _ string) bool { return false }
+
+	manager, err := NewManager(Config{
+		DirectoryURL: srv.URL + "/directory",
+		DirectoryCA:  srv.caFile,
+		StorageDir:   t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	manager.SetDomains([]string{"example.com"})
+	manager.obtainCertificates(context.Background())
+
+	assert.Empty(t, manager.Certificates())
+	assert.Equal(t, 1, srv.orders)
+
+	// Failed domains are not retried right away.
+	manager.obtainCertificates(context.Background())
+	assert.Equal(t, 1, srv.orders)
+}
+
+func TestManager_ServeHTTP(t *testing.T) {
+	manager, err := NewManager(Config{StorageDir: t.TempDir()})
+	require.NoError(t, err)
+
+	manager.httpTokens["token"] = "token.thumbprint"
+
+	tests := []struct {
+		desc     string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			desc:     "pending challenge",
+			path:     "/.well-known/acme-challenge/token",
+			wantCode: http.StatusOK,
+			wantBody: "token.thumbprint",
+		},
+		{
+			desc:     "unknown token",
+			path:     "/.well-known/acme-challenge/unknown",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			desc:     "not a challenge",
+			path:     "/token",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			rec := httptest.NewRecorder()
+			manager.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, http.NoBody))
+
+			assert.Equal(t, test.wantCode, rec.Code)
+			if test.wantBody != "" {
+				assert.Equal(t, test.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+// httpValidator validates http-01 challenges by requesting the challenge server of the given manager.
+func httpValidator(manager *Manager) func(domain, token string) bool {
+	return func(domain, token string) bool {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://"+domain+httpChallengePrefix+token, http.NoBody)
+		manager.ServeHTTP(rec, req)
+
+		return rec.Code == http.StatusOK && strings.HasPrefix(rec.Body.String(), token+".")
+	}
+}
+
+// acmeServerMock is a minimal ACME server. It doesn't verify the request signatures.
+type acmeServerMock struct {
+	*httptest.Server
+
+	caFile   string
+	caCert   *x509.Certificate
+	caKey    *ecdsa.PrivateKey
+	validity time.Duration
+	validate func(domain, token string) bool
+
+	mu     sync.Mutex
+	orders int
+	domain string
+	authz  string
+	order  string
+	cert   []byte
+	issued []string
+}
+
+func newACMEServerMock(t *testing.T) *acmeServerMock {
+	t.Helper()
+
+	s := &acmeServerMock{validity: 90 * 24 * time.Hour}
+
+	var err error
+	s.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ACME mock CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &s.caKey.PublicKey, s.caKey)
+	require.NoError(t, err)
+	s.caCert, err = x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", func(rw http.ResponseWriter, req *http.Request) {
+		s.writeJSON(rw, http.StatusOK, map[string]string{
+			"newNonce":   s.URL + "/nonce",
+			"newAccount": s.URL + "/account",
+			"newOrder":   s.URL + "/order",
+			"revokeCert": s.URL + "/revoke",
+			"keyChange":  s.URL + "/key-change",
+		})
+	})
+	mux.HandleFunc("/nonce", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Replay-Nonce", "nonce")
+	})
+	mux.HandleFunc("/account", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Location", s.URL+"/account/1")
+		s.writeJSON(rw, http.StatusCreated, map[string]string{"status": "valid"})
+	})
+	mux.HandleFunc("/order", func(rw http.ResponseWriter, req *http.Request) {
+		var payload struct {
+			Identifiers []struct {
+				Value string
+			}
+		}
+		if !readJWS(t, req, &payload) {
+			http.Error(rw, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.orders++
+		s.domain = payload.Identifiers[0].Value
+		s.authz = xacme.StatusPending
+		s.order = xacme.StatusPending
+		s.mu.Unlock()
+
+		rw.Header().Set("Location", s.URL+"/order/1")
+		s.writeJSON(rw, http.StatusCreated, s.orderJSON())
+	})
+	mux.HandleFunc("/order/1", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Location", s.URL+"/order/1")
+		s.writeJSON(rw, http.StatusOK, s.orderJSON())
+	})
+	mux.HandleFunc("/authz/1", func(rw http.ResponseWriter, req *http.Request) {
+		s.writeJSON(rw, http.StatusOK, s.authzJSON())
+	})
+	mux.HandleFunc("/challenge/1", func(rw http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		domain := s.domain
+		s.mu.Unlock()
+
+		valid := s.validate(domain, "token")
+
+		s.mu.Lock()
+		if valid {
+			s.authz = xacme.StatusValid
+			s.order = xacme.StatusReady
+		} else {
+			s.authz = xacme.StatusInvalid
+			s.order = xacme.StatusInvalid
+		}
+		s.mu.Unlock()
+
+		s.writeJSON(rw, http.StatusOK, map[string]string{"status": xacme.StatusProcessing, "url": s.URL + "/challenge/1", "token": "token"})
+	})
+	mux.HandleFunc("/finalize/1", func(rw http.ResponseWriter, req *http.Request) {
+		var payload struct {
+			CSR string
+		}
+		if !readJWS(t, req, &payload) {
+			http.Error(rw, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+		require.NoError(t, err)
+		csr, err := x509.ParseCertificateRequest(der)
+		require.NoError(t, err)
+
+		certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(s.validity),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, s.caCert, csr.PublicKey, s.caKey)
+		require.NoError(t, err)
+
+		s.mu.Lock()
+		s.order = xacme.StatusValid
+		s.cert = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})...)
+		s.issued = append(s.issued, csr.DNSNames...)
+		s.mu.Unlock()
+
+		rw.Header().Set("Location", s.URL+"/order/1")
+		s.writeJSON(rw, http.StatusOK, s.orderJSON())
+	})
+	mux.HandleFunc("/certificate/1", func(rw http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		rw.Header().Set("Replay-Nonce", "nonce")
+		rw.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = rw.Write(s.cert)
+	})
+
+	s.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(s.Close)
+
+	s.caFile = filepath.Join(t.TempDir(), "ca.pem")
+	err = os.WriteFile(s.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0o600)
+	require.NoError(t, err)
+
+	return s
+}
+
+func (s *acmeServerMock) orderJSON() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order := map[string]interface{}{
+		"status":         s.order,
+		"identifiers":    []map[string]string{{"type": "dns", "value": s.domain}},
+		"authorizations": []string{s.URL + "/authz/1"},
+		"finalize":       s.URL + "/finalize/1",
+	}
+	if s.order == xacme.StatusValid {
+		order["certificate"] = s.URL + "/certificate/1"
+	}
+
+	return order
+}
+
+func (s *acmeServerMock) authzJSON() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return map[string]interface{}{
+		"status":     s.authz,
+		"identifier": map[string]string{"type": "dns", "value": s.domain},
+		"challenges": []map[string]string{
+			{"type": ChallengeHTTP01, "url": s.URL + "/challenge/1", "token": "token", "status": s.authz},
+			{"type": ChallengeTLSALPN01, "url": s.URL + "/challenge/1", "token": "token", "status": s.authz},
+		},
+	}
+}
+
+func (s *acmeServerMock) writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Replay-Nonce", "nonce")
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+// readJWS reads the payload of the JWS request.
+func readJWS(t *testing.T, req *http.Request, payload interface{}) bool {
+	t.Helper()
+
+	var jws struct {
+		Payload string
+	}
+	if err := json.NewDecoder(req.Body).Decode(&jws); err != nil {
+		return false
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(b, payload) == nil
+}
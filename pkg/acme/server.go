@@ -0,0 +1,254 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package acme
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	xacme "golang.org/x/crypto/acme"
+)
+
+const httpChallengePrefix = "/.well-known/acme-challenge/"
+
+// tlsHandshakeRecord is the first byte of TLS connections: the type of the record holding the ClientHello.
+const tlsHandshakeRecord = 0x16
+
+// sniffTimeout is the maximum duration to wait for the first byte of a connection.
+const sniffTimeout = 5 * time.Second
+
+// Server serves the challenges of a Manager. It serves HTTP for http-01 challenges and, when the Manager uses
+// tls-alpn-01 challenges, TLS on the same address, as certificates still valid are renewed with http-01 challenges.
+type Server struct {
+	listenAddr string
+	manager    *Manager
+}
+
+// NewServer returns a new Server.
+func NewServer(listenAddr string, manager *Manager) *Server {
+	return &Server{
+		listenAddr: listenAddr,
+		manager:    manager,
+	}
+}
+
+// Run runs the server until the given context is canceled.
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("listen on %q: %w", s.listenAddr, err)
+	}
+
+	if s.manager.Challenge() == ChallengeTLSALPN01 {
+		ln = newChallengeListener(ln, &tls.Config{
+			NextProtos:     []string{xacme.ALPNProto},
+			GetCertificate: s.manager.getChallengeCertificate,
+			MinVersion:     tls.VersionTLS12,
+		})
+	}
+
+	server := &http.Server{
+		Handler:  s.manager,
+		ErrorLog: stdlog.New(log.Logger.Level(zerolog.DebugLevel), "", 0),
+	}
+
+	srvDone := make(chan struct{})
+
+	go func() {
+		log.Info().Str("addr", s.listenAddr).Str("challenge", s.manager.Challenge()).Msg("Starting ACME challenge server")
+		if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			log.Err(err).Msg("Unable to listen and serve ACME challenges")
+		}
+		close(srvDone)
+	}()
+
+	select {
+	case <-ctx.Done():
+		gracefulCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		//nolint:contextcheck // False positive.
+		if err := server.Shutdown(gracefulCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to shutdown ACME challenge server gracefully")
+			if err = server.Close(); err != nil {
+				return fmt.Errorf("close ACME challenge server: %w", err)
+			}
+		}
+
+		return nil
+	case <-srvDone:
+		return errors.New("ACME challenge server stopped")
+	}
+}
+
+// challengeListener is a net.Listener accepting both plain and TLS connections. Connections starting with a TLS
+// handshake are returned as TLS server connections, the other ones as they are.
+type challengeListener struct {
+	net.Listener
+	tlsConfig *tls.Config
+
+	conns     chan net.Conn
+	errs      chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newChallengeListener(ln net.Listener, tlsConfig *tls.Config) *challengeListener {
+	l := &challengeListener{
+		Listener:  ln,
+		tlsConfig: tlsConfig,
+		conns:     make(chan net.Conn),
+		errs:      make(chan error),
+		closed:    make(chan struct{}),
+	}
+
+	go l.accept()
+
+	return l
+}
+
+// Accept waits for and returns the next connection whose protocol is known.
+func (l *challengeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener.
+func (l *challengeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+
+	return l.Listener.Close()
+}
+
+// accept accepts the connections of the underlying listener until it is closed. The protocol of each connection is
+// detected in its own goroutine, so slow clients don't block the other ones.
+func (l *challengeListener) accept() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.closed:
+				return
+			}
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				continue
+			}
+
+			return
+		}
+
+		go l.detect(conn)
+	}
+}
+
+// detect reads the first byte of the given connection to know whether it is a TLS connection.
+func (l *challengeListener) detect(conn net.Conn) {
+	r := bufio.NewReader(conn)
+
+	_ = conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	first, err := r.Peek(1)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	var c net.Conn = &peekedConn{Conn: conn, r: r}
+	if first[0] == tlsHandshakeRecord {
+		c = tls.Server(c, l.tlsConfig)
+	}
+
+	select {
+	case l.conns <- c:
+	case <-l.closed:
+		_ = c.Close()
+	}
+}
+
+// peekedConn is a net.Conn whose first bytes were read ahead.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// ServeHTTP serves the key authorizations of the pending http-01 challenges.
+func (m *Manager) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.URL.Path, httpChallengePrefix) {
+		http.NotFound(rw, req)
+		return
+	}
+
+	m.mu.RLock()
+	keyAuth, ok := m.httpTokens[strings.TrimPrefix(req.URL.Path, httpChallengePrefix)]
+	m.mu.RUnlock()
+
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/plain")
+	_, _ = rw.Write([]byte(keyAuth))
+}
+
+// getChallengeCertificate returns the certificate of the pending tls-alpn-01 challenge of the requested domain.
+func (m *Manager) getChallengeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	var alpn bool
+	for _, proto := range hello.SupportedProtos {
+		if proto == xacme.ALPNProto {
+			alpn = true
+		}
+	}
+	if !alpn {
+		return nil, errors.New("only tls-alpn-01 challenges are served")
+	}
+
+	m.mu.RLock()
+	cert, ok := m.alpnCerts[strings.ToLower(hello.ServerName)]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, errors.New("no pending challenge")
+	}
+
+	return cert, nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xacme "golang.org/x/crypto/acme"
+)
+
+func TestServer_tlsALPN01_renew(t *testing.T) {
+	srv := newACMEServerMock(t)
+	srv.validity = 24 * time.Hour
+
+	manager, err := NewManager(Config{
+		DirectoryURL: srv.URL + "/directory",
+		DirectoryCA:  srv.caFile,
+		StorageDir:   t.TempDir(),
+		Challenge:    ChallengeTLSALPN01,
+		RenewBefore:  48 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	addr := startServer(t, manager)
+
+	var challenges []string
+	srv.validate = func(domain, token string) bool {
+		if len(manager.PendingTLSALPNDomains()) > 0 {
+			challenges = append(challenges, ChallengeTLSALPN01)
+			return validateTLSALPN(addr, domain)
+		}
+
+		challenges = append(challenges, ChallengeHTTP01)
+		return validateHTTP(addr, domain, token)
+	}
+
+	manager.SetDomains([]string{"example.com"})
+	assert.Empty(t, manager.HTTP01Domains())
+
+	manager.obtainCertificates(context.Background())
+	assert.Equal(t, []string{"example.com"}, manager.HTTP01Domains())
+
+	// The domain keeps being served while its certificate is renewed, through the address serving the tls-alpn-01
+	// challenges.
+	manager.obtainCertificates(context.Background())
+
+	assert.Equal(t, []string{ChallengeTLSALPN01, ChallengeHTTP01}, challenges)
+	assert.Equal(t, []string{"example.com", "example.com"}, srv.issued)
+}
+
+// startServer starts a Server serving the challenges of the given manager and returns its address.
+func startServer(t *testing.T, manager *Manager) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	go func() {
+		defer close(done)
+		_ = NewServer(addr, manager).Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	return addr
+}
+
+// validateTLSALPN validates the tls-alpn-01 challenge of the given domain by connecting to the challenge server.
+func validateTLSALPN(addr, domain string) bool {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{xacme.ALPNProto},
+		InsecureSkipVerify: true, //nolint:gosec // The challenge certificate is self-signed.
+	})
+	if err != nil {
+		return false
+	}
+	defer func() { _ = conn.Close() }()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != xacme.ALPNProto || len(state.PeerCertificates) == 0 {
+		return false
+	}
+
+	dnsNames := state.PeerCertificates[0].DNSNames
+	return len(dnsNames) == 1 && dnsNames[0] == domain
+}
+
+// validateHTTP validates the http-01 challenge of the given domain by requesting the challenge server.
+func validateHTTP(addr, domain, token string) bool {
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+httpChallengePrefix+token, http.NoBody)
+	if err != nil {
+		return false
+	}
+	req.Host = domain
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false
+	}
+
+	return resp.StatusCode == http.StatusOK && strings.HasPrefix(string(body), token+".")
+}
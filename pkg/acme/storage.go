@@ -0,0 +1,171 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	accountKeyFile  = "account.key"
+	certificatesDir = "certificates"
+)
+
+// storage stores the account key and the certificates on disk.
+type storage struct {
+	dir string
+}
+
+// accountKey returns the account key, which is generated on first use.
+func (s storage) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(s.dir, accountKeyFile)
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM data found in %q", path)
+		}
+
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal key: %w", err)
+	}
+
+	if err = os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	if err = writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// loadCertificates loads the stored certificates, indexed by domain. Invalid certificates are ignored.
+func (s storage) loadCertificates() (map[string]*Certificate, error) {
+	certs := make(map[string]*Certificate)
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, certificatesDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return certs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".crt") {
+			continue
+		}
+
+		domain := strings.TrimSuffix(entry.Name(), ".crt")
+
+		cert, err := s.loadCertificate(domain)
+		if err != nil {
+			log.Warn().Err(err).Str("domain", domain).Msg("Unable to load stored certificate")
+			continue
+		}
+
+		certs[domain] = cert
+	}
+
+	return certs, nil
+}
+
+func (s storage) loadCertificate(domain string) (*Certificate, error) {
+	certPEM, err := os.ReadFile(filepath.Join(s.dir, certificatesDir, domain+".crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := os.ReadFile(filepath.Join(s.dir, certificatesDir, domain+".key"))
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse key pair: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+
+	return &Certificate{
+		Domain:      domain,
+		Certificate: certPEM,
+		PrivateKey:  keyPEM,
+		NotAfter:    leaf.NotAfter,
+	}, nil
+}
+
+// saveCertificate stores the given certificate, replacing the previous one of its domain.
+func (s storage) saveCertificate(cert *Certificate) error {
+	dir := filepath.Join(s.dir, certificatesDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	if err := writeFile(filepath.Join(dir, cert.Domain+".key"), cert.PrivateKey); err != nil {
+		return fmt.Errorf("write private key: %w", err)
+	}
+
+	if err := writeFile(filepath.Join(dir, cert.Domain+".crt"), cert.Certificate); err != nil {
+		return fmt.Errorf("write certificate: %w", err)
+	}
+
+	return nil
+}
+
+// writeFile atomically writes the given data to the given path, so a crash never leaves a partial file.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
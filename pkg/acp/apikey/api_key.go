@@ -0,0 +1,194 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package apikey
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+const (
+	defaultHeader = "X-Api-Key"
+	hashPrefix    = "$sha256$"
+)
+
+// Handler is an API key ACP Handler.
+type Handler struct {
+	name string
+
+	header     string
+	query      string
+	keys       []apiKey
+	fwdHeaders map[string]string
+}
+
+// apiKey is an allowed API key.
+type apiKey struct {
+	id       string
+	salt     []byte
+	hash     []byte
+	metadata map[string]string
+}
+
+// NewHandler returns a new API key ACP Handler.
+func NewHandler(cfg *edge.ACPAPIKeyConfig, polName string) (*Handler, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("at least an API key is required")
+	}
+
+	header := cfg.Header
+	if header == "" && cfg.Query == "" {
+		header = defaultHeader
+	}
+
+	ids := make(map[string]struct{}, len(cfg.Keys))
+	keys := make([]apiKey, 0, len(cfg.Keys))
+	for _, k := range cfg.Keys {
+		if k.ID == "" {
+			return nil, errors.New("API key ID is required")
+		}
+		if _, ok := ids[k.ID]; ok {
+			return nil, fmt.Errorf("duplicated API key ID %q", k.ID)
+		}
+		ids[k.ID] = struct{}{}
+
+		salt, hash, err := parseHash(k.Value)
+		if err != nil {
+			return nil, fmt.Errorf("parse API key %q: %w", k.ID, err)
+		}
+
+		keys = append(keys, apiKey{
+			id:       k.ID,
+			salt:     salt,
+			hash:     hash,
+			metadata: k.Metadata,
+		})
+	}
+
+	return &Handler{
+		name:       polName,
+		header:     header,
+		query:      cfg.Query,
+		keys:       keys,
+		fwdHeaders: cfg.ForwardHeaders,
+	}, nil
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	logger := log.With().Str("handler_type", "APIKey").Str("handler_name", h.name).Logger()
+
+	rawKey := h.extractKey(req)
+	if rawKey == "" {
+		logger.Debug().Msg("No API key found in request")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	key, ok := h.findKey(rawKey)
+	if !ok {
+		logger.Debug().Msg("Unknown API key")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	for name, metadata := range h.fwdHeaders {
+		if val, ok := key.metadata[metadata]; ok {
+			rw.Header().Set(name, val)
+		}
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// Credential returns the API key of the given request.
+func (h *Handler) Credential(req *http.Request) (string, time.Time, bool) {
+	rawKey := h.extractKey(req)
+
+	return rawKey, time.Time{}, rawKey != ""
+}
+
+// extractKey extracts the API key from the configured header, or from the configured query parameter.
+func (h *Handler) extractKey(req *http.Request) string {
+	if h.header != "" {
+		if key := req.Header.Get(h.header); key != "" {
+			return key
+		}
+	}
+
+	if h.query != "" {
+		return req.URL.Query().Get(h.query)
+	}
+
+	return ""
+}
+
+// findKey returns the allowed API key matching the given raw key.
+func (h *Handler) findKey(rawKey string) (apiKey, bool) {
+	var (
+		found apiKey
+		ok    bool
+	)
+	// All keys are checked to avoid leaking, through timing, which key matched.
+	for _, k := range h.keys {
+		sum := sha256.Sum256(append(append([]byte{}, k.salt...), rawKey...))
+		if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 && !ok {
+			found, ok = k, true
+		}
+	}
+
+	return found, ok
+}
+
+// parseHash parses a salted hash formatted as `$sha256$<base64 salt>$<base64 hash>`.
+func parseHash(value string) (salt, hash []byte, err error) {
+	if !strings.HasPrefix(value, hashPrefix) {
+		return nil, nil, fmt.Errorf("unsupported hash format, expected %q prefix", hashPrefix)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, hashPrefix), "$")
+	if len(parts) != 2 {
+		return nil, nil, errors.New("malformed hash")
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode salt: %w", err)
+	}
+	if len(salt) == 0 {
+		return nil, nil, errors.New("empty salt")
+	}
+
+	hash, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode hash: %w", err)
+	}
+	if len(hash) != sha256.Size {
+		return nil, nil, fmt.Errorf("invalid hash length %d", len(hash))
+	}
+
+	return salt, hash, nil
+}
@@ -0,0 +1,183 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+func TestNewHandler(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cfg     edge.ACPAPIKeyConfig
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			desc:    "valid",
+			cfg:     edge.ACPAPIKeyConfig{Keys: []edge.ACPAPIKey{{ID: "bob", Value: hashKey("salt", "secret")}}},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "no keys",
+			cfg:     edge.ACPAPIKeyConfig{},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "missing ID",
+			cfg:     edge.ACPAPIKeyConfig{Keys: []edge.ACPAPIKey{{Value: hashKey("salt", "secret")}}},
+			wantErr: assert.Error,
+		},
+		{
+			desc: "duplicated ID",
+			cfg: edge.ACPAPIKeyConfig{Keys: []edge.ACPAPIKey{
+				{ID: "bob", Value: hashKey("salt", "secret")},
+				{ID: "bob", Value: hashKey("salt", "other")},
+			}},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "plaintext key",
+			cfg:     edge.ACPAPIKeyConfig{Keys: []edge.ACPAPIKey{{ID: "bob", Value: "secret"}}},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "missing salt",
+			cfg:     edge.ACPAPIKeyConfig{Keys: []edge.ACPAPIKey{{ID: "bob", Value: "$sha256$$" + base64.RawStdEncoding.EncodeToString(make([]byte, sha256.Size))}}},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "truncated hash",
+			cfg:     edge.ACPAPIKeyConfig{Keys: []edge.ACPAPIKey{{ID: "bob", Value: "$sha256$c2FsdA$AAAA"}}},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewHandler(&test.cfg, "acp@my-ns")
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	keys := []edge.ACPAPIKey{
+		{ID: "bob", Value: hashKey("salt-1", "bob-secret"), Metadata: map[string]string{"owner": "bob", "tier": "gold"}},
+		{ID: "alice", Value: hashKey("salt-2", "alice-secret"), Metadata: map[string]string{"owner": "alice"}},
+	}
+	fwdHeaders := map[string]string{"X-Owner": "owner", "X-Tier": "tier"}
+
+	tests := []struct {
+		desc           string
+		cfg            edge.ACPAPIKeyConfig
+		header         http.Header
+		target         string
+		wantStatusCode int
+		wantHeaders    http.Header
+	}{
+		{
+			desc:           "key in default header",
+			cfg:            edge.ACPAPIKeyConfig{Keys: keys, ForwardHeaders: fwdHeaders},
+			header:         http.Header{"X-Api-Key": {"bob-secret"}},
+			target:         "/",
+			wantStatusCode: http.StatusOK,
+			wantHeaders:    http.Header{"X-Owner": {"bob"}, "X-Tier": {"gold"}},
+		},
+		{
+			desc:           "missing metadata is not forwarded",
+			cfg:            edge.ACPAPIKeyConfig{Keys: keys, ForwardHeaders: fwdHeaders},
+			header:         http.Header{"X-Api-Key": {"alice-secret"}},
+			target:         "/",
+			wantStatusCode: http.StatusOK,
+			wantHeaders:    http.Header{"X-Owner": {"alice"}},
+		},
+		{
+			desc:           "key in custom header",
+			cfg:            edge.ACPAPIKeyConfig{Header: "Api-Token", Keys: keys},
+			header:         http.Header{"Api-Token": {"bob-secret"}},
+			target:         "/",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc:           "key in query parameter",
+			cfg:            edge.ACPAPIKeyConfig{Query: "api_key", Keys: keys},
+			target:         "/?api_key=alice-secret",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc:           "default header is ignored when a query parameter is configured",
+			cfg:            edge.ACPAPIKeyConfig{Query: "api_key", Keys: keys},
+			header:         http.Header{"X-Api-Key": {"bob-secret"}},
+			target:         "/",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "unknown key",
+			cfg:            edge.ACPAPIKeyConfig{Keys: keys},
+			header:         http.Header{"X-Api-Key": {"unknown"}},
+			target:         "/",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "no key",
+			cfg:            edge.ACPAPIKeyConfig{Keys: keys},
+			target:         "/",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			handler, err := NewHandler(&test.cfg, "acp@my-ns")
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, test.target, http.NoBody)
+			for name, vals := range test.header {
+				req.Header[name] = vals
+			}
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.wantStatusCode, rec.Code)
+			for name := range fwdHeaders {
+				assert.Equal(t, test.wantHeaders.Values(name), rec.Header().Values(name))
+			}
+		})
+	}
+}
+
+func hashKey(salt, key string) string {
+	sum := sha256.Sum256([]byte(salt + key))
+
+	return hashPrefix + base64.RawStdEncoding.EncodeToString([]byte(salt)) + "$" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
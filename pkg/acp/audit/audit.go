@@ -0,0 +1,189 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/ipfilter"
+)
+
+// Reasons of auth decisions.
+const (
+	ReasonAuthenticated      = "authenticated"
+	ReasonMissingCredentials = "missing_credentials"
+	ReasonInvalidCredentials = "invalid_credentials"
+	ReasonInvalidToken       = "invalid_token"
+	ReasonInvalidClaims      = "invalid_claims"
+	ReasonClaimsMismatch     = "claims_mismatch"
+	ReasonLockedOut          = "locked_out"
+)
+
+const (
+	outcomeAllowed = "allowed"
+	outcomeDenied  = "denied"
+)
+
+// redacted replaces the values which could hold secrets.
+const redacted = "REDACTED"
+
+// Config configures a Logger.
+type Config struct {
+	// Output is either "stderr" or the path of the file to write to.
+	Output string
+	// SuccessSampleRate is the ratio, between 0 and 1, of allowed requests which are logged.
+	// Denied requests are always logged.
+	SuccessSampleRate float64
+	// MaxSize is the size in bytes after which the file is rotated. The file is never rotated when zero.
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups int
+}
+
+// Decision is an auth decision taken by an ACP handler.
+type Decision struct {
+	ACP     string
+	Allowed bool
+	Reason  string
+	// Subject is the identity the request authenticated, or attempted to authenticate, as.
+	Subject string
+	// Cached tells whether the decision was served from the decision cache of the auth server.
+	Cached bool
+	// Start is the time at which the handler started to process the request.
+	Start time.Time
+}
+
+// Logger writes a structured audit record per auth decision.
+// A nil Logger discards all decisions.
+type Logger struct {
+	logger     zerolog.Logger
+	sampleRate float64
+	sample     func() float64
+	strategy   *ipfilter.Strategy
+	closer     io.Closer
+}
+
+// NewLogger returns a new Logger.
+func NewLogger(cfg Config) (*Logger, error) {
+	if cfg.SuccessSampleRate < 0 || cfg.SuccessSampleRate > 1 {
+		return nil, errors.New("success sample rate must be between 0 and 1")
+	}
+	if cfg.MaxSize < 0 {
+		return nil, errors.New("max size must not be negative")
+	}
+	if cfg.MaxBackups < 0 {
+		return nil, errors.New("max backups must not be negative")
+	}
+
+	var (
+		w      io.Writer
+		closer io.Closer
+	)
+	switch cfg.Output {
+	case "", "stderr":
+		w = os.Stderr
+	default:
+		file, err := openRotatingFile(cfg.Output, cfg.MaxSize, cfg.MaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log file: %w", err)
+		}
+
+		w, closer = file, file
+	}
+
+	return newLogger(w, cfg.SuccessSampleRate, closer), nil
+}
+
+func newLogger(w io.Writer, sampleRate float64, closer io.Closer) *Logger {
+	// The default strategy can't fail.
+	strategy, _ := ipfilter.NewStrategy(nil)
+
+	return &Logger{
+		logger:     zerolog.New(w).With().Timestamp().Str("type", "audit").Logger(),
+		sampleRate: sampleRate,
+		sample:     rand.Float64,
+		strategy:   strategy,
+		closer:     closer,
+	}
+}
+
+// Log logs the given decision taken for the given request.
+// The request details are read from the headers set by Traefik's ForwardAuth middleware.
+func (l *Logger) Log(req *http.Request, d Decision) {
+	if l == nil {
+		return
+	}
+
+	if d.Allowed && l.sample() >= l.sampleRate {
+		return
+	}
+
+	outcome := outcomeDenied
+	if d.Allowed {
+		outcome = outcomeAllowed
+	}
+
+	l.logger.Log().
+		Str("acp", d.ACP).
+		Str("outcome", outcome).
+		Str("reason", d.Reason).
+		Str("subject", d.Subject).
+		Bool("cached", d.Cached).
+		Str("client_ip", l.strategy.ClientIP(req)).
+		Str("host", req.Header.Get("X-Forwarded-Host")).
+		Str("path", redactURI(req.Header.Get("X-Forwarded-Uri"))).
+		Dur("latency", time.Since(d.Start)).
+		Msg("Auth decision")
+}
+
+// Close closes the audit log file, if any.
+func (l *Logger) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+
+	return l.closer.Close()
+}
+
+// redactURI redacts the query parameter values of the given URI, as they could hold tokens or API keys.
+func redactURI(uri string) string {
+	i := strings.Index(uri, "?")
+	if i < 0 {
+		return uri
+	}
+
+	query, err := url.ParseQuery(uri[i+1:])
+	if err != nil {
+		return uri[:i+1] + redacted
+	}
+
+	for key := range query {
+		query[key] = []string{redacted}
+	}
+
+	return uri[:i+1] + query.Encode()
+}
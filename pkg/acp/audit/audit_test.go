@@ -0,0 +1,170 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cfg     Config
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			desc:    "stderr",
+			cfg:     Config{Output: "stderr", SuccessSampleRate: 0.5},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "sample rate lower than 0",
+			cfg:     Config{SuccessSampleRate: -0.1},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "sample rate greater than 1",
+			cfg:     Config{SuccessSampleRate: 1.1},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "negative max size",
+			cfg:     Config{MaxSize: -1},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "negative max backups",
+			cfg:     Config{MaxBackups: -1},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewLogger(test.cfg)
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestLogger_Log(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(&buf, 1, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	req.Header.Set("X-Forwarded-Host", "example.com")
+	req.Header.Set("X-Forwarded-Uri", "/foo?jwt=secret")
+
+	l.Log(req, Decision{
+		ACP:     "acp@my-ns",
+		Reason:  ReasonClaimsMismatch,
+		Subject: "john",
+		Start:   time.Now().Add(-time.Second),
+	})
+
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+
+	assert.Equal(t, "audit", got["type"])
+	assert.Equal(t, "acp@my-ns", got["acp"])
+	assert.Equal(t, "denied", got["outcome"])
+	assert.Equal(t, ReasonClaimsMismatch, got["reason"])
+	assert.Equal(t, "john", got["subject"])
+	assert.Equal(t, false, got["cached"])
+	assert.Equal(t, "10.0.0.1", got["client_ip"])
+	assert.Equal(t, "example.com", got["host"])
+	assert.Equal(t, "/foo?jwt=REDACTED", got["path"])
+	assert.GreaterOrEqual(t, got["latency"], float64(1000))
+	assert.Contains(t, got, "time")
+}
+
+func TestLogger_Log_sampling(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(&buf, 0.5, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+
+	l.sample = func() float64 { return 0.7 }
+	l.Log(req, Decision{ACP: "acp@my-ns", Allowed: true, Reason: ReasonAuthenticated})
+	assert.Zero(t, buf.Len())
+
+	l.Log(req, Decision{ACP: "acp@my-ns", Reason: ReasonInvalidCredentials})
+	assert.Contains(t, buf.String(), `"outcome":"denied"`)
+	buf.Reset()
+
+	l.sample = func() float64 { return 0.2 }
+	l.Log(req, Decision{ACP: "acp@my-ns", Allowed: true, Reason: ReasonAuthenticated})
+	assert.Contains(t, buf.String(), `"outcome":"allowed"`)
+}
+
+func TestLogger_Log_nil(t *testing.T) {
+	var l *Logger
+
+	l.Log(httptest.NewRequest(http.MethodGet, "/", http.NoBody), Decision{ACP: "acp@my-ns"})
+	assert.NoError(t, l.Close())
+}
+
+func TestRedactURI(t *testing.T) {
+	tests := []struct {
+		desc string
+		uri  string
+		want string
+	}{
+		{
+			desc: "no query",
+			uri:  "/foo/bar",
+			want: "/foo/bar",
+		},
+		{
+			desc: "query parameters",
+			uri:  "/foo?jwt=secret&page=2&page=3",
+			want: "/foo?jwt=REDACTED&page=REDACTED",
+		},
+		{
+			desc: "malformed query",
+			uri:  "/foo?key=%zz",
+			want: "/foo?REDACTED",
+		},
+		{
+			desc: "empty",
+			uri:  "",
+			want: "",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, test.want, redactURI(test.uri))
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	f, err := openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, record := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		_, err = f.Write([]byte(record))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	assertFile(t, path, "dddddd\n")
+	assertFile(t, path+".1", "cccccc\n")
+	assertFile(t, path+".2", "bbbbbb\n")
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestRotatingFile_appends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("aaaaaa\n"), 0o600))
+
+	f, err := openRotatingFile(path, 10, 1)
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte("bbbbbb\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assertFile(t, path, "bbbbbb\n")
+	assertFile(t, path+".1", "aaaaaa\n")
+}
+
+func TestRotatingFile_noBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	f, err := openRotatingFile(path, 10, 0)
+	require.NoError(t, err)
+
+	for _, record := range []string{"aaaaaa\n", "bbbbbb\n"} {
+		_, err = f.Write([]byte(record))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	assertFile(t, path, "bbbbbb\n")
+	assert.NoFileExists(t, path+".1")
+
+	_, err = f.Write([]byte("cccccc\n"))
+	assert.Error(t, err)
+}
+
+func assertFile(t *testing.T, path, want string) {
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, want, string(got))
+}
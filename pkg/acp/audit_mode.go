@@ -0,0 +1,84 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package acp
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DecisionHeader is the header in which ACPs in audit mode forward the decision they would have taken.
+const DecisionHeader = "X-Hub-Acp-Decision"
+
+// auditModeHandler allows all requests, whatever the decision of the ACP handler it wraps. The decision is logged,
+// counted and forwarded in the DecisionHeader header. The headers to forward are only added to allowed requests.
+type auditModeHandler struct {
+	name    string
+	handler http.Handler
+}
+
+func (h *auditModeHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rec := &auditModeRecorder{header: make(http.Header)}
+	h.handler.ServeHTTP(rec, req)
+
+	code := rec.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	decision := outcome(code)
+
+	if code >= 200 && code < 300 {
+		for name, vals := range rec.header {
+			rw.Header()[name] = vals
+		}
+	} else {
+		log.Info().
+			Str("acp_name", h.name).
+			Str("decision", decision).
+			Str("host", req.Header.Get("X-Forwarded-Host")).
+			Msg("Request would have been denied by ACP in audit mode")
+	}
+
+	rw.Header().Set(DecisionHeader, decision)
+	rw.WriteHeader(http.StatusOK)
+}
+
+// auditModeRecorder records the decision of an ACP handler, discarding the response body.
+type auditModeRecorder struct {
+	header http.Header
+	code   int
+}
+
+func (r *auditModeRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *auditModeRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *auditModeRecorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+
+	return len(b), nil
+}
@@ -21,9 +21,13 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	goauth "github.com/abbot/go-http-auth"
 	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/audit"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/denial"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/lockout"
 	"github.com/traefik/hub-agent-traefik/pkg/edge"
 )
 
@@ -38,6 +42,9 @@ type Handler struct {
 	users              map[string]string
 	forwardUsername    string
 	stripAuthorization bool
+	limiter            *lockout.Limiter
+	auditLogger        *audit.Logger
+	denial             *denial.Responder
 	name               string
 }
 
@@ -48,10 +55,19 @@ func NewHandler(cfg *edge.ACPBasicAuthConfig, name string) (*Handler, error) {
 		return nil, err
 	}
 
+	var limiter *lockout.Limiter
+	if cfg.Lockout != nil {
+		limiter, err = lockout.NewLimiter(cfg.Lockout)
+		if err != nil {
+			return nil, fmt.Errorf("create lockout limiter: %w", err)
+		}
+	}
+
 	h := &Handler{
 		users:              users,
 		forwardUsername:    cfg.ForwardUsernameHeader,
 		stripAuthorization: cfg.StripAuthorizationHeader,
+		limiter:            limiter,
 		name:               name,
 	}
 
@@ -65,24 +81,64 @@ func NewHandler(cfg *edge.ACPBasicAuthConfig, name string) (*Handler, error) {
 	return h, nil
 }
 
+// SetAuditLogger sets the logger auditing the decisions of the handler.
+func (h *Handler) SetAuditLogger(l *audit.Logger) {
+	h.auditLogger = l
+}
+
+// SetDenialResponder sets the responder writing the responses denying requests, instead of the realm challenge.
+func (h *Handler) SetDenialResponder(r *denial.Responder) {
+	h.denial = r
+}
+
 func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	start := time.Now()
 	logger := log.With().Str("handler_type", "BasicAuth").Str("handler_name", h.name).Logger()
 
 	username, password, ok := req.BasicAuth()
+
+	if h.limiter != nil {
+		if retryAfter, allowed := h.limiter.Allow(req, username); !allowed {
+			logger.Debug().Msg("Too many failed authentications")
+			h.auditLogger.Log(req, audit.Decision{ACP: h.name, Reason: audit.ReasonLockedOut, Subject: username, Start: start})
+
+			lockout.WriteTooManyRequests(rw, retryAfter)
+			return
+		}
+	}
+
+	reason := audit.ReasonMissingCredentials
 	if ok {
+		reason = audit.ReasonInvalidCredentials
+
 		secret := h.auth.Secrets(username, h.auth.Realm)
-		if secret == "" || !goauth.CheckSecret(password, secret) {
+		if secret == "" || !checkSecret(password, secret) {
 			ok = false
+
+			// Only requests carrying credentials are counted, as browsers first send requests without any.
+			if h.limiter != nil {
+				h.limiter.Fail(req, username)
+			}
 		}
 	}
 
 	if !ok {
 		logger.Debug().Msg("Authentication failed")
+		h.auditLogger.Log(req, audit.Decision{ACP: h.name, Reason: reason, Subject: username, Start: start})
+
+		if h.denial != nil {
+			h.denial.Deny(rw, req, http.StatusUnauthorized)
+			return
+		}
 
 		h.auth.RequireAuth(rw, req)
 		return
 	}
 
+	if h.limiter != nil {
+		h.limiter.Succeed(username)
+	}
+
 	if h.forwardUsername != "" {
 		rw.Header().Set(h.forwardUsername, username)
 	}
@@ -91,9 +147,31 @@ func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		rw.Header().Add("Authorization", "")
 	}
 
+	h.auditLogger.Log(req, audit.Decision{ACP: h.name, Allowed: true, Reason: audit.ReasonAuthenticated, Subject: username, Start: start})
+
 	rw.WriteHeader(http.StatusOK)
 }
 
+// Credential returns the basic auth credentials of the given request. Decisions must not be reused when failed
+// authentications lock clients out, as locked out clients must be denied whatever their credentials.
+func (h *Handler) Credential(req *http.Request) (string, time.Time, bool) {
+	if h.limiter != nil {
+		return "", time.Time{}, false
+	}
+
+	if _, _, ok := req.BasicAuth(); !ok {
+		return "", time.Time{}, false
+	}
+
+	return req.Header.Get("Authorization"), time.Time{}, true
+}
+
+// Subject returns the username of the given request.
+func (h *Handler) Subject(req *http.Request) string {
+	username, _, _ := req.BasicAuth()
+	return username
+}
+
 func (h *Handler) secretBasic(user, _ string) string {
 	if secret, ok := h.users[user]; ok {
 		return secret
@@ -107,6 +185,11 @@ func basicUserParser(user string) (username, password string, err error) {
 	if len(split) != 2 {
 		return "", "", fmt.Errorf("parse BasicUser: %v", user)
 	}
+
+	if err := validateHash(split[1]); err != nil {
+		return "", "", fmt.Errorf("invalid password hash for user %q: %w", split[0], err)
+	}
+
 	return split[0], split[1], nil
 }
 
@@ -120,6 +203,9 @@ func getUsers(users []string, parser userParser) (map[string]string, error) {
 		if err != nil {
 			return nil, err
 		}
+		if _, ok := userMap[userName]; ok {
+			return nil, fmt.Errorf("duplicated user %q", userName)
+		}
 		userMap[userName] = userHash
 	}
 
@@ -18,13 +18,24 @@ along with this program. If not, see <https://www.gnu.org/licenses/>.
 package basicauth
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/audit"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/denial"
 	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestBasicAuth_fail(t *testing.T) {
@@ -35,13 +46,13 @@ func TestBasicAuth_fail(t *testing.T) {
 	require.Error(t, err)
 
 	cfg = &edge.ACPBasicAuthConfig{
-		Users: []string{"test:test"},
+		Users: []string{"test:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/"},
 	}
 	handler, err := NewHandler(cfg, "acp@my-ns")
 	require.NoError(t, err)
 
 	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
-	req.SetBasicAuth("test", "test")
+	req.SetBasicAuth("test", "wrong")
 	rec := httptest.NewRecorder()
 
 	handler.ServeHTTP(rec, req)
@@ -66,3 +77,222 @@ func TestBasicAuth_userHeader(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code)
 	assert.Equal(t, "test", rec.Header().Get("User"))
 }
+
+func TestBasicAuth_lockout(t *testing.T) {
+	cfg := &edge.ACPBasicAuthConfig{
+		Users:   []string{"test:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/"},
+		Lockout: &edge.ACPLockoutConfig{MaxAttempts: 2, Period: time.Minute},
+	}
+	handler, err := NewHandler(cfg, "acp@my-ns")
+	require.NoError(t, err)
+
+	serve := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		if password != "" {
+			req.SetBasicAuth("test", password)
+		}
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// Requests without credentials are not counted.
+	assert.Equal(t, http.StatusUnauthorized, serve("").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("").Code)
+	assert.Equal(t, http.StatusOK, serve("test").Code)
+
+	assert.Equal(t, http.StatusUnauthorized, serve("wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("wrong").Code)
+
+	rec := serve("test")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+}
+
+func TestBasicAuth_denial(t *testing.T) {
+	cfg := &edge.ACPBasicAuthConfig{
+		Users: []string{"test:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/"},
+	}
+	handler, err := NewHandler(cfg, "acp@my-ns")
+	require.NoError(t, err)
+
+	responder, err := denial.NewResponder(&edge.ACPDenialConfig{
+		Body:        `{"error":"unauthorized"}`,
+		ContentType: "application/json",
+	})
+	require.NoError(t, err)
+	handler.SetDenialResponder(responder)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.SetBasicAuth("test", "wrong")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"error":"unauthorized"}`, rec.Body.String())
+	// The custom response replaces the realm challenge.
+	assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+}
+
+func TestBasicAuth_audit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLogger, err := audit.NewLogger(audit.Config{Output: path, SuccessSampleRate: 1})
+	require.NoError(t, err)
+
+	cfg := &edge.ACPBasicAuthConfig{
+		Users: []string{"test:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/"},
+	}
+	handler, err := NewHandler(cfg, "acp@my-ns")
+	require.NoError(t, err)
+	handler.SetAuditLogger(auditLogger)
+
+	for _, password := range []string{"", "wrong", "test"} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		if password != "" {
+			req.SetBasicAuth("test", password)
+		}
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	require.NoError(t, auditLogger.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var reasons []string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+
+		assert.NotContains(t, line, "wrong")
+		reasons = append(reasons, fmt.Sprintf("%s/%s/%s", record["outcome"], record["reason"], record["subject"]))
+	}
+
+	assert.Equal(t, []string{
+		"denied/missing_credentials/",
+		"denied/invalid_credentials/test",
+		"allowed/authenticated/test",
+	}, reasons)
+}
+
+func TestNewHandler_users(t *testing.T) {
+	tests := []struct {
+		desc    string
+		users   []string
+		wantErr string
+	}{
+		{
+			desc:  "supported hashes",
+			users: []string{"md5:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/", "sha:{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M=", "bcrypt:" + bcryptHash(t, "test"), "argon:" + argon2idHash("test")},
+		},
+		{
+			desc:    "plaintext password",
+			users:   []string{"test:test"},
+			wantErr: `invalid password hash for user "test": unsupported hash format, passwords must be hashed using MD5, SHA1, bcrypt or argon2id`,
+		},
+		{
+			desc:    "malformed MD5 hash",
+			users:   []string{"test:$apr1$H6uskkkW"},
+			wantErr: `invalid password hash for user "test": malformed MD5 hash`,
+		},
+		{
+			desc:    "malformed SHA1 hash",
+			users:   []string{"test:{SHA}test"},
+			wantErr: `invalid password hash for user "test": malformed SHA1 hash`,
+		},
+		{
+			desc:    "malformed bcrypt hash",
+			users:   []string{"test:$2y$10$short"},
+			wantErr: `invalid password hash for user "test": malformed bcrypt hash`,
+		},
+		{
+			desc:    "malformed argon2id hash",
+			users:   []string{"test:$argon2id$v=19$m=65536$salt$key"},
+			wantErr: `invalid password hash for user "test": malformed argon2id hash`,
+		},
+		{
+			desc:    "unsupported argon2id version",
+			users:   []string{"test:$argon2id$v=16$m=65536,t=1,p=1$c2FsdA$a2V5"},
+			wantErr: `invalid password hash for user "test": malformed argon2id hash: unsupported version 16`,
+		},
+		{
+			desc:    "duplicated user",
+			users:   []string{"test:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/", "test:{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M="},
+			wantErr: `duplicated user "test"`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewHandler(&edge.ACPBasicAuthConfig{Users: test.users}, "acp@my-ns")
+			if test.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.wantErr)
+		})
+	}
+}
+
+func TestBasicAuth_hashes(t *testing.T) {
+	tests := []struct {
+		desc string
+		hash string
+	}{
+		{desc: "MD5", hash: "$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/"},
+		{desc: "SHA1", hash: "{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M="},
+		{desc: "bcrypt", hash: bcryptHash(t, "test")},
+		{desc: "argon2id", hash: argon2idHash("test")},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			handler, err := NewHandler(&edge.ACPBasicAuthConfig{Users: []string{"test:" + test.hash}}, "acp@my-ns")
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+			req.SetBasicAuth("test", "test")
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			req = httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+			req.SetBasicAuth("test", "wrong")
+			rec = httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		})
+	}
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	return string(hash)
+}
+
+func argon2idHash(password string) string {
+	salt := []byte("somesalt")
+	key := argon2.IDKey([]byte(password), salt, 1, 64, 1, 32)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
@@ -0,0 +1,135 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package basicauth
+
+import (
+	"crypto/sha1" //nolint:gosec // Required to support {SHA} htpasswd hashes.
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	goauth "github.com/abbot/go-http-auth"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// validateHash makes sure the given password hash has a supported format.
+// Supported formats are MD5 (`$apr1$` and `$1$`), SHA1 (`{SHA}`), bcrypt (`$2a$`, `$2b$`, `$2x$` and `$2y$`)
+// and argon2id (`$argon2id$`).
+func validateHash(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		parts := strings.Split(hash, "$")
+		if len(parts) != 4 || parts[2] == "" || len(parts[3]) != 22 {
+			return errors.New("malformed MD5 hash")
+		}
+		return nil
+
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hash, "{SHA}"))
+		if err != nil || len(sum) != sha1.Size {
+			return errors.New("malformed SHA1 hash")
+		}
+		return nil
+
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"),
+		strings.HasPrefix(hash, "$2x$"), strings.HasPrefix(hash, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("malformed bcrypt hash: %w", err)
+		}
+		return nil
+
+	case strings.HasPrefix(hash, argon2idPrefix):
+		if _, err := parseArgon2id(hash); err != nil {
+			return fmt.Errorf("malformed argon2id hash: %w", err)
+		}
+		return nil
+
+	default:
+		return errors.New("unsupported hash format, passwords must be hashed using MD5, SHA1, bcrypt or argon2id")
+	}
+}
+
+// checkSecret returns whether the given password matches the given hash.
+func checkSecret(password, hash string) bool {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return goauth.CheckSecret(password, hash)
+	}
+
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+
+	return subtle.ConstantTimeCompare(key, params.key) == 1
+}
+
+type argon2idParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2id parses an argon2id hash encoded in the PHC string format:
+// `$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<base64 salt>$<base64 key>`.
+func parseArgon2id(hash string) (argon2idParams, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return argon2idParams{}, errors.New("unexpected number of fields")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return argon2idParams{}, fmt.Errorf("parse version: %w", err)
+	}
+	if version != argon2.Version {
+		return argon2idParams{}, fmt.Errorf("unsupported version %d", version)
+	}
+
+	var params argon2idParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return argon2idParams{}, fmt.Errorf("parse parameters: %w", err)
+	}
+	if params.memory == 0 || params.time == 0 || params.threads == 0 {
+		return argon2idParams{}, errors.New("parameters must be positive")
+	}
+
+	var err error
+	params.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2idParams{}, fmt.Errorf("decode salt: %w", err)
+	}
+
+	params.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return argon2idParams{}, fmt.Errorf("decode key: %w", err)
+	}
+	if len(params.key) == 0 {
+		return argon2idParams{}, errors.New("empty key")
+	}
+
+	return params, nil
+}
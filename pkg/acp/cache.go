@@ -0,0 +1,269 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package acp
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/traefik/hub-agent-traefik/pkg/acp/audit"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+// DecisionCacheConfig configures the decision cache of the auth server.
+// The cache is disabled when its size is zero.
+type DecisionCacheConfig struct {
+	Size int
+	TTL  time.Duration
+}
+
+// credentialer is implemented by ACP handlers whose decisions only depend on the credential carried by the request.
+type credentialer interface {
+	// Credential returns the credential carried by the given request. It also returns the time after which
+	// a decision about this credential must not be reused, or a zero time if it doesn't expire.
+	Credential(req *http.Request) (credential string, expiry time.Time, ok bool)
+}
+
+// subjecter is implemented by ACP handlers able to tell the identity a request authenticates as.
+type subjecter interface {
+	Subject(req *http.Request) string
+}
+
+// decisionCache is an LRU cache of the decisions allowing requests.
+// Only allowed decisions are cached: denied requests are always evaluated again.
+type decisionCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type decision struct {
+	key     string
+	acp     string
+	version string
+	subject string
+
+	header http.Header
+	code   int
+	expiry time.Time
+}
+
+func newDecisionCache(cfg DecisionCacheConfig) *decisionCache {
+	if cfg.Size <= 0 || cfg.TTL <= 0 {
+		return nil
+	}
+
+	return &decisionCache{
+		size:    cfg.Size,
+		ttl:     cfg.TTL,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// wrap returns a handler caching the decisions of the given ACP handler, if it supports it. Decisions of ACPs without
+// version are never cached, as a change of their configuration could not be detected. Decisions served from the cache
+// are audited by the given logger, if any.
+func (c *decisionCache) wrap(acp edge.ACP, h http.Handler, auditLogger *audit.Logger) http.Handler {
+	if c == nil || acp.Version == "" {
+		return h
+	}
+
+	cred, ok := h.(credentialer)
+	if !ok {
+		return h
+	}
+
+	return &cachedHandler{
+		name:    acp.Name,
+		version: acp.Version,
+		handler: h,
+		cred:    cred,
+		cache:   c,
+		audit:   auditLogger,
+	}
+}
+
+func (c *decisionCache) get(key string, now time.Time) (*decision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	d := elem.Value.(*decision)
+	if !now.Before(d.expiry) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	return d, true
+}
+
+func (c *decisionCache) set(d *decision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[d.key]; ok {
+		elem.Value = d
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[d.key] = c.lru.PushFront(d)
+
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*decision).key)
+	}
+}
+
+// retain removes the cached decisions of the ACPs which are not part of the given ones, or whose version changed.
+func (c *decisionCache) retain(acps []edge.ACP) {
+	if c == nil {
+		return
+	}
+
+	versions := make(map[string]string, len(acps))
+	for _, acp := range acps {
+		versions[acp.Name] = acp.Version
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+
+		d := elem.Value.(*decision)
+		if version, ok := versions[d.acp]; !ok || version != d.version {
+			c.lru.Remove(elem)
+			delete(c.entries, d.key)
+		}
+
+		elem = next
+	}
+}
+
+// cachedHandler serves the cached decision of an ACP handler when there is one.
+type cachedHandler struct {
+	name    string
+	version string
+	handler http.Handler
+	cred    credentialer
+	cache   *decisionCache
+	audit   *audit.Logger
+}
+
+func (h *cachedHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	credential, credExpiry, ok := h.cred.Credential(req)
+	if !ok {
+		h.handler.ServeHTTP(rw, req)
+		return
+	}
+
+	now := time.Now()
+
+	// Credentials are hashed so they are never kept in memory in clear. The ACP version makes sure decisions
+	// taken with an outdated configuration are never reused.
+	sum := sha256.Sum256([]byte(h.name + "\x00" + h.version + "\x00" + credential))
+	key := hex.EncodeToString(sum[:])
+
+	if d, found := h.cache.get(key, now); found {
+		h.audit.Log(req, audit.Decision{
+			ACP:     h.name,
+			Allowed: true,
+			Reason:  audit.ReasonAuthenticated,
+			Subject: d.subject,
+			Cached:  true,
+			Start:   now,
+		})
+
+		for name, vals := range d.header {
+			rw.Header()[name] = append([]string(nil), vals...)
+		}
+		rw.WriteHeader(d.code)
+		return
+	}
+
+	rec := &decisionRecorder{ResponseWriter: rw}
+	h.handler.ServeHTTP(rec, req)
+
+	if rec.code < 200 || rec.code >= 300 {
+		return
+	}
+
+	expiry := now.Add(h.cache.ttl)
+	if !credExpiry.IsZero() && credExpiry.Before(expiry) {
+		expiry = credExpiry
+	}
+	if !now.Before(expiry) {
+		return
+	}
+
+	var subject string
+	if s, ok := h.handler.(subjecter); ok {
+		subject = s.Subject(req)
+	}
+
+	h.cache.set(&decision{
+		key:     key,
+		acp:     h.name,
+		version: h.version,
+		subject: subject,
+		header:  rw.Header().Clone(),
+		code:    rec.code,
+		expiry:  expiry,
+	})
+}
+
+// decisionRecorder records the status code written by an ACP handler.
+type decisionRecorder struct {
+	http.ResponseWriter
+
+	code int
+}
+
+func (r *decisionRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *decisionRecorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+
+	return r.ResponseWriter.Write(b)
+}
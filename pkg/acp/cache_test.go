@@ -0,0 +1,268 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package acp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/audit"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+func TestNewDecisionCache_disabled(t *testing.T) {
+	assert.Nil(t, newDecisionCache(DecisionCacheConfig{}))
+	assert.Nil(t, newDecisionCache(DecisionCacheConfig{Size: 10}))
+	assert.Nil(t, newDecisionCache(DecisionCacheConfig{TTL: time.Minute}))
+
+	h := &fakeACPHandler{code: http.StatusOK}
+
+	var cache *decisionCache
+	assert.Same(t, h, cache.wrap(edge.ACP{Name: "acp", Version: "1"}, h, nil))
+}
+
+func TestDecisionCache_wrap_noVersion(t *testing.T) {
+	cache := newDecisionCache(DecisionCacheConfig{Size: 10, TTL: time.Minute})
+	h := &fakeACPHandler{code: http.StatusOK, cred: "token", credOK: true}
+
+	// A configuration change of an ACP without version could not be detected.
+	assert.Same(t, h, cache.wrap(edge.ACP{Name: "acp"}, h, nil))
+}
+
+func TestCachedHandler_audit(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "audit.log")
+	auditLogger, err := audit.NewLogger(audit.Config{Output: output, SuccessSampleRate: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = auditLogger.Close() })
+
+	cache := newDecisionCache(DecisionCacheConfig{Size: 10, TTL: time.Minute})
+	h := &fakeACPHandler{code: http.StatusOK, cred: "token", credOK: true, subject: "john"}
+	handler := cache.wrap(edge.ACP{Name: "acp", Version: "1"}, h, auditLogger)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	require.Equal(t, 1, h.calls)
+
+	b, err := os.ReadFile(output)
+	require.NoError(t, err)
+
+	// The fake handler doesn't audit its decisions: the only record is the one of the cache hit.
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &got))
+
+	assert.Equal(t, "acp", got["acp"])
+	assert.Equal(t, "allowed", got["outcome"])
+	assert.Equal(t, "john", got["subject"])
+	assert.Equal(t, true, got["cached"])
+}
+
+func TestCachedHandler(t *testing.T) {
+	tests := []struct {
+		desc      string
+		code      int
+		credOK    bool
+		expiry    time.Time
+		wantCalls int
+	}{
+		{
+			desc:      "allowed decision is cached",
+			code:      http.StatusOK,
+			credOK:    true,
+			wantCalls: 1,
+		},
+		{
+			desc:      "unauthorized decision is not cached",
+			code:      http.StatusUnauthorized,
+			credOK:    true,
+			wantCalls: 2,
+		},
+		{
+			desc:      "forbidden decision is not cached",
+			code:      http.StatusForbidden,
+			credOK:    true,
+			wantCalls: 2,
+		},
+		{
+			desc:      "no credential",
+			code:      http.StatusOK,
+			wantCalls: 2,
+		},
+		{
+			desc:      "expired credential",
+			code:      http.StatusOK,
+			credOK:    true,
+			expiry:    time.Now().Add(-time.Second),
+			wantCalls: 2,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			cache := newDecisionCache(DecisionCacheConfig{Size: 10, TTL: time.Minute})
+			h := &fakeACPHandler{
+				code:   test.code,
+				header: http.Header{"X-User": []string{"bob"}},
+				cred:   "token",
+				credOK: test.credOK,
+				expiry: test.expiry,
+			}
+			handler := cache.wrap(edge.ACP{Name: "acp", Version: "1"}, h, nil)
+
+			for i := 0; i < 2; i++ {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+				assert.Equal(t, test.code, rec.Code)
+				assert.Equal(t, "bob", rec.Header().Get("X-User"))
+			}
+
+			assert.Equal(t, test.wantCalls, h.calls)
+		})
+	}
+}
+
+func TestCachedHandler_keys(t *testing.T) {
+	cache := newDecisionCache(DecisionCacheConfig{Size: 10, TTL: time.Minute})
+	h := &fakeACPHandler{code: http.StatusOK, cred: "token", credOK: true}
+
+	serve := func(handler http.Handler) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	serve(cache.wrap(edge.ACP{Name: "acp", Version: "1"}, h, nil))
+	serve(cache.wrap(edge.ACP{Name: "acp", Version: "1"}, h, nil))
+	assert.Equal(t, 1, h.calls)
+
+	serve(cache.wrap(edge.ACP{Name: "acp", Version: "2"}, h, nil))
+	assert.Equal(t, 2, h.calls)
+
+	serve(cache.wrap(edge.ACP{Name: "other-acp", Version: "2"}, h, nil))
+	assert.Equal(t, 3, h.calls)
+
+	h.cred = "other-token"
+	serve(cache.wrap(edge.ACP{Name: "acp", Version: "2"}, h, nil))
+	assert.Equal(t, 4, h.calls)
+
+	cache.retain([]edge.ACP{{Name: "acp", Version: "2"}})
+	serve(cache.wrap(edge.ACP{Name: "acp", Version: "2"}, h, nil))
+	assert.Equal(t, 4, h.calls)
+
+	h.cred = "token"
+	serve(cache.wrap(edge.ACP{Name: "other-acp", Version: "2"}, h, nil))
+	assert.Equal(t, 5, h.calls)
+
+	cache.retain([]edge.ACP{{Name: "acp", Version: "3"}})
+	serve(cache.wrap(edge.ACP{Name: "acp", Version: "2"}, h, nil))
+	assert.Equal(t, 6, h.calls)
+}
+
+func TestDecisionCache_expiry(t *testing.T) {
+	cache := newDecisionCache(DecisionCacheConfig{Size: 10, TTL: time.Minute})
+
+	now := time.Now()
+	cache.set(&decision{key: "key", code: http.StatusOK, expiry: now.Add(time.Second)})
+
+	_, ok := cache.get("key", now)
+	assert.True(t, ok)
+
+	_, ok = cache.get("key", now.Add(time.Second))
+	assert.False(t, ok)
+	assert.Empty(t, cache.entries)
+}
+
+func TestDecisionCache_eviction(t *testing.T) {
+	cache := newDecisionCache(DecisionCacheConfig{Size: 2, TTL: time.Minute})
+
+	now := time.Now()
+	expiry := now.Add(time.Minute)
+
+	cache.set(&decision{key: "a", expiry: expiry})
+	cache.set(&decision{key: "b", expiry: expiry})
+
+	// Using "a" makes "b" the least recently used decision.
+	_, ok := cache.get("a", now)
+	require.True(t, ok)
+
+	cache.set(&decision{key: "c", expiry: expiry})
+
+	_, ok = cache.get("a", now)
+	assert.True(t, ok)
+	_, ok = cache.get("b", now)
+	assert.False(t, ok)
+	_, ok = cache.get("c", now)
+	assert.True(t, ok)
+}
+
+func TestCachedHandler_credentialExpiry(t *testing.T) {
+	cache := newDecisionCache(DecisionCacheConfig{Size: 10, TTL: time.Hour})
+
+	expiry := time.Now().Add(time.Minute)
+	h := &fakeACPHandler{code: http.StatusOK, cred: "token", credOK: true, expiry: expiry}
+
+	rec := httptest.NewRecorder()
+	cache.wrap(edge.ACP{Name: "acp", Version: "1"}, h, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	require.Len(t, cache.entries, 1)
+	for _, elem := range cache.entries {
+		assert.Equal(t, expiry, elem.Value.(*decision).expiry)
+	}
+}
+
+type fakeACPHandler struct {
+	code   int
+	header http.Header
+	calls  int
+
+	cred    string
+	credOK  bool
+	expiry  time.Time
+	subject string
+}
+
+func (h *fakeACPHandler) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	h.calls++
+
+	for name, vals := range h.header {
+		rw.Header()[name] = vals
+	}
+	rw.WriteHeader(h.code)
+}
+
+func (h *fakeACPHandler) Credential(_ *http.Request) (string, time.Time, bool) {
+	return h.cred, h.expiry, h.credOK
+}
+
+func (h *fakeACPHandler) Subject(_ *http.Request) string {
+	return h.subject
+}
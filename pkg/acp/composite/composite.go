@@ -0,0 +1,189 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package composite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+// Handler is a composite ACP Handler. It combines the decisions of other ACP handlers.
+//
+// With the "and" operator, every handler must allow the request. Their forwarded headers are merged,
+// and the response of the first handler denying the request is returned.
+// With the "or" operator, the response of the first handler allowing the request is returned.
+// If none of them allows the request, the response of the last one is returned.
+type Handler struct {
+	name     string
+	operator string
+	children []child
+}
+
+type child struct {
+	name    string
+	handler http.Handler
+}
+
+// NewHandler returns a new composite ACP Handler. The ACPs referenced by the configuration are looked up in the
+// given handlers, indexed by ACP name.
+func NewHandler(cfg *edge.ACPCompositeConfig, polName string, handlers map[string]http.Handler) (*Handler, error) {
+	switch cfg.Operator {
+	case edge.ACPCompositeOperatorAnd, edge.ACPCompositeOperatorOr:
+	default:
+		return nil, fmt.Errorf("unsupported operator %q", cfg.Operator)
+	}
+
+	if len(cfg.ACPs) < 2 {
+		return nil, errors.New("at least two ACPs are required")
+	}
+
+	children := make([]child, 0, len(cfg.ACPs))
+	for _, name := range cfg.ACPs {
+		h, ok := handlers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown ACP %q", name)
+		}
+
+		children = append(children, child{name: name, handler: h})
+	}
+
+	return &Handler{
+		name:     polName,
+		operator: cfg.Operator,
+		children: children,
+	}, nil
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	logger := log.With().Str("handler_type", "Composite").Str("handler_name", h.name).Logger()
+
+	if h.operator == edge.ACPCompositeOperatorOr {
+		var rec *recorder
+		for _, c := range h.children {
+			rec = newRecorder()
+			c.handler.ServeHTTP(rec, req)
+
+			if rec.allowed() {
+				rec.replay(rw)
+				return
+			}
+
+			logger.Debug().Str("acp_name", c.name).Int("status_code", rec.status()).Msg("Request denied by ACP")
+		}
+
+		rec.replay(rw)
+		return
+	}
+
+	hdrs := make(http.Header)
+	for _, c := range h.children {
+		rec := newRecorder()
+		c.handler.ServeHTTP(rec, req)
+
+		if !rec.allowed() {
+			logger.Debug().Str("acp_name", c.name).Int("status_code", rec.status()).Msg("Request denied by ACP")
+
+			rec.replay(rw)
+			return
+		}
+
+		mergeHeaders(hdrs, rec.header)
+	}
+
+	mergeHeaders(rw.Header(), hdrs)
+	rw.WriteHeader(http.StatusOK)
+}
+
+// mergeHeaders adds the values of src to dst, skipping values already present in dst.
+func mergeHeaders(dst, src http.Header) {
+	for name, vals := range src {
+		for _, val := range vals {
+			if !contains(dst[name], val) {
+				dst[name] = append(dst[name], val)
+			}
+		}
+	}
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recorder records the response of an ACP handler so it can be inspected before being sent.
+type recorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(http.Header)}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+
+	return r.body.Write(b)
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+// status returns the recorded status code. Like with an http.Server, it defaults to 200.
+func (r *recorder) status() int {
+	if r.code == 0 {
+		return http.StatusOK
+	}
+
+	return r.code
+}
+
+// allowed returns whether the recorded response allows the request.
+func (r *recorder) allowed() bool {
+	return r.status() >= 200 && r.status() < 300
+}
+
+// replay writes the recorded response to the given ResponseWriter.
+func (r *recorder) replay(rw http.ResponseWriter) {
+	for name, vals := range r.header {
+		rw.Header()[name] = append(rw.Header()[name], vals...)
+	}
+
+	rw.WriteHeader(r.status())
+	_, _ = rw.Write(r.body.Bytes())
+}
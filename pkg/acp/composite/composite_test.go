@@ -0,0 +1,158 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package composite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+func TestNewHandler(t *testing.T) {
+	handlers := map[string]http.Handler{
+		"jwt":   respond(http.StatusOK, nil),
+		"basic": respond(http.StatusOK, nil),
+	}
+
+	tests := []struct {
+		desc    string
+		cfg     edge.ACPCompositeConfig
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			desc:    "and",
+			cfg:     edge.ACPCompositeConfig{Operator: "and", ACPs: []string{"jwt", "basic"}},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "or",
+			cfg:     edge.ACPCompositeConfig{Operator: "or", ACPs: []string{"jwt", "basic"}},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "unsupported operator",
+			cfg:     edge.ACPCompositeConfig{Operator: "xor", ACPs: []string{"jwt", "basic"}},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "single ACP",
+			cfg:     edge.ACPCompositeConfig{Operator: "and", ACPs: []string{"jwt"}},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "unknown ACP",
+			cfg:     edge.ACPCompositeConfig{Operator: "and", ACPs: []string{"jwt", "unknown"}},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewHandler(&test.cfg, "acp@my-ns", handlers)
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	handlers := map[string]http.Handler{
+		"jwt":             respond(http.StatusOK, http.Header{"User": {"john"}, "Authorization": {""}}),
+		"basic":           respond(http.StatusOK, http.Header{"Group": {"dev"}, "Authorization": {""}}),
+		"ip":              respond(http.StatusOK, nil),
+		"jwt-denied":      respond(http.StatusUnauthorized, nil),
+		"basic-challenge": respond(http.StatusUnauthorized, http.Header{"Www-Authenticate": {`Basic realm="hub"`}}),
+		"forbidden":       respond(http.StatusForbidden, nil),
+	}
+
+	tests := []struct {
+		desc           string
+		operator       string
+		acps           []string
+		wantStatusCode int
+		wantHeaders    http.Header
+	}{
+		{
+			desc:           "and: all allowed, headers are merged",
+			operator:       "and",
+			acps:           []string{"jwt", "basic"},
+			wantStatusCode: http.StatusOK,
+			wantHeaders:    http.Header{"User": {"john"}, "Group": {"dev"}, "Authorization": {""}},
+		},
+		{
+			desc:           "and: first denial is returned",
+			operator:       "and",
+			acps:           []string{"ip", "forbidden", "jwt-denied"},
+			wantStatusCode: http.StatusForbidden,
+			wantHeaders:    http.Header{},
+		},
+		{
+			desc:           "or: first allowed is returned",
+			operator:       "or",
+			acps:           []string{"jwt-denied", "basic", "jwt"},
+			wantStatusCode: http.StatusOK,
+			wantHeaders:    http.Header{"Group": {"dev"}, "Authorization": {""}},
+		},
+		{
+			desc:           "or: last denial is returned",
+			operator:       "or",
+			acps:           []string{"jwt-denied", "basic-challenge"},
+			wantStatusCode: http.StatusUnauthorized,
+			wantHeaders:    http.Header{"Www-Authenticate": {`Basic realm="hub"`}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			handler, err := NewHandler(&edge.ACPCompositeConfig{Operator: test.operator, ACPs: test.acps}, "acp@my-ns", handlers)
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.wantStatusCode, rec.Code)
+			for name, vals := range test.wantHeaders {
+				assert.Equal(t, vals, rec.Header().Values(name))
+			}
+			assert.Len(t, rec.Header(), len(test.wantHeaders))
+		})
+	}
+}
+
+func respond(code int, hdrs http.Header) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		for name, vals := range hdrs {
+			for _, val := range vals {
+				rw.Header().Add(name, val)
+			}
+		}
+
+		rw.WriteHeader(code)
+	})
+}
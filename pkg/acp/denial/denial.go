@@ -0,0 +1,138 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package denial
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+const (
+	defaultRedirectParam = "redirect"
+	defaultContentType   = "text/html; charset=utf-8"
+)
+
+// Responder writes the responses denying requests.
+// A nil Responder only writes the status code.
+type Responder struct {
+	redirectURL   *url.URL
+	redirectParam string
+	body          []byte
+	contentType   string
+	headers       map[string]string
+}
+
+// NewResponder returns a new Responder.
+func NewResponder(cfg *edge.ACPDenialConfig) (*Responder, error) {
+	if cfg.RedirectURL != "" && cfg.Body != "" {
+		return nil, errors.New("redirect URL and body cannot be used together")
+	}
+
+	var redirectURL *url.URL
+	if cfg.RedirectURL != "" {
+		var err error
+		redirectURL, err = url.Parse(cfg.RedirectURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse redirect URL: %w", err)
+		}
+		if !redirectURL.IsAbs() {
+			return nil, fmt.Errorf("redirect URL %q must be absolute", cfg.RedirectURL)
+		}
+	}
+
+	redirectParam := cfg.RedirectParam
+	if redirectParam == "" {
+		redirectParam = defaultRedirectParam
+	}
+
+	contentType := cfg.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	return &Responder{
+		redirectURL:   redirectURL,
+		redirectParam: redirectParam,
+		body:          []byte(cfg.Body),
+		contentType:   contentType,
+		headers:       cfg.Headers,
+	}, nil
+}
+
+// Deny writes a response denying the given request with the given status code. Redirections replace the status code.
+func (r *Responder) Deny(rw http.ResponseWriter, req *http.Request, code int) {
+	if r == nil {
+		rw.WriteHeader(code)
+		return
+	}
+
+	for name, val := range r.headers {
+		rw.Header().Set(name, val)
+	}
+
+	if r.redirectURL != nil {
+		http.Redirect(rw, req, r.location(req), http.StatusFound)
+		return
+	}
+
+	if len(r.body) == 0 {
+		rw.WriteHeader(code)
+		return
+	}
+
+	rw.Header().Set("Content-Type", r.contentType)
+	rw.Header().Set("Content-Length", strconv.Itoa(len(r.body)))
+	rw.WriteHeader(code)
+	_, _ = rw.Write(r.body)
+}
+
+// location returns the URL the given request is redirected to.
+func (r *Responder) location(req *http.Request) string {
+	originalURL := forwardedURL(req)
+	if originalURL == "" {
+		return r.redirectURL.String()
+	}
+
+	location := *r.redirectURL
+	query := location.Query()
+	query.Set(r.redirectParam, originalURL)
+	location.RawQuery = query.Encode()
+
+	return location.String()
+}
+
+// forwardedURL returns the URL of the request forwarded by Traefik's ForwardAuth middleware, or an empty string
+// if it is unknown.
+func forwardedURL(req *http.Request) string {
+	host := req.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		return ""
+	}
+
+	proto := req.Header.Get("X-Forwarded-Proto")
+	if proto == "" {
+		proto = "http"
+	}
+
+	return proto + "://" + host + req.Header.Get("X-Forwarded-Uri")
+}
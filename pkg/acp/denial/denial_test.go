@@ -0,0 +1,195 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package denial
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+func TestNewResponder(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cfg     edge.ACPDenialConfig
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			desc:    "redirect",
+			cfg:     edge.ACPDenialConfig{RedirectURL: "https://login.example.com/?app=foo", RedirectParam: "rd"},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "body",
+			cfg:     edge.ACPDenialConfig{Body: `{"error":"denied"}`, ContentType: "application/json"},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "relative redirect URL",
+			cfg:     edge.ACPDenialConfig{RedirectURL: "/login"},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "invalid redirect URL",
+			cfg:     edge.ACPDenialConfig{RedirectURL: "https://login.example.com/%zz"},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "redirect URL and body",
+			cfg:     edge.ACPDenialConfig{RedirectURL: "https://login.example.com", Body: "denied"},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewResponder(&test.cfg)
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestResponder_Deny(t *testing.T) {
+	tests := []struct {
+		desc       string
+		cfg        *edge.ACPDenialConfig
+		header     http.Header
+		wantCode   int
+		wantHeader http.Header
+		wantBody   string
+	}{
+		{
+			desc:       "no responder",
+			wantCode:   http.StatusUnauthorized,
+			wantHeader: http.Header{},
+		},
+		{
+			desc: "headers",
+			cfg: &edge.ACPDenialConfig{
+				Headers: map[string]string{"WWW-Authenticate": `Bearer error="invalid_token"`},
+			},
+			wantCode:   http.StatusUnauthorized,
+			wantHeader: http.Header{"Www-Authenticate": {`Bearer error="invalid_token"`}},
+		},
+		{
+			desc: "body",
+			cfg: &edge.ACPDenialConfig{
+				Body:        `{"error":"denied"}`,
+				ContentType: "application/json",
+			},
+			wantCode: http.StatusUnauthorized,
+			wantHeader: http.Header{
+				"Content-Type":   {"application/json"},
+				"Content-Length": {"18"},
+			},
+			wantBody: `{"error":"denied"}`,
+		},
+		{
+			desc: "HTML body",
+			cfg: &edge.ACPDenialConfig{
+				Body: "<p>Denied</p>",
+			},
+			wantCode: http.StatusUnauthorized,
+			wantHeader: http.Header{
+				"Content-Type":   {"text/html; charset=utf-8"},
+				"Content-Length": {"13"},
+			},
+			wantBody: "<p>Denied</p>",
+		},
+		{
+			desc: "redirect",
+			cfg: &edge.ACPDenialConfig{
+				RedirectURL: "https://login.example.com/?app=foo",
+				Headers:     map[string]string{"Cache-Control": "no-store"},
+			},
+			header: http.Header{
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"app.example.com"},
+				"X-Forwarded-Uri":   {"/foo?bar=baz"},
+			},
+			wantCode: http.StatusFound,
+			wantHeader: http.Header{
+				"Location":      {"https://login.example.com/?app=foo&redirect=https%3A%2F%2Fapp.example.com%2Ffoo%3Fbar%3Dbaz"},
+				"Cache-Control": {"no-store"},
+			},
+		},
+		{
+			desc: "redirect with custom parameter",
+			cfg: &edge.ACPDenialConfig{
+				RedirectURL:   "https://login.example.com/",
+				RedirectParam: "rd",
+			},
+			header: http.Header{
+				"X-Forwarded-Host": {"app.example.com"},
+				"X-Forwarded-Uri":  {"/"},
+			},
+			wantCode: http.StatusFound,
+			wantHeader: http.Header{
+				"Location": {"https://login.example.com/?rd=http%3A%2F%2Fapp.example.com%2F"},
+			},
+		},
+		{
+			desc: "redirect without forwarded host",
+			cfg: &edge.ACPDenialConfig{
+				RedirectURL: "https://login.example.com/",
+			},
+			wantCode: http.StatusFound,
+			wantHeader: http.Header{
+				"Location": {"https://login.example.com/"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var responder *Responder
+			if test.cfg != nil {
+				var err error
+				responder, err = NewResponder(test.cfg)
+				require.NoError(t, err)
+			}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			for name, vals := range test.header {
+				req.Header[name] = vals
+			}
+
+			responder.Deny(rec, req, http.StatusUnauthorized)
+
+			assert.Equal(t, test.wantCode, rec.Code)
+			for name, vals := range test.wantHeader {
+				assert.Equal(t, vals, rec.Header()[name])
+			}
+			if test.wantCode != http.StatusFound {
+				assert.Equal(t, test.wantBody, rec.Body.String())
+				assert.Len(t, rec.Header(), len(test.wantHeader))
+			}
+		})
+	}
+}
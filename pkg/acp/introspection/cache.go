@@ -0,0 +1,106 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package introspection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries is the maximum number of introspection results kept in the cache.
+const maxCacheEntries = 10000
+
+// result is the outcome of a token introspection.
+type result struct {
+	active bool
+	claims map[string]interface{}
+}
+
+type cacheEntry struct {
+	result result
+	expiry time.Time
+}
+
+// cache caches introspection results. Tokens are hashed so they are never kept in memory in clear.
+type cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newCache() *cache {
+	return &cache{entries: make(map[string]cacheEntry)}
+}
+
+// get returns the cached result for the given token, if it is not expired.
+func (c *cache) get(token string, now time.Time) (result, bool) {
+	key := cacheKey(token)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return result{}, false
+	}
+
+	if !now.Before(entry.expiry) {
+		delete(c.entries, key)
+		return result{}, false
+	}
+
+	return entry.result, true
+}
+
+// set caches the result for the given token until the given expiry.
+func (c *cache) set(token string, res result, expiry time.Time, now time.Time) {
+	if !now.Before(expiry) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCacheEntries {
+		c.evict(now)
+	}
+
+	c.entries[cacheKey(token)] = cacheEntry{result: res, expiry: expiry}
+}
+
+// evict removes expired entries. If the cache is still full, arbitrary entries are removed to make room.
+func (c *cache) evict(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiry) {
+			delete(c.entries, key)
+		}
+	}
+
+	for key := range c.entries {
+		if len(c.entries) < maxCacheEntries {
+			return
+		}
+		delete(c.entries, key)
+	}
+}
+
+func cacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,261 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package introspection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/jwt/expr"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+const (
+	defaultCacheTTL         = time.Minute
+	defaultNegativeCacheTTL = 10 * time.Second
+)
+
+// Handler is an OAuth 2.0 token introspection (RFC 7662) ACP Handler.
+// It validates opaque access tokens by asking the authorization server about them.
+type Handler struct {
+	name string
+
+	endpoint      string
+	clientID      string
+	clientSecret  string
+	tokenTypeHint string
+	client        *http.Client
+
+	cache            *cache
+	cacheTTL         time.Duration
+	negativeCacheTTL time.Duration
+
+	tokQryKey            string
+	stripAuthorization   bool
+	fwdHeaders           map[string]string
+	validateCustomClaims expr.Predicate
+}
+
+// NewHandler returns a new token introspection ACP Handler.
+func NewHandler(cfg *edge.ACPIntrospectionConfig, polName string) (*Handler, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("an introspection endpoint is required")
+	}
+
+	if _, err := url.ParseRequestURI(cfg.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid introspection endpoint: %w", err)
+	}
+
+	if cfg.CacheTTL < 0 || cfg.NegativeCacheTTL < 0 {
+		return nil, errors.New("cache TTLs must not be negative")
+	}
+
+	var (
+		pred expr.Predicate
+		err  error
+	)
+	if cfg.Claims != "" {
+		pred, err = expr.Parse(cfg.Claims)
+		if err != nil {
+			return nil, fmt.Errorf("make predicate: %w", err)
+		}
+	}
+
+	cacheTTL := defaultCacheTTL
+	if cfg.CacheTTL > 0 {
+		cacheTTL = cfg.CacheTTL
+	}
+
+	negativeCacheTTL := defaultNegativeCacheTTL
+	if cfg.NegativeCacheTTL > 0 {
+		negativeCacheTTL = cfg.NegativeCacheTTL
+	}
+
+	tokenQueryKey := "token"
+	if cfg.TokenQueryKey != "" {
+		tokenQueryKey = cfg.TokenQueryKey
+	}
+
+	return &Handler{
+		name:                 polName,
+		endpoint:             cfg.Endpoint,
+		clientID:             cfg.ClientID,
+		clientSecret:         cfg.ClientSecret,
+		tokenTypeHint:        cfg.TokenTypeHint,
+		client:               &http.Client{Timeout: 5 * time.Second},
+		cache:                newCache(),
+		cacheTTL:             cacheTTL,
+		negativeCacheTTL:     negativeCacheTTL,
+		tokQryKey:            tokenQueryKey,
+		stripAuthorization:   cfg.StripAuthorizationHeader,
+		fwdHeaders:           cfg.ForwardHeaders,
+		validateCustomClaims: pred,
+	}, nil
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	logger := log.With().Str("handler_type", "Introspection").Str("handler_name", h.name).Logger()
+
+	token := h.extractToken(req)
+	if token == "" {
+		logger.Debug().Msg("No token found in request")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	res, err := h.introspect(req.Context(), token)
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to introspect token")
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	if !res.active {
+		logger.Debug().Msg("Inactive token")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if h.validateCustomClaims != nil {
+		if !h.validateCustomClaims(expr.Input{Claims: res.claims, Request: expr.NewRequest(req)}) {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	hdrs, err := expr.PluckClaims(h.fwdHeaders, res.claims)
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to set forwarded header")
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	for name, vals := range hdrs {
+		for _, val := range vals {
+			rw.Header().Add(name, val)
+		}
+	}
+
+	if h.stripAuthorization {
+		rw.Header().Add("Authorization", "")
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// extractToken extracts the access token from the "Authorization" header, or from the configured query parameter.
+func (h *Handler) extractToken(req *http.Request) string {
+	if token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "); token != "" {
+		return token
+	}
+
+	return req.URL.Query().Get(h.tokQryKey)
+}
+
+// introspect returns the introspection result of the given token, from the cache if possible.
+func (h *Handler) introspect(ctx context.Context, token string) (result, error) {
+	now := time.Now()
+
+	if res, ok := h.cache.get(token, now); ok {
+		return res, nil
+	}
+
+	res, err := h.requestIntrospection(ctx, token)
+	if err != nil {
+		return result{}, err
+	}
+
+	expiry := now.Add(h.negativeCacheTTL)
+	if res.active {
+		expiry = now.Add(h.cacheTTL)
+
+		// Never cache an active token past its expiration.
+		if exp, ok := expiration(res.claims); ok && exp.Before(expiry) {
+			expiry = exp
+		}
+	}
+
+	h.cache.set(token, res, expiry, now)
+
+	return res, nil
+}
+
+// requestIntrospection asks the authorization server whether the given token is active.
+func (h *Handler) requestIntrospection(ctx context.Context, token string) (result, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	if h.tokenTypeHint != "" {
+		form.Set("token_type_hint", h.tokenTypeHint)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return result{}, fmt.Errorf("build introspection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if h.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(h.clientID), url.QueryEscape(h.clientSecret))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return result{}, fmt.Errorf("request introspection: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return result{}, fmt.Errorf("unexpected status code %q: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+
+	var claims map[string]interface{}
+	if err = dec.Decode(&claims); err != nil {
+		return result{}, fmt.Errorf("decode introspection response: %w", err)
+	}
+
+	active, _ := claims["active"].(bool)
+
+	return result{active: active, claims: claims}, nil
+}
+
+// expiration returns the time held by the `exp` member of an introspection response.
+func expiration(claims map[string]interface{}) (time.Time, bool) {
+	num, ok := claims["exp"].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	exp, err := num.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(exp), 0), true
+}
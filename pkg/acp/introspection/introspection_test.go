@@ -0,0 +1,236 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package introspection
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+func TestNewHandler(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cfg     edge.ACPIntrospectionConfig
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			desc:    "valid",
+			cfg:     edge.ACPIntrospectionConfig{Endpoint: "https://auth.example.com/introspect", ClientID: "id", ClientSecret: "secret"},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "no endpoint",
+			cfg:     edge.ACPIntrospectionConfig{},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "invalid endpoint",
+			cfg:     edge.ACPIntrospectionConfig{Endpoint: "not a URL"},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "negative cache TTL",
+			cfg:     edge.ACPIntrospectionConfig{Endpoint: "https://auth.example.com/introspect", CacheTTL: -time.Second},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "invalid claims",
+			cfg:     edge.ACPIntrospectionConfig{Endpoint: "https://auth.example.com/introspect", Claims: "Equals(`grp`"},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewHandler(&test.cfg, "acp@my-ns")
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		desc           string
+		cfg            edge.ACPIntrospectionConfig
+		token          string
+		wantStatusCode int
+		wantHeaders    http.Header
+	}{
+		{
+			desc:           "active token",
+			cfg:            edge.ACPIntrospectionConfig{ForwardHeaders: map[string]string{"User": "sub", "Scope": "scope"}},
+			token:          "active",
+			wantStatusCode: http.StatusOK,
+			wantHeaders:    http.Header{"User": {"john"}, "Scope": {"read write"}},
+		},
+		{
+			desc:           "active token with authorization header stripped",
+			cfg:            edge.ACPIntrospectionConfig{StripAuthorizationHeader: true},
+			token:          "active",
+			wantStatusCode: http.StatusOK,
+			wantHeaders:    http.Header{"Authorization": {""}},
+		},
+		{
+			desc:           "inactive token",
+			token:          "inactive",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "no token",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "claims are valid",
+			cfg:            edge.ACPIntrospectionConfig{Claims: "Equals(`grp`, `admin`)"},
+			token:          "active",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc:           "claims are invalid",
+			cfg:            edge.ACPIntrospectionConfig{Claims: "Equals(`grp`, `dev`)"},
+			token:          "active",
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			desc:           "introspection endpoint failure",
+			token:          "error",
+			wantStatusCode: http.StatusBadGateway,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			srv := httptest.NewServer(introspectionHandler(t, nil))
+			t.Cleanup(srv.Close)
+
+			test.cfg.Endpoint = srv.URL
+			test.cfg.ClientID = "client-id"
+			test.cfg.ClientSecret = "client-secret"
+
+			handler, err := NewHandler(&test.cfg, "acp@my-ns")
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.wantStatusCode, rec.Code)
+			for name, vals := range test.wantHeaders {
+				assert.Equal(t, vals, rec.Header().Values(name))
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_cache(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(introspectionHandler(t, &calls))
+	t.Cleanup(srv.Close)
+
+	handler, err := NewHandler(&edge.ACPIntrospectionConfig{
+		Endpoint:     srv.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+	}, "acp@my-ns")
+	require.NoError(t, err)
+
+	for _, token := range []string{"active", "active", "inactive", "inactive", "error", "error"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		handler.ServeHTTP(rec, req)
+	}
+
+	// Positive and negative results are cached, failures are not.
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestHandler_introspect_expiredTokenNotCached(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(introspectionHandler(t, &calls))
+	t.Cleanup(srv.Close)
+
+	handler, err := NewHandler(&edge.ACPIntrospectionConfig{Endpoint: srv.URL, ClientID: "client-id", ClientSecret: "client-secret"}, "acp@my-ns")
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		res, err := handler.introspect(httptest.NewRequest(http.MethodGet, "/", http.NoBody).Context(), "expired")
+		require.NoError(t, err)
+		assert.True(t, res.active)
+	}
+
+	// The token expiration is in the past: the result must not outlive it.
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func introspectionHandler(t *testing.T, calls *int32) http.HandlerFunc {
+	t.Helper()
+
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if calls != nil {
+			atomic.AddInt32(calls, 1)
+		}
+
+		id, secret, ok := req.BasicAuth()
+		if req.Method != http.MethodPost || !ok || id != "client-id" || secret != "client-secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var resp map[string]interface{}
+		switch req.FormValue("token") {
+		case "active":
+			resp = map[string]interface{}{
+				"active": true,
+				"sub":    "john",
+				"scope":  "read write",
+				"grp":    "admin",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+			}
+		case "expired":
+			resp = map[string]interface{}{"active": true, "exp": time.Now().Add(-time.Second).Unix()}
+		case "inactive":
+			resp = map[string]interface{}{"active": false}
+		default:
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(resp)
+	}
+}
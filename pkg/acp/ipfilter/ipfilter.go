@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package ipfilter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+// Handler is an IP filter ACP Handler.
+// Denied ranges take precedence over allowed ranges. When no allowed range is configured, any IP that is
+// not denied is allowed.
+type Handler struct {
+	name string
+
+	allowed  ranges
+	denied   ranges
+	strategy *Strategy
+}
+
+// NewHandler returns a new IP filter ACP Handler.
+func NewHandler(cfg *edge.ACPIPFilterConfig, polName string) (*Handler, error) {
+	if len(cfg.AllowedRanges) == 0 && len(cfg.DeniedRanges) == 0 {
+		return nil, errors.New("at least an allowed or denied range is required")
+	}
+
+	allowed, err := parseRanges(cfg.AllowedRanges)
+	if err != nil {
+		return nil, fmt.Errorf("parse allowed ranges: %w", err)
+	}
+
+	denied, err := parseRanges(cfg.DeniedRanges)
+	if err != nil {
+		return nil, fmt.Errorf("parse denied ranges: %w", err)
+	}
+
+	strategy, err := NewStrategy(cfg.IPStrategy)
+	if err != nil {
+		return nil, fmt.Errorf("create IP strategy: %w", err)
+	}
+
+	return &Handler{
+		name:     polName,
+		allowed:  allowed,
+		denied:   denied,
+		strategy: strategy,
+	}, nil
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	logger := log.With().Str("handler_type", "IPFilter").Str("handler_name", h.name).Logger()
+
+	clientIP := h.strategy.ClientIP(req)
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		logger.Debug().Str("client_ip", clientIP).Msg("Unable to determine client IP")
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if !h.isAllowed(ip) {
+		logger.Debug().Str("client_ip", clientIP).Msg("IP not allowed")
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) isAllowed(ip net.IP) bool {
+	if h.denied.contains(ip) {
+		return false
+	}
+
+	return len(h.allowed) == 0 || h.allowed.contains(ip)
+}
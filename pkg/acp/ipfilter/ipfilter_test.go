@@ -0,0 +1,178 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package ipfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+func TestNewHandler(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cfg     edge.ACPIPFilterConfig
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			desc:    "allowed and denied ranges",
+			cfg:     edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8", "192.168.1.1"}, DeniedRanges: []string{"10.0.0.1", "::1/128"}},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "no ranges",
+			cfg:     edge.ACPIPFilterConfig{},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "invalid CIDR",
+			cfg:     edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/33"}},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "invalid IP",
+			cfg:     edge.ACPIPFilterConfig{DeniedRanges: []string{"10.0.0"}},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "negative depth",
+			cfg:     edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}, IPStrategy: &edge.ACPIPStrategy{Depth: -1}},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "depth and excluded IPs",
+			cfg:     edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}, IPStrategy: &edge.ACPIPStrategy{Depth: 1, ExcludedIPs: []string{"10.0.0.1"}}},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewHandler(&test.cfg, "acp@my-ns")
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		desc           string
+		cfg            edge.ACPIPFilterConfig
+		xff            []string
+		wantStatusCode int
+	}{
+		{
+			desc:           "allowed IP",
+			cfg:            edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}},
+			xff:            []string{"10.1.2.3"},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc:           "IP not in allowed ranges",
+			cfg:            edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}},
+			xff:            []string{"192.168.1.1"},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			desc:           "denied ranges take precedence",
+			cfg:            edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}, DeniedRanges: []string{"10.1.0.0/16"}},
+			xff:            []string{"10.1.2.3"},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			desc:           "IP not denied",
+			cfg:            edge.ACPIPFilterConfig{DeniedRanges: []string{"10.1.0.0/16"}},
+			xff:            []string{"10.2.2.3"},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc:           "IPv6",
+			cfg:            edge.ACPIPFilterConfig{AllowedRanges: []string{"2001:db8::/32"}},
+			xff:            []string{"2001:db8::1"},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc:           "spoofed X-Forwarded-For is ignored by default",
+			cfg:            edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}},
+			xff:            []string{"10.1.2.3, 192.168.1.1"},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			desc:           "depth",
+			cfg:            edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}, IPStrategy: &edge.ACPIPStrategy{Depth: 2}},
+			xff:            []string{"10.1.2.3, 172.16.0.1", "192.168.1.1"},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc:           "depth greater than the number of forwarded IPs",
+			cfg:            edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}, IPStrategy: &edge.ACPIPStrategy{Depth: 3}},
+			xff:            []string{"10.1.2.3, 172.16.0.1, 192.168.1.1"},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			desc:           "excluded IPs",
+			cfg:            edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}, IPStrategy: &edge.ACPIPStrategy{ExcludedIPs: []string{"172.16.0.0/12"}}},
+			xff:            []string{"10.1.2.3, 172.16.0.2, 172.16.0.1, 192.168.1.1"},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc:           "all forwarded IPs excluded",
+			cfg:            edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}, IPStrategy: &edge.ACPIPStrategy{ExcludedIPs: []string{"172.16.0.0/12"}}},
+			xff:            []string{"172.16.0.1, 10.1.2.3"},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			desc:           "no X-Forwarded-For",
+			cfg:            edge.ACPIPFilterConfig{DeniedRanges: []string{"10.0.0.0/8"}},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			desc:           "invalid IP",
+			cfg:            edge.ACPIPFilterConfig{DeniedRanges: []string{"10.0.0.0/8"}},
+			xff:            []string{"unknown"},
+			wantStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			handler, err := NewHandler(&test.cfg, "acp@my-ns")
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			for _, xff := range test.xff {
+				req.Header.Add("X-Forwarded-For", xff)
+			}
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.wantStatusCode, rec.Code)
+		})
+	}
+}
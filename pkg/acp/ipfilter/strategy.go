@@ -0,0 +1,152 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package ipfilter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+// Strategy extracts the client IP of requests forwarded to the auth server by Traefik's ForwardAuth middleware.
+//
+// Traefik always appends the address of the peer it received the request from to the X-Forwarded-For header.
+// By default, this address is the client IP. With a depth or excluded IPs, the client IP is looked up in the
+// X-Forwarded-For header Traefik received, the same way Traefik's IPStrategy does.
+type Strategy struct {
+	depth    int
+	excluded ranges
+}
+
+// NewStrategy returns a new Strategy. A nil configuration returns the default strategy.
+func NewStrategy(cfg *edge.ACPIPStrategy) (*Strategy, error) {
+	if cfg == nil {
+		return &Strategy{}, nil
+	}
+
+	if cfg.Depth < 0 {
+		return nil, errors.New("depth must not be negative")
+	}
+
+	if cfg.Depth > 0 && len(cfg.ExcludedIPs) > 0 {
+		return nil, errors.New("depth and excluded IPs cannot be used together")
+	}
+
+	excluded, err := parseRanges(cfg.ExcludedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("parse excluded IPs: %w", err)
+	}
+
+	return &Strategy{
+		depth:    cfg.Depth,
+		excluded: excluded,
+	}, nil
+}
+
+// ForwardedHeaderRequired returns whether the strategy needs the X-Forwarded-For header received by Traefik,
+// which requires the ForwardAuth middleware to trust it.
+func (s *Strategy) ForwardedHeaderRequired() bool {
+	return s.depth > 0 || len(s.excluded) > 0
+}
+
+// ClientIP returns the client IP of the given request. It returns an empty string if it cannot be determined.
+func (s *Strategy) ClientIP(req *http.Request) string {
+	var ips []string
+	for _, val := range req.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(val, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		return ""
+	}
+
+	if !s.ForwardedHeaderRequired() {
+		return ips[len(ips)-1]
+	}
+
+	// The last IP is the peer address added by Traefik, the others come from the header Traefik received.
+	forwarded := ips[:len(ips)-1]
+
+	if s.depth > 0 {
+		if s.depth > len(forwarded) {
+			return ""
+		}
+
+		return forwarded[len(forwarded)-s.depth]
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(forwarded[i])
+		if ip == nil || !s.excluded.contains(ip) {
+			return forwarded[i]
+		}
+	}
+
+	return ""
+}
+
+// ranges is a list of IP ranges.
+type ranges []*net.IPNet
+
+// parseRanges parses the given CIDRs. Single IPs are accepted too.
+func parseRanges(cidrs []string) (ranges, error) {
+	res := make(ranges, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+
+		res = append(res, ipNet)
+	}
+
+	return res, nil
+}
+
+func (r ranges) contains(ip net.IP) bool {
+	for _, ipNet := range r {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
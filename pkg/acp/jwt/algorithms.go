@@ -0,0 +1,107 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/golang-jwt/jwt"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// supportedAlgorithms lists the signing algorithms supported by the handler.
+var supportedAlgorithms = map[string]struct{}{
+	"HS256": {}, "HS384": {}, "HS512": {},
+	"RS256": {}, "RS384": {}, "RS512": {},
+	"PS256": {}, "PS384": {}, "PS512": {},
+	"ES256": {}, "ES384": {}, "ES512": {},
+	"EdDSA": {},
+}
+
+// parseAlgorithms returns the set of allowed algorithms. It returns nil if all supported algorithms are allowed.
+func parseAlgorithms(algs []string) (map[string]struct{}, error) {
+	if len(algs) == 0 {
+		return nil, nil
+	}
+
+	allowed := make(map[string]struct{}, len(algs))
+	for _, alg := range algs {
+		if _, ok := supportedAlgorithms[alg]; !ok {
+			return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+		}
+		allowed[alg] = struct{}{}
+	}
+
+	return allowed, nil
+}
+
+// isAllowedAlgorithm returns whether the given algorithm can be used to sign tokens.
+func (h *Handler) isAllowedAlgorithm(alg string) bool {
+	if _, ok := supportedAlgorithms[alg]; !ok {
+		return false
+	}
+
+	if h.algorithms == nil {
+		return true
+	}
+
+	_, ok := h.algorithms[alg]
+	return ok
+}
+
+// checkJWK makes sure the given JWK can be used to verify a token signed with the given method.
+// It prevents algorithm confusion attacks where a key is used with an algorithm it is not meant for.
+func checkJWK(k *jose.JSONWebKey, method jwt.SigningMethod) error {
+	if k.Algorithm != "" && k.Algorithm != method.Alg() {
+		return fmt.Errorf("key %q is meant for algorithm %q, not %q", k.KeyID, k.Algorithm, method.Alg())
+	}
+
+	if k.Use != "" && k.Use != "sig" {
+		return fmt.Errorf("key %q is not meant for signature verification", k.KeyID)
+	}
+
+	return checkKeyType(k.Key, method)
+}
+
+// checkKeyType makes sure the given public key type matches the given signing method.
+func checkKeyType(key interface{}, method jwt.SigningMethod) error {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if _, ok := key.(*rsa.PublicKey); ok {
+			return nil
+		}
+
+	case *jwt.SigningMethodECDSA:
+		if k, ok := key.(*ecdsa.PublicKey); ok {
+			if k.Curve.Params().BitSize != m.CurveBits {
+				return fmt.Errorf("curve %s cannot be used with algorithm %q", k.Curve.Params().Name, method.Alg())
+			}
+			return nil
+		}
+
+	case *jwt.SigningMethodEd25519:
+		if _, ok := key.(ed25519.PublicKey); ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("key of type %T cannot be used with algorithm %q", key, method.Alg())
+}
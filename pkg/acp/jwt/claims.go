@@ -0,0 +1,141 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Reasons for which the validation of registered claims can fail.
+const (
+	reasonMalformedClaim  = "malformed_claim"
+	reasonExpired         = "expired"
+	reasonNotYetValid     = "not_yet_valid"
+	reasonIssuedInFuture  = "issued_in_future"
+	reasonInvalidIssuer   = "invalid_issuer"
+	reasonInvalidAudience = "invalid_audience"
+)
+
+// claimsError is returned when a registered claim is invalid.
+type claimsError struct {
+	reason string
+	msg    string
+}
+
+func (e claimsError) Error() string {
+	return e.msg
+}
+
+// validateRegisteredClaims validates the `exp`, `nbf`, `iat`, `iss` and `aud` claims.
+func (h *Handler) validateRegisteredClaims(claims jwt.MapClaims, now time.Time) error {
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return claimsError{reason: reasonMalformedClaim, msg: err.Error()}
+	}
+	if ok && now.Add(-h.leeway).After(exp) {
+		return claimsError{reason: reasonExpired, msg: fmt.Sprintf("token expired at %s", exp.UTC())}
+	}
+
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return claimsError{reason: reasonMalformedClaim, msg: err.Error()}
+	}
+	if ok && now.Add(h.leeway).Before(nbf) {
+		return claimsError{reason: reasonNotYetValid, msg: fmt.Sprintf("token is not valid before %s", nbf.UTC())}
+	}
+
+	iat, ok, err := numericDate(claims, "iat")
+	if err != nil {
+		return claimsError{reason: reasonMalformedClaim, msg: err.Error()}
+	}
+	if ok && now.Add(h.leeway).Before(iat) {
+		return claimsError{reason: reasonIssuedInFuture, msg: fmt.Sprintf("token issued in the future at %s", iat.UTC())}
+	}
+
+	if iss, _ := claims["iss"].(string); !h.isAllowedIssuer(iss) {
+		return claimsError{reason: reasonInvalidIssuer, msg: fmt.Sprintf("issuer %v is not allowed", claims["iss"])}
+	}
+
+	if !h.isAllowedAudience(claims) {
+		return claimsError{reason: reasonInvalidAudience, msg: fmt.Sprintf("audience %v is not allowed", claims["aud"])}
+	}
+
+	return nil
+}
+
+// isAllowedAudience returns whether one of the audiences of the `aud` claim is allowed.
+// Any audience is allowed when no audience is configured.
+func (h *Handler) isAllowedAudience(claims jwt.MapClaims) bool {
+	if len(h.audiences) == 0 {
+		return true
+	}
+
+	var auds []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		auds = []string{aud}
+	case []interface{}:
+		for _, v := range aud {
+			str, ok := v.(string)
+			if !ok {
+				return false
+			}
+			auds = append(auds, str)
+		}
+	default:
+		return false
+	}
+
+	for _, aud := range auds {
+		for _, allowed := range h.audiences {
+			if aud == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// numericDate returns the time held by the given claim. It returns false if the claim is not set.
+func numericDate(claims jwt.MapClaims, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	var sec float64
+	switch val := v.(type) {
+	case json.Number:
+		var err error
+		sec, err = val.Float64()
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid %q claim: %w", name, err)
+		}
+	case float64:
+		sec = val
+	default:
+		return time.Time{}, false, fmt.Errorf("invalid %q claim: expected a number, got %T", name, v)
+	}
+
+	return time.Unix(int64(sec), 0), true, nil
+}
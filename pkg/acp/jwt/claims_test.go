@@ -0,0 +1,215 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+func TestHandler_validateRegisteredClaims(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	num := func(offset time.Duration) json.Number {
+		return json.Number(strconv.FormatInt(now.Add(offset).Unix(), 10))
+	}
+
+	tests := []struct {
+		desc       string
+		handler    *Handler
+		claims     jwt.MapClaims
+		wantReason string
+	}{
+		{
+			desc:    "no registered claims",
+			handler: &Handler{},
+			claims:  jwt.MapClaims{},
+		},
+		{
+			desc:    "valid time claims",
+			handler: &Handler{},
+			claims:  jwt.MapClaims{"exp": num(time.Minute), "nbf": num(-time.Minute), "iat": num(-time.Minute)},
+		},
+		{
+			desc:       "expired",
+			handler:    &Handler{},
+			claims:     jwt.MapClaims{"exp": num(-time.Second)},
+			wantReason: reasonExpired,
+		},
+		{
+			desc:    "expired within leeway",
+			handler: &Handler{leeway: time.Minute},
+			claims:  jwt.MapClaims{"exp": num(-30 * time.Second)},
+		},
+		{
+			desc:       "float expiration",
+			handler:    &Handler{},
+			claims:     jwt.MapClaims{"exp": json.Number(strconv.FormatInt(now.Unix()-10, 10) + ".5")},
+			wantReason: reasonExpired,
+		},
+		{
+			desc:       "malformed expiration",
+			handler:    &Handler{},
+			claims:     jwt.MapClaims{"exp": "tomorrow"},
+			wantReason: reasonMalformedClaim,
+		},
+		{
+			desc:       "not yet valid",
+			handler:    &Handler{},
+			claims:     jwt.MapClaims{"nbf": num(time.Second)},
+			wantReason: reasonNotYetValid,
+		},
+		{
+			desc:    "not yet valid within leeway",
+			handler: &Handler{leeway: time.Minute},
+			claims:  jwt.MapClaims{"nbf": num(30 * time.Second)},
+		},
+		{
+			desc:       "issued in the future",
+			handler:    &Handler{},
+			claims:     jwt.MapClaims{"iat": num(time.Second)},
+			wantReason: reasonIssuedInFuture,
+		},
+		{
+			desc:    "allowed issuer",
+			handler: &Handler{issuers: []string{"https://issuer.example.com/"}},
+			claims:  jwt.MapClaims{"iss": "https://issuer.example.com"},
+		},
+		{
+			desc:       "issuer not allowed",
+			handler:    &Handler{issuers: []string{"https://issuer.example.com"}},
+			claims:     jwt.MapClaims{"iss": "https://other.example.com"},
+			wantReason: reasonInvalidIssuer,
+		},
+		{
+			desc:       "missing issuer",
+			handler:    &Handler{issuers: []string{"https://issuer.example.com"}},
+			claims:     jwt.MapClaims{},
+			wantReason: reasonInvalidIssuer,
+		},
+		{
+			desc:    "allowed audience",
+			handler: &Handler{audiences: []string{"api"}},
+			claims:  jwt.MapClaims{"aud": "api"},
+		},
+		{
+			desc:    "allowed audience in an array",
+			handler: &Handler{audiences: []string{"api"}},
+			claims:  jwt.MapClaims{"aud": []interface{}{"web", "api"}},
+		},
+		{
+			desc:       "audience not allowed",
+			handler:    &Handler{audiences: []string{"api"}},
+			claims:     jwt.MapClaims{"aud": []interface{}{"web", "mobile"}},
+			wantReason: reasonInvalidAudience,
+		},
+		{
+			desc:       "missing audience",
+			handler:    &Handler{audiences: []string{"api"}},
+			claims:     jwt.MapClaims{},
+			wantReason: reasonInvalidAudience,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			err := test.handler.validateRegisteredClaims(test.claims, now)
+			if test.wantReason == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var claimsErr claimsError
+			require.True(t, errors.As(err, &claimsErr))
+			assert.Equal(t, test.wantReason, claimsErr.reason)
+		})
+	}
+}
+
+func TestServeHTTP_registeredClaims(t *testing.T) {
+	sign := func(t *testing.T, claims jwt.MapClaims) string {
+		t.Helper()
+
+		tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("bibi"))
+		require.NoError(t, err)
+
+		return tok
+	}
+
+	tests := []struct {
+		desc           string
+		jwtCfg         edge.ACPJWTConfig
+		claims         jwt.MapClaims
+		wantStatusCode int
+	}{
+		{
+			desc:           "expired token is accepted within leeway",
+			jwtCfg:         edge.ACPJWTConfig{SigningSecret: "bibi", Leeway: time.Minute},
+			claims:         jwt.MapClaims{"exp": time.Now().Add(-30 * time.Second).Unix()},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc:           "expired token is rejected without leeway",
+			jwtCfg:         edge.ACPJWTConfig{SigningSecret: "bibi"},
+			claims:         jwt.MapClaims{"exp": time.Now().Add(-30 * time.Second).Unix()},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "audience is checked before custom claims",
+			jwtCfg:         edge.ACPJWTConfig{SigningSecret: "bibi", Audiences: []string{"api"}, Claims: "Equals(`grp`, `admin`)"},
+			claims:         jwt.MapClaims{"aud": "web", "grp": "dev"},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "issuer and audience are valid",
+			jwtCfg:         edge.ACPJWTConfig{SigningSecret: "bibi", Issuers: []string{"https://issuer.example.com"}, Audiences: []string{"api"}},
+			claims:         jwt.MapClaims{"iss": "https://issuer.example.com", "aud": []string{"web", "api"}},
+			wantStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			handler, err := NewHandler(&test.jwtCfg, "acp@my-ns")
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.Header.Set("Authorization", "Bearer "+sign(t, test.claims))
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.wantStatusCode, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pquerna/cachecontrol"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultMetadataTTL is how long provider metadata is cached when the provider doesn't send cache control headers.
+const defaultMetadataTTL = time.Hour
+
+// ProviderMetadata holds the OpenID Provider metadata used by the ACP handlers.
+type ProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKsURI               string `json:"jwks_uri"`
+}
+
+// Discovery fetches the metadata of an OpenID Provider, and keeps it up to date.
+//
+// Like keys, expired metadata is served while it is refreshed in the background, and for a grace period if the
+// refresh fails.
+type Discovery struct {
+	issuer          string
+	client          *http.Client
+	keySetCfg       RemoteKeySetConfig
+	gracePeriod     time.Duration
+	refetchInterval time.Duration
+
+	mu        sync.Mutex
+	metadata  *ProviderMetadata
+	keySet    *RemoteKeySet
+	expiry    time.Time
+	lastFetch time.Time
+	lastErr   error
+	updating  *inflight
+}
+
+// NewDiscovery returns a Discovery for the given issuer. The key set it advertises is kept up to date with the
+// given configuration, whose grace period and refetch interval also apply to the metadata.
+func NewDiscovery(issuer string, keySetCfg RemoteKeySetConfig) *Discovery {
+	gracePeriod := keySetCfg.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultJWKsGracePeriod
+	}
+
+	refetchInterval := keySetCfg.RefetchInterval
+	if refetchInterval == 0 {
+		refetchInterval = defaultJWKsRefetchInterval
+	}
+
+	return &Discovery{
+		issuer:          normalizeIssuer(issuer),
+		client:          &http.Client{Timeout: 5 * time.Second},
+		keySetCfg:       keySetCfg,
+		gracePeriod:     gracePeriod,
+		refetchInterval: refetchInterval,
+	}
+}
+
+// Metadata returns the provider metadata, fetching it if it is unknown or expired.
+func (d *Discovery) Metadata(ctx context.Context) (*ProviderMetadata, error) {
+	metadata, _, err := d.refresh(ctx)
+	return metadata, err
+}
+
+// KeySet returns the key set advertised by the provider metadata.
+func (d *Discovery) KeySet(ctx context.Context) (*RemoteKeySet, error) {
+	_, keySet, err := d.refresh(ctx)
+	return keySet, err
+}
+
+// refresh returns the metadata to use, and the key set it advertises, waiting for it to be fetched if there is no
+// usable one.
+func (d *Discovery) refresh(ctx context.Context) (*ProviderMetadata, *RemoteKeySet, error) {
+	d.mu.Lock()
+
+	now := time.Now()
+	if d.metadata != nil && now.Before(d.expiry.Add(d.gracePeriod)) {
+		// Expired metadata is served while being refreshed. Failed refreshes are only retried after a while.
+		if !now.Before(d.expiry) && d.updating == nil && (d.lastErr == nil || now.Sub(d.lastFetch) >= d.refetchInterval) {
+			d.fetch()
+		}
+
+		metadata, keySet := d.metadata, d.keySet
+		d.mu.Unlock()
+
+		return metadata, keySet, nil
+	}
+
+	if d.updating == nil {
+		if d.lastErr != nil && now.Sub(d.lastFetch) < d.refetchInterval {
+			err := d.lastErr
+			d.mu.Unlock()
+
+			return nil, nil, err
+		}
+
+		d.fetch()
+	}
+
+	updating := d.updating
+	d.mu.Unlock()
+
+	if err := updating.Wait(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.metadata, d.keySet, nil
+}
+
+// fetch fetches the metadata in the background. It must be called with the lock held.
+// The fetch is detached from the requests waiting for it, so a cancelled request doesn't abort it for the others.
+func (d *Discovery) fetch() {
+	updating := newInflight()
+	d.updating = updating
+	d.lastFetch = time.Now()
+
+	go func() {
+		metadata, expiry, err := fetchMetadata(context.Background(), d.client, d.issuer)
+		if err != nil {
+			log.Warn().Err(err).Str("issuer", d.issuer).Msg("Unable to refresh provider metadata")
+		}
+
+		d.mu.Lock()
+		if err == nil {
+			if d.keySet == nil || d.keySet.url != metadata.JWKsURI {
+				d.keySet = NewRemoteKeySet(metadata.JWKsURI, d.keySetCfg)
+			}
+			d.metadata = metadata
+			d.expiry = expiry
+		}
+		d.lastErr = err
+		d.updating = nil
+		d.mu.Unlock()
+
+		updating.Done(err)
+	}()
+}
+
+func fetchMetadata(ctx context.Context, client *http.Client, issuer string) (*ProviderMetadata, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", http.NoBody)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to build discovery request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to fetch provider metadata: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("unexpected status code %q", resp.Status)
+	}
+
+	var metadata ProviderMetadata
+	if err = json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to decode provider metadata: %w", err)
+	}
+
+	if normalizeIssuer(metadata.Issuer) != issuer {
+		return nil, time.Time{}, fmt.Errorf("issuer %q does not match the expected issuer %q", metadata.Issuer, issuer)
+	}
+
+	if metadata.JWKsURI == "" {
+		return nil, time.Time{}, errors.New("provider metadata has no JWKs URI")
+	}
+
+	expiry := time.Now().Add(defaultMetadataTTL)
+	_, e, err := cachecontrol.CachableResponse(req, resp, cachecontrol.Options{})
+	if err == nil && e.After(time.Now()) {
+		expiry = e
+	}
+
+	return &metadata, expiry, nil
+}
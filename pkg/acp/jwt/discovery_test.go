@@ -0,0 +1,164 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package jwt_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/jwt"
+)
+
+func TestDiscovery_FetchesAndCachesMetadata(t *testing.T) {
+	var hdlrCalled int
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		hdlrCalled++
+
+		_ = json.NewEncoder(rw).Encode(jwt.ProviderMetadata{
+			Issuer:  srv.URL,
+			JWKsURI: srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Add("Cache-Control", "max-age=600")
+		_, _ = rw.Write([]byte(jwkeys))
+	})
+
+	d := jwt.NewDiscovery(srv.URL+"/", jwt.RemoteKeySetConfig{})
+
+	metadata, err := d.Metadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/jwks", metadata.JWKsURI)
+
+	ks, err := d.KeySet(context.Background())
+	require.NoError(t, err)
+
+	key, err := ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+	assert.NotNil(t, key)
+
+	assert.Equal(t, 1, hdlrCalled)
+}
+
+func TestDiscovery_RejectsIssuerMismatch(t *testing.T) {
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode(jwt.ProviderMetadata{
+			Issuer:  "https://evil.example.com",
+			JWKsURI: "https://evil.example.com/jwks",
+		})
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	d := jwt.NewDiscovery(srv.URL, jwt.RemoteKeySetConfig{})
+
+	_, err := d.Metadata(context.Background())
+	assert.Error(t, err)
+}
+
+func TestDiscovery_ServesStaleMetadataWhileProviderIsDown(t *testing.T) {
+	var (
+		down       int32
+		hdlrCalled int32
+	)
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hdlrCalled, 1)
+
+		if atomic.LoadInt32(&down) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		rw.Header().Add("Cache-Control", "max-age=1")
+		_ = json.NewEncoder(rw).Encode(jwt.ProviderMetadata{
+			Issuer:  srv.URL,
+			JWKsURI: srv.URL + "/jwks",
+		})
+	})
+
+	d := jwt.NewDiscovery(srv.URL, jwt.RemoteKeySetConfig{GracePeriod: time.Minute})
+
+	_, err := d.Metadata(context.Background())
+	require.NoError(t, err)
+
+	atomic.StoreInt32(&down, 1)
+	time.Sleep(1100 * time.Millisecond)
+
+	// The expired metadata is served while it cannot be refreshed.
+	for i := 0; i < 3; i++ {
+		metadata, err := d.Metadata(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, srv.URL+"/jwks", metadata.JWKsURI)
+	}
+
+	// Failed refreshes are only retried after a while.
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&hdlrCalled) == 2 }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hdlrCalled))
+}
+
+func TestDiscovery_CancelledRequestDoesNotAbortFetch(t *testing.T) {
+	var hdlrCalled int32
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hdlrCalled, 1)
+
+		time.Sleep(50 * time.Millisecond)
+		_ = json.NewEncoder(rw).Encode(jwt.ProviderMetadata{
+			Issuer:  srv.URL,
+			JWKsURI: srv.URL + "/jwks",
+		})
+	})
+
+	d := jwt.NewDiscovery(srv.URL, jwt.RemoteKeySetConfig{})
+
+	// The request is cancelled while the metadata is being fetched.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := d.Metadata(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	metadata, err := d.Metadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/jwks", metadata.JWKsURI)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hdlrCalled))
+}
@@ -0,0 +1,161 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// HeaderRenderer renders the headers to forward from a set of claims.
+//
+// A header value is either the name of a claim, or a Go template rendered with the claims, such as
+// `{{.sub}}@{{.tenant}}`. Objects and arrays are rendered as JSON, as they would be with the json function:
+// `{{.groups}}` and `{{json .groups}}` render the same value.
+type HeaderRenderer struct {
+	headers map[string]headerSource
+}
+
+type headerSource struct {
+	claim       string
+	tmpl        *template.Template
+	fallback    string
+	hasFallback bool
+}
+
+// NewHeaderRenderer returns a HeaderRenderer for the given headers. Defaults are the values of the headers which
+// cannot be rendered, for instance because a claim is missing.
+func NewHeaderRenderer(headers, defaults map[string]string) (*HeaderRenderer, error) {
+	for name := range defaults {
+		if _, ok := headers[name]; !ok {
+			return nil, fmt.Errorf("default value for header %q which is not forwarded", name)
+		}
+	}
+
+	sources := make(map[string]headerSource, len(headers))
+	for name, value := range headers {
+		src := headerSource{claim: value}
+		src.fallback, src.hasFallback = defaults[name]
+
+		if strings.Contains(value, "{{") {
+			tmpl, err := template.New(name).
+				Option("missingkey=error").
+				Funcs(template.FuncMap{"json": toJSON}).
+				Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("parse template of header %q: %w", name, err)
+			}
+
+			src = headerSource{tmpl: tmpl, fallback: src.fallback, hasFallback: src.hasFallback}
+		}
+
+		sources[name] = src
+	}
+
+	return &HeaderRenderer{headers: sources}, nil
+}
+
+// Render renders the headers for the given claims. Headers which cannot be rendered get their default value. Those
+// without default value are skipped, and an error is returned for each of them.
+func (r *HeaderRenderer) Render(claims map[string]interface{}) (map[string][]string, []error) {
+	result := make(map[string][]string, len(r.headers))
+
+	var errs []error
+	for name, src := range r.headers {
+		vals, err := src.render(claims)
+		if err != nil || len(vals) == 0 {
+			if src.hasFallback {
+				result[name] = []string{src.fallback}
+				continue
+			}
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("render header %q: %w", name, err))
+			}
+			continue
+		}
+
+		result[name] = vals
+	}
+
+	return result, errs
+}
+
+func (s headerSource) render(claims map[string]interface{}) ([]string, error) {
+	if s.tmpl == nil {
+		return PluckClaim(s.claim, claims)
+	}
+
+	var buf bytes.Buffer
+	if err := s.tmpl.Execute(&buf, toTemplateValue(claims)); err != nil {
+		return nil, err
+	}
+
+	return []string{buf.String()}, nil
+}
+
+// jsonObject and jsonArray are the objects and arrays of the claims given to templates. They are printed as JSON,
+// instead of the Go syntax, while their fields and elements can still be accessed.
+type (
+	jsonObject map[string]interface{}
+	jsonArray  []interface{}
+)
+
+func (o jsonObject) String() string {
+	s, _ := toJSON(o)
+	return s
+}
+
+func (a jsonArray) String() string {
+	s, _ := toJSON(a)
+	return s
+}
+
+// toTemplateValue returns the given claim value with its objects and arrays, at any depth, printed as JSON.
+func toTemplateValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		obj := make(jsonObject, len(val))
+		for k, elem := range val {
+			obj[k] = toTemplateValue(elem)
+		}
+		return obj
+
+	case []interface{}:
+		arr := make(jsonArray, len(val))
+		for i, elem := range val {
+			arr[i] = toTemplateValue(elem)
+		}
+		return arr
+
+	default:
+		return v
+	}
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
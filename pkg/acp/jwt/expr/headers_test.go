@@ -0,0 +1,152 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHeaderRenderer(t *testing.T) {
+	tests := []struct {
+		desc     string
+		headers  map[string]string
+		defaults map[string]string
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{
+			desc:     "claims and templates",
+			headers:  map[string]string{"Sub": "sub", "User": "{{.sub}}@{{.tenant}}"},
+			defaults: map[string]string{"User": "anonymous"},
+			wantErr:  assert.NoError,
+		},
+		{
+			desc:    "invalid template",
+			headers: map[string]string{"User": "{{.sub"},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "unknown template function",
+			headers: map[string]string{"User": "{{upper .sub}}"},
+			wantErr: assert.Error,
+		},
+		{
+			desc:     "default for a header not forwarded",
+			headers:  map[string]string{"Sub": "sub"},
+			defaults: map[string]string{"User": "anonymous"},
+			wantErr:  assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewHeaderRenderer(test.headers, test.defaults)
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestHeaderRenderer_Render(t *testing.T) {
+	claims := `{
+		"sub": "john",
+		"tenant": "acme",
+		"age": 42,
+		"groups": ["admin", "dev"],
+		"org": {"name": "acme", "teams": [{"id": 1}]},
+		"empty": null
+	}`
+
+	tests := []struct {
+		desc       string
+		headers    map[string]string
+		defaults   map[string]string
+		wantHeader map[string][]string
+		wantErrs   int
+	}{
+		{
+			desc:       "claim",
+			headers:    map[string]string{"Sub": "sub", "Groups": "groups", "Team": "org.teams"},
+			wantHeader: map[string][]string{"Sub": {"john"}, "Groups": {"admin", "dev"}, "Team": {`[{"id":1}]`}},
+		},
+		{
+			desc:       "template combining claims",
+			headers:    map[string]string{"User": "{{.sub}}@{{.tenant}}", "Age": "{{.age}}", "Org": "{{.org.name}}"},
+			wantHeader: map[string][]string{"User": {"john@acme"}, "Age": {"42"}, "Org": {"acme"}},
+		},
+		{
+			desc:       "template serializing objects and arrays as JSON",
+			headers:    map[string]string{"Groups": "{{json .groups}}", "Org": "{{json .org}}"},
+			wantHeader: map[string][]string{"Groups": {`["admin","dev"]`}, "Org": {`{"name":"acme","teams":[{"id":1}]}`}},
+		},
+		{
+			desc:       "template rendering objects and arrays as JSON by default",
+			headers:    map[string]string{"Groups": "{{.groups}}", "Org": "{{.org}}", "Teams": "{{.org.teams}}", "Group": "{{index .groups 0}}"},
+			wantHeader: map[string][]string{"Groups": {`["admin","dev"]`}, "Org": {`{"name":"acme","teams":[{"id":1}]}`}, "Teams": {`[{"id":1}]`}, "Group": {"admin"}},
+		},
+		{
+			desc:       "missing claim is skipped",
+			headers:    map[string]string{"Sub": "sub", "Email": "email"},
+			wantHeader: map[string][]string{"Sub": {"john"}},
+		},
+		{
+			desc:       "template which cannot be rendered is skipped",
+			headers:    map[string]string{"Sub": "sub", "User": "{{.sub}}@{{.domain}}"},
+			wantHeader: map[string][]string{"Sub": {"john"}},
+			wantErrs:   1,
+		},
+		{
+			desc:       "unsupported claim is skipped",
+			headers:    map[string]string{"Sub": "sub", "Empty": "empty"},
+			wantHeader: map[string][]string{"Sub": {"john"}},
+			wantErrs:   1,
+		},
+		{
+			desc:       "defaults",
+			headers:    map[string]string{"User": "{{.sub}}@{{.domain}}", "Email": "email", "Empty": "empty", "Sub": "sub"},
+			defaults:   map[string]string{"User": "anonymous", "Email": "unknown", "Empty": "none", "Sub": "nobody"},
+			wantHeader: map[string][]string{"User": {"anonymous"}, "Email": {"unknown"}, "Empty": {"none"}, "Sub": {"john"}},
+		},
+	}
+
+	var parsedClaims map[string]interface{}
+	dec := json.NewDecoder(bytes.NewBufferString(claims))
+	dec.UseNumber()
+	require.NoError(t, dec.Decode(&parsedClaims))
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			renderer, err := NewHeaderRenderer(test.headers, test.defaults)
+			require.NoError(t, err)
+
+			got, errs := renderer.Render(parsedClaims)
+
+			assert.Equal(t, test.wantHeader, got)
+			assert.Len(t, errs, test.wantErrs)
+		})
+	}
+}
@@ -3,17 +3,88 @@ package expr
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/vulcand/predicate"
 )
 
 // Predicate represents a function that can be evaluated to get the result of an expression.
-type Predicate func(a map[string]interface{}) bool
+type Predicate func(in Input) bool
+
+// Input is what expressions are evaluated against: the claims of the authenticated identity and the attributes of
+// the request being authorized.
+type Input struct {
+	Claims  map[string]interface{}
+	Request Request
+}
+
+// Request holds the attributes of the request being authorized.
+type Request struct {
+	Method string
+	Host   string
+	Path   string
+}
+
+// NewRequest returns the attributes of the request being authorized, as forwarded by Traefik's ForwardAuth
+// middleware in the given auth request. Attributes which are not forwarded, or cannot be parsed, are left empty.
+func NewRequest(req *http.Request) Request {
+	r := Request{
+		Method: req.Header.Get("X-Forwarded-Method"),
+		Host:   req.Header.Get("X-Forwarded-Host"),
+	}
+
+	if u, err := url.ParseRequestURI(req.Header.Get("X-Forwarded-Uri")); err == nil {
+		r.Path = cleanPath(u.Path)
+	}
+
+	return r
+}
+
+// known returns whether all the attributes of the request are known.
+func (r Request) known() bool {
+	return r.Method != "" && r.Host != "" && r.Path != ""
+}
+
+// cleanPath returns the given path with its dot segments resolved, the way the backend would resolve it, so they
+// cannot be used to bypass path predicates. The trailing slash is kept.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
 
 // Parse returns a predicate from the given expression.
 func Parse(expr string) (Predicate, error) {
+	pred, _, err := parse(expr)
+	return pred, err
+}
+
+// DependsOnRequest returns whether the given expression depends on the attributes of the request being authorized,
+// or on the time it is authorized at, and not only on claims. Decisions based on such expressions cannot be reused
+// for other requests.
+func DependsOnRequest(expr string) bool {
+	_, dependsOnReq, err := parse(expr)
+
+	return err == nil && dependsOnReq
+}
+
+func parse(expr string) (Predicate, bool, error) {
+	// Request and time functions are called while parsing, which tells whether the expression depends on the request.
+	var usesRequest, usesTime bool
+
 	parser, err := predicate.NewParser(predicate.Def{
 		Operators: predicate.Operators{
 			AND: andFunc,
@@ -26,41 +97,74 @@ func Parse(expr string) (Predicate, error) {
 			"Contains":      contains,
 			"SplitContains": splitContains,
 			"Ohubf":         ohubf,
+			"Exists":        exists,
+			"GreaterThan":   greaterThan,
+			"LessThan":      lessThan,
+			"Matches":       matchesRegexp,
+			"OlderThan": func(claimName, duration string) (Predicate, error) {
+				usesTime = true
+				return olderThan(claimName, duration)
+			},
+			"NotOlderThan": func(claimName, duration string) (Predicate, error) {
+				usesTime = true
+				return notOlderThan(claimName, duration)
+			},
+			"Method": func(expected ...string) Predicate {
+				usesRequest = true
+				return method(expected...)
+			},
+			"Host": func(expected ...string) Predicate {
+				usesRequest = true
+				return host(expected...)
+			},
+			"PathPrefix": func(expected string) Predicate {
+				usesRequest = true
+				return pathPrefix(expected)
+			},
 		},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to create parser: %w", err)
+		return nil, false, fmt.Errorf("unable to create parser: %w", err)
 	}
 
 	p, err := parser.Parse(expr)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse expression: %w", err)
+		return nil, false, fmt.Errorf("unable to parse expression: %w", err)
 	}
 
-	return p.(Predicate), nil
+	pred := p.(Predicate)
+	if usesRequest {
+		// Requests whose attributes are unknown are denied, even when request functions are negated.
+		evaluate := pred
+		pred = func(in Input) bool {
+			return in.Request.known() && evaluate(in)
+		}
+	}
+
+	return pred, usesRequest || usesTime, nil
 }
 
 func andFunc(a, b Predicate) Predicate {
-	return func(v map[string]interface{}) bool {
-		return a(v) && b(v)
+	return func(in Input) bool {
+		return a(in) && b(in)
 	}
 }
 
 func orFunc(a, b Predicate) Predicate {
-	return func(v map[string]interface{}) bool {
-		return a(v) || b(v)
+	return func(in Input) bool {
+		return a(in) || b(in)
 	}
 }
 
 func notFunc(a Predicate) Predicate {
-	return func(v map[string]interface{}) bool {
-		return !a(v)
+	return func(in Input) bool {
+		return !a(in)
 	}
 }
 
 func equals(claimName, expected string) Predicate {
-	return func(claims map[string]interface{}) bool {
-		claim, ok := resolve(claimName, claims)
+	return func(in Input) bool {
+		claim, ok := resolve(claimName, in.Claims)
 		if !ok {
 			return false
 		}
@@ -70,8 +174,8 @@ func equals(claimName, expected string) Predicate {
 }
 
 func prefix(claimName, expected string) Predicate {
-	return func(claims map[string]interface{}) bool {
-		claim, ok := resolve(claimName, claims)
+	return func(in Input) bool {
+		claim, ok := resolve(claimName, in.Claims)
 		if !ok {
 			return false
 		}
@@ -86,8 +190,8 @@ func prefix(claimName, expected string) Predicate {
 }
 
 func contains(claimName, expected string) Predicate {
-	return func(claims map[string]interface{}) bool {
-		claim, ok := resolve(claimName, claims)
+	return func(in Input) bool {
+		claim, ok := resolve(claimName, in.Claims)
 		if !ok {
 			return false
 		}
@@ -111,8 +215,8 @@ func contains(claimName, expected string) Predicate {
 }
 
 func splitContains(claimName, sep, expected string) Predicate {
-	return func(claims map[string]interface{}) bool {
-		claim, ok := resolve(claimName, claims)
+	return func(in Input) bool {
+		claim, ok := resolve(claimName, in.Claims)
 		if !ok {
 			return false
 		}
@@ -133,8 +237,8 @@ func splitContains(claimName, sep, expected string) Predicate {
 }
 
 func ohubf(claimName string, expected ...string) Predicate {
-	return func(claims map[string]interface{}) bool {
-		claim, ok := resolve(claimName, claims)
+	return func(in Input) bool {
+		claim, ok := resolve(claimName, in.Claims)
 		if !ok {
 			return false
 		}
@@ -162,6 +266,163 @@ func ohubf(claimName string, expected ...string) Predicate {
 	}
 }
 
+func exists(claimName string) Predicate {
+	return func(in Input) bool {
+		_, ok := resolve(claimName, in.Claims)
+		return ok
+	}
+}
+
+func greaterThan(claimName, expected string) (Predicate, error) {
+	exp, err := strconv.ParseFloat(expected, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q for GreaterThan: %w", expected, err)
+	}
+
+	return func(in Input) bool {
+		claim, ok := resolve(claimName, in.Claims)
+		if !ok {
+			return false
+		}
+
+		val, ok := number(claim)
+		return ok && val > exp
+	}, nil
+}
+
+func lessThan(claimName, expected string) (Predicate, error) {
+	exp, err := strconv.ParseFloat(expected, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q for LessThan: %w", expected, err)
+	}
+
+	return func(in Input) bool {
+		claim, ok := resolve(claimName, in.Claims)
+		if !ok {
+			return false
+		}
+
+		val, ok := number(claim)
+		return ok && val < exp
+	}, nil
+}
+
+func matchesRegexp(claimName, expr string) (Predicate, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q for Matches: %w", expr, err)
+	}
+
+	return func(in Input) bool {
+		claim, ok := resolve(claimName, in.Claims)
+		if !ok {
+			return false
+		}
+
+		switch val := claim.(type) {
+		case []interface{}:
+			for _, v := range val {
+				if str, ok := v.(string); ok && re.MatchString(str) {
+					return true
+				}
+			}
+			return false
+
+		case string:
+			return re.MatchString(val)
+
+		default:
+			return false
+		}
+	}, nil
+}
+
+// olderThan returns a predicate checking the time held by the given claim, as a NumericDate, is older than the
+// given duration.
+func olderThan(claimName, duration string) (Predicate, error) {
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration %q for OlderThan: %w", duration, err)
+	}
+
+	return func(in Input) bool {
+		t, ok := claimTime(claimName, in.Claims)
+		return ok && time.Since(t) > d
+	}, nil
+}
+
+// notOlderThan returns a predicate checking the time held by the given claim, as a NumericDate, is not older than
+// the given duration.
+func notOlderThan(claimName, duration string) (Predicate, error) {
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration %q for NotOlderThan: %w", duration, err)
+	}
+
+	return func(in Input) bool {
+		t, ok := claimTime(claimName, in.Claims)
+		return ok && time.Since(t) <= d
+	}, nil
+}
+
+func method(expected ...string) Predicate {
+	return func(in Input) bool {
+		for _, exp := range expected {
+			if strings.EqualFold(in.Request.Method, exp) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func host(expected ...string) Predicate {
+	return func(in Input) bool {
+		for _, exp := range expected {
+			if strings.EqualFold(in.Request.Host, exp) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func pathPrefix(expected string) Predicate {
+	return func(in Input) bool {
+		return strings.HasPrefix(in.Request.Path, expected)
+	}
+}
+
+// number returns the numeric value of the given claim.
+func number(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case json.Number:
+		f, err := val.Float64()
+		return f, err == nil
+
+	case float64:
+		return val, true
+
+	default:
+		return 0, false
+	}
+}
+
+// claimTime returns the time held by the given claim, as a NumericDate.
+func claimTime(claimName string, claims map[string]interface{}) (time.Time, bool) {
+	claim, ok := resolve(claimName, claims)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	secs, ok := number(claim)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, int64(secs*float64(time.Second))), true
+}
+
 func matches(v interface{}, expected string) bool {
 	switch val := v.(type) {
 	case string:
@@ -194,7 +455,7 @@ func resolve(claimName string, claims map[string]interface{}) (interface{}, bool
 		switch val := got.(type) {
 		case map[string]interface{}:
 			if isLast {
-				return nil, false
+				return val, true
 			}
 
 			v = val
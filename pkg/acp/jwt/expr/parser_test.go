@@ -3,7 +3,11 @@ package expr
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -196,6 +200,66 @@ func TestValidateCustomClaims(t *testing.T) {
 			expr:   "Equals(``, `bruce`)",
 			want:   false,
 		},
+		{
+			desc:   "exists",
+			claims: `{"user":{"role":"batman"}}`,
+			expr:   "Exists(`user.role`) && !Exists(`user.name`)",
+			want:   true,
+		},
+		{
+			desc:   "greater than",
+			claims: `{"level":3,"ratio":0.5}`,
+			expr:   "GreaterThan(`level`, `2`) && GreaterThan(`ratio`, `0.25`) && !GreaterThan(`level`, `3`)",
+			want:   true,
+		},
+		{
+			desc:   "less than",
+			claims: `{"level":3,"ratio":0.5}`,
+			expr:   "LessThan(`level`, `4`) && LessThan(`ratio`, `0.75`) && !LessThan(`level`, `3`)",
+			want:   true,
+		},
+		{
+			desc:   "numeric comparison on a string",
+			claims: `{"level":"3"}`,
+			expr:   "GreaterThan(`level`, `2`)",
+			want:   false,
+		},
+		{
+			desc:   "matches",
+			claims: `{"email":"bruce@wayne.com"}`,
+			expr:   "Matches(`email`, `^[a-z]+@wayne\\.com$`)",
+			want:   true,
+		},
+		{
+			desc:   "matches an array",
+			claims: `{"groups":["dev","ops-admin"]}`,
+			expr:   "Matches(`groups`, `-admin$`)",
+			want:   true,
+		},
+		{
+			desc:   "does not match",
+			claims: `{"email":"bruce@wayne.com.evil"}`,
+			expr:   "Matches(`email`, `@wayne\\.com$`)",
+			want:   false,
+		},
+		{
+			desc:   "not older than",
+			claims: fmt.Sprintf(`{"auth_time":%d}`, time.Now().Add(-time.Minute).Unix()),
+			expr:   "NotOlderThan(`auth_time`, `5m`) && !OlderThan(`auth_time`, `5m`)",
+			want:   true,
+		},
+		{
+			desc:   "older than",
+			claims: fmt.Sprintf(`{"iat":%d}`, time.Now().Add(-time.Hour).Unix()),
+			expr:   "OlderThan(`iat`, `5m`) && !NotOlderThan(`iat`, `5m`)",
+			want:   true,
+		},
+		{
+			desc:   "time-relative check on a missing claim",
+			claims: `{}`,
+			expr:   "NotOlderThan(`auth_time`, `5m`) || OlderThan(`auth_time`, `5m`)",
+			want:   false,
+		},
 	}
 	for _, test := range tests {
 		test := test
@@ -211,7 +275,143 @@ func TestValidateCustomClaims(t *testing.T) {
 			err = dec.Decode(&claims)
 			require.NoError(t, err)
 
-			assert.Equal(t, test.want, pred(claims))
+			assert.Equal(t, test.want, pred(Input{Claims: claims}))
+		})
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	// Admins can POST, everyone can GET.
+	const expr = "Method(`GET`) || (Method(`POST`) && Contains(`groups`, `admin`) && PathPrefix(`/api/`) && Host(`api.example.com`))"
+
+	tests := []struct {
+		desc   string
+		groups []interface{}
+		req    Request
+		want   bool
+	}{
+		{
+			desc: "GET",
+			req:  Request{Method: http.MethodGet, Host: "api.example.com", Path: "/api/users"},
+			want: true,
+		},
+		{
+			desc:   "POST by an admin",
+			groups: []interface{}{"admin"},
+			req:    Request{Method: http.MethodPost, Host: "API.example.com", Path: "/api/users"},
+			want:   true,
+		},
+		{
+			desc: "POST by a user",
+			req:  Request{Method: http.MethodPost, Host: "api.example.com", Path: "/api/users"},
+			want: false,
+		},
+		{
+			desc:   "POST by an admin on another path",
+			groups: []interface{}{"admin"},
+			req:    Request{Method: http.MethodPost, Host: "api.example.com", Path: "/admin"},
+			want:   false,
+		},
+		{
+			desc:   "POST by an admin on another host",
+			groups: []interface{}{"admin"},
+			req:    Request{Method: http.MethodPost, Host: "example.com", Path: "/api/users"},
+			want:   false,
+		},
+	}
+
+	pred, err := Parse(expr)
+	require.NoError(t, err)
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			in := Input{
+				Claims:  map[string]interface{}{"groups": test.groups},
+				Request: test.req,
+			}
+			assert.Equal(t, test.want, pred(in))
+		})
+	}
+}
+
+func TestParse_invalidArguments(t *testing.T) {
+	exprs := []string{
+		"GreaterThan(`level`, `high`)",
+		"LessThan(`level`, `low`)",
+		"Matches(`email`, `(`)",
+		"OlderThan(`iat`, `yesterday`)",
+		"NotOlderThan(`iat`, `5`)",
+	}
+
+	for _, expr := range exprs {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestDependsOnRequest(t *testing.T) {
+	assert.False(t, DependsOnRequest("Equals(`grp`, `admin`)"))
+	assert.False(t, DependsOnRequest("invalid("))
+	assert.True(t, DependsOnRequest("Equals(`grp`, `admin`) || Method(`GET`)"))
+	assert.True(t, DependsOnRequest("!PathPrefix(`/admin`)"))
+	assert.True(t, DependsOnRequest("Host(`example.com`)"))
+	assert.True(t, DependsOnRequest("OlderThan(`iat`, `1h`)"))
+	assert.True(t, DependsOnRequest("Equals(`grp`, `admin`) && NotOlderThan(`auth_time`, `5m`)"))
+}
+
+func TestParse_unknownRequest(t *testing.T) {
+	pred, err := Parse("!PathPrefix(`/admin`)")
+	require.NoError(t, err)
+
+	assert.True(t, pred(Input{Request: Request{Method: http.MethodGet, Host: "api.example.com", Path: "/api"}}))
+	assert.False(t, pred(Input{Request: Request{Method: http.MethodGet, Host: "api.example.com"}}))
+
+	pred, err = Parse("Equals(`grp`, `admin`)")
+	require.NoError(t, err)
+
+	assert.True(t, pred(Input{Claims: map[string]interface{}{"grp": "admin"}}))
+}
+
+func TestNewRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://auth.local/my-acp", http.NoBody)
+
+	// The attributes of the auth request itself must never be used.
+	assert.Equal(t, Request{}, NewRequest(req))
+
+	req.Header.Set("X-Forwarded-Method", http.MethodPost)
+	req.Header.Set("X-Forwarded-Host", "api.example.com")
+	req.Header.Set("X-Forwarded-Uri", "/api/users?page=2")
+
+	assert.Equal(t, Request{Method: http.MethodPost, Host: "api.example.com", Path: "/api/users"}, NewRequest(req))
+}
+
+func TestNewRequest_path(t *testing.T) {
+	tests := []struct {
+		uri      string
+		wantPath string
+	}{
+		{uri: "/public/../admin", wantPath: "/admin"},
+		{uri: "/public/%2e%2e/admin", wantPath: "/admin"},
+		{uri: "/public/./docs//index.html", wantPath: "/public/docs/index.html"},
+		{uri: "/../../admin", wantPath: "/admin"},
+		{uri: "/api/", wantPath: "/api/"},
+		{uri: "https://api.example.com", wantPath: "/"},
+		{uri: "not a URI", wantPath: ""},
+		{uri: "", wantPath: ""},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.uri, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "http://auth.local/my-acp", http.NoBody)
+			req.Header.Set("X-Forwarded-Uri", test.uri)
+
+			assert.Equal(t, test.wantPath, NewRequest(req).Path)
 		})
 	}
 }
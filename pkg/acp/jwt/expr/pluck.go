@@ -7,6 +7,8 @@ import (
 )
 
 // PluckClaim returns the claim with a given name from a set of claims.
+// Arrays of strings, numbers or booleans give a value per item. Objects, and arrays holding objects or arrays,
+// are serialized as JSON.
 func PluckClaim(selection string, claims map[string]interface{}) ([]string, error) {
 	claimVal, ok := resolve(selection, claims)
 	if !ok {
@@ -15,11 +17,24 @@ func PluckClaim(selection string, claims map[string]interface{}) ([]string, erro
 
 	var result []string
 	switch val := claimVal.(type) {
+	case map[string]interface{}:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, string(b))
+
 	case []interface{}:
 		for _, v := range val {
 			strVal, err := toStr(v)
 			if err != nil {
-				return nil, err
+				b, jsonErr := json.Marshal(val)
+				if jsonErr != nil {
+					return nil, jsonErr
+				}
+
+				return []string{string(b)}, nil
 			}
 
 			result = append(result, strVal)
@@ -71,13 +71,44 @@ func TestPluckClaims(t *testing.T) {
 	assert.Equal(t, want, got)
 }
 
-func TestPluckClaims_FailsOnUnsupportedNestedTypes(t *testing.T) {
+func TestPluckClaims_SerializesNestedTypes(t *testing.T) {
+	q := map[string]string{
+		"Object":       "object",
+		"Object-Slice": "object-slice",
+		"Mixed-Slice":  "mixed-slice",
+	}
+
+	claims := `{
+		"object": {"name": "lol", "ids": [1, 2]},
+		"object-slice": [{}],
+		"mixed-slice": ["string", ["nested"]]
+	}`
+
+	want := map[string][]string{
+		"Object":       {`{"ids":[1,2],"name":"lol"}`},
+		"Object-Slice": {`[{}]`},
+		"Mixed-Slice":  {`["string",["nested"]]`},
+	}
+
+	var parsedClaims map[string]interface{}
+	dec := json.NewDecoder(bytes.NewBuffer([]byte(claims)))
+	dec.UseNumber()
+	err := dec.Decode(&parsedClaims)
+	require.NoError(t, err)
+
+	got, err := expr.PluckClaims(q, parsedClaims)
+	require.NoError(t, err)
+
+	assert.Equal(t, want, got)
+}
+
+func TestPluckClaims_FailsOnUnsupportedTypes(t *testing.T) {
 	q := map[string]string{
 		"String": "bug",
 	}
 
 	claims := `{
-		"bug": [{}]
+		"bug": null
 	}
 	`
 	var parsedClaims map[string]interface{}
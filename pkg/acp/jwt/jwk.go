@@ -28,6 +28,8 @@ import (
 	"time"
 
 	"github.com/pquerna/cachecontrol"
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/telemetry"
 	"gopkg.in/square/go-jose.v2"
 )
 
@@ -156,19 +158,74 @@ func (k *FileKeySet) updateKeySet() error {
 	return nil
 }
 
+const (
+	defaultJWKsMinRefreshInterval = time.Minute
+	defaultJWKsGracePeriod        = time.Hour
+	defaultJWKsRefetchInterval    = 10 * time.Second
+	defaultJWKsUnknownKeyTTL      = time.Minute
+
+	// maxUnknownKeys is the maximum number of unknown key IDs remembered by a RemoteKeySet.
+	maxUnknownKeys = 1000
+)
+
+// RemoteKeySetConfig configures how a RemoteKeySet keeps its keys up to date.
+// Zero values are replaced by defaults.
+type RemoteKeySetConfig struct {
+	// MinRefreshInterval is the minimum duration during which fetched keys are used, even if the server doesn't
+	// send cache control headers or asks for a shorter one.
+	MinRefreshInterval time.Duration
+	// GracePeriod is how long expired keys are still used while they cannot be refreshed.
+	GracePeriod time.Duration
+	// RefetchInterval is the minimum time between a fetch and a fetch triggered by an unknown key ID,
+	// or between two fetches after a failure.
+	RefetchInterval time.Duration
+	// UnknownKeyTTL is how long a key ID missing from a freshly fetched key set is considered unknown.
+	UnknownKeyTTL time.Duration
+}
+
 // RemoteKeySet resolves a key set based on a key set URL, and keeps it up to date.
+//
+// Expired keys are served while they are refreshed in the background, and for a grace period if the refresh fails.
+// Key IDs which are not found trigger a rate-limited refresh, as the keys may have been rotated.
 type RemoteKeySet struct {
-	url string
-
-	mu       sync.RWMutex
-	keys     jose.JSONWebKeySet
-	expiry   time.Time
-	updating *inflight
-	client   *http.Client
+	url                string
+	client             *http.Client
+	minRefreshInterval time.Duration
+	gracePeriod        time.Duration
+	refetchInterval    time.Duration
+	unknownKeyTTL      time.Duration
+
+	mu          sync.Mutex
+	keys        *jose.JSONWebKeySet
+	expiry      time.Time
+	lastFetch   time.Time
+	lastErr     error
+	unknownKeys map[string]time.Time
+	updating    *inflight
 }
 
 // NewRemoteKeySet returns a RemoteKeySet.
-func NewRemoteKeySet(url string) *RemoteKeySet {
+func NewRemoteKeySet(url string, cfg RemoteKeySetConfig) *RemoteKeySet {
+	minRefreshInterval := cfg.MinRefreshInterval
+	if minRefreshInterval == 0 {
+		minRefreshInterval = defaultJWKsMinRefreshInterval
+	}
+
+	gracePeriod := cfg.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultJWKsGracePeriod
+	}
+
+	refetchInterval := cfg.RefetchInterval
+	if refetchInterval == 0 {
+		refetchInterval = defaultJWKsRefetchInterval
+	}
+
+	unknownKeyTTL := cfg.UnknownKeyTTL
+	if unknownKeyTTL == 0 {
+		unknownKeyTTL = defaultJWKsUnknownKeyTTL
+	}
+
 	return &RemoteKeySet{
 		url: url,
 		client: &http.Client{
@@ -181,61 +238,173 @@ func NewRemoteKeySet(url string) *RemoteKeySet {
 			},
 			Timeout: 5 * time.Second,
 		},
+		minRefreshInterval: minRefreshInterval,
+		gracePeriod:        gracePeriod,
+		refetchInterval:    refetchInterval,
+		unknownKeyTTL:      unknownKeyTTL,
 	}
 }
 
 // Key returns a key for a given key ID.
 func (s *RemoteKeySet) Key(ctx context.Context, keyID string) (*jose.JSONWebKey, error) {
-	if err := s.updateKeySet(ctx); err != nil {
+	keys, err := s.keySet(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	if key := findKey(keys, keyID); key != nil {
+		return key, nil
+	}
 
-	keys := s.keys.Key(keyID)
-	if len(keys) == 0 {
+	updating := s.refreshUnknownKey(keyID)
+	if updating == nil {
 		return nil, nil
 	}
-	return &keys[0], nil
-}
 
-func (s *RemoteKeySet) updateKeySet(ctx context.Context) error {
-	if !s.isExpired() {
-		return nil
+	if err = updating.Wait(ctx); err != nil {
+		return nil, err
 	}
 
 	s.mu.Lock()
-	if s.updating == nil {
-		s.updating = newInflight()
+	defer s.mu.Unlock()
 
-		go func() {
-			keySet, expiry, err := fetchKeys(ctx, s.client, s.url)
+	if key := findKey(s.keys, keyID); key != nil {
+		return key, nil
+	}
 
-			s.mu.Lock()
-			defer s.mu.Unlock()
+	s.markUnknown(keyID, time.Now())
 
-			if err == nil {
-				s.keys = *keySet
-				s.expiry = expiry
-			}
+	return nil, nil
+}
+
+// keySet returns the keys to use, waiting for them to be fetched if there are no usable ones.
+func (s *RemoteKeySet) keySet(ctx context.Context) (*jose.JSONWebKeySet, error) {
+	s.mu.Lock()
+
+	now := time.Now()
+	if s.keys != nil && now.Before(s.expiry.Add(s.gracePeriod)) {
+		// Expired keys are served while being refreshed. Failed refreshes are only retried after a while.
+		if !now.Before(s.expiry) && s.updating == nil && (s.lastErr == nil || now.Sub(s.lastFetch) >= s.refetchInterval) {
+			s.refresh()
+		}
+
+		keys := s.keys
+		s.mu.Unlock()
 
-			s.updating.Done(err)
-			s.updating = nil
-		}()
+		return keys, nil
+	}
+
+	if s.updating == nil {
+		if s.lastErr != nil && now.Sub(s.lastFetch) < s.refetchInterval {
+			err := s.lastErr
+			s.mu.Unlock()
+
+			return nil, err
+		}
+
+		s.refresh()
 	}
 
 	updating := s.updating
 	s.mu.Unlock()
 
-	return updating.Wait(ctx)
+	if err := updating.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.keys, nil
+}
+
+// refreshUnknownKey refreshes the keys because the given key ID was not found. It returns nil if the keys cannot
+// be refreshed yet, or if the key ID is known to be unknown.
+func (s *RemoteKeySet) refreshUnknownKey(keyID string) *inflight {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if until, ok := s.unknownKeys[keyID]; ok && now.Before(until) {
+		return nil
+	}
+
+	if s.updating != nil {
+		return s.updating
+	}
+
+	if now.Sub(s.lastFetch) < s.refetchInterval {
+		return nil
+	}
+
+	return s.refresh()
 }
 
-func (s *RemoteKeySet) isExpired() bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// refresh fetches the keys in the background. It must be called with the lock held.
+// The fetch is detached from the requests waiting for it, so a cancelled request doesn't abort it for the others.
+func (s *RemoteKeySet) refresh() *inflight {
+	updating := newInflight()
+	s.updating = updating
+	s.lastFetch = time.Now()
+
+	go func() {
+		start := time.Now()
+		keySet, expiry, err := fetchKeys(context.Background(), s.client, s.url)
+		telemetry.JWKSFetchDuration.Observe(time.Since(start).Seconds(), telemetry.Result(err))
+
+		if err != nil {
+			log.Warn().Err(err).Str("url", s.url).Msg("Unable to refresh JWKs")
+		}
+
+		s.mu.Lock()
+		if err == nil {
+			s.keys = keySet
+			s.expiry = expiry
+			if minExpiry := start.Add(s.minRefreshInterval); s.expiry.Before(minExpiry) {
+				s.expiry = minExpiry
+			}
+		}
+		s.lastErr = err
+		s.updating = nil
+		s.mu.Unlock()
 
-	return time.Now().After(s.expiry)
+		updating.Done(err)
+	}()
+
+	return updating
+}
+
+// markUnknown remembers the given key ID is unknown. It must be called with the lock held.
+func (s *RemoteKeySet) markUnknown(keyID string, now time.Time) {
+	if s.unknownKeys == nil {
+		s.unknownKeys = make(map[string]time.Time)
+	}
+
+	if len(s.unknownKeys) >= maxUnknownKeys {
+		for id, until := range s.unknownKeys {
+			if !now.Before(until) {
+				delete(s.unknownKeys, id)
+			}
+		}
+
+		if len(s.unknownKeys) >= maxUnknownKeys {
+			return
+		}
+	}
+
+	s.unknownKeys[keyID] = now.Add(s.unknownKeyTTL)
+}
+
+func findKey(keySet *jose.JSONWebKeySet, keyID string) *jose.JSONWebKey {
+	if keySet == nil {
+		return nil
+	}
+
+	keys := keySet.Key(keyID)
+	if len(keys) == 0 {
+		return nil
+	}
+	return &keys[0]
 }
 
 func fetchKeys(ctx context.Context, client *http.Client, url string) (*jose.JSONWebKeySet, time.Time, error) {
@@ -22,7 +22,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -108,7 +110,7 @@ func TestRemoteKeySet_KeysFetchesKeySet(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(hdlr))
 	defer srv.Close()
 
-	ks := jwt.NewRemoteKeySet(srv.URL)
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetConfig{})
 
 	gotFooKey, err := ks.Key(context.Background(), "foo-key")
 	require.NoError(t, err)
@@ -136,7 +138,7 @@ func TestRemoteKeySet_KeysFetchesKeySetWithoutCache(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(hdlr))
 	defer srv.Close()
 
-	ks := jwt.NewRemoteKeySet(srv.URL)
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetConfig{})
 
 	gotFooKey, err := ks.Key(context.Background(), "foo-key")
 	require.NoError(t, err)
@@ -144,7 +146,8 @@ func TestRemoteKeySet_KeysFetchesKeySetWithoutCache(t *testing.T) {
 	gotBarKey, err := ks.Key(context.Background(), "bar-key")
 	require.NoError(t, err)
 
-	assert.Equal(t, 2, hdlrCalled)
+	// Keys are kept for the minimum refresh interval when the server doesn't send cache control headers.
+	assert.Equal(t, 1, hdlrCalled)
 	assert.Equal(t, wantKeys.Key("foo-key")[0], *gotFooKey)
 	assert.Equal(t, wantKeys.Key("bar-key")[0], *gotBarKey)
 }
@@ -158,12 +161,193 @@ func TestRemoteKeySet_KeysReturnsNilWhenKeyIsUnknown(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(hdlr))
 	defer srv.Close()
 
-	ks := jwt.NewRemoteKeySet(srv.URL)
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetConfig{})
+
+	gotKey, err := ks.Key(context.Background(), "meh-key")
+	require.NoError(t, err)
+
+	assert.Nil(t, gotKey)
+}
+
+func TestRemoteKeySet_ServesStaleKeysWhenRefreshFails(t *testing.T) {
+	var hdlrCalled int32
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&hdlrCalled, 1) > 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = rw.Write([]byte(jwkeys))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetConfig{
+		MinRefreshInterval: 20 * time.Millisecond,
+		GracePeriod:        time.Hour,
+		RefetchInterval:    time.Hour,
+	})
+
+	gotKey, err := ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+	require.NotNil(t, gotKey)
+
+	time.Sleep(50 * time.Millisecond)
+
+	// Expired keys are served while they are refreshed in the background.
+	gotKey, err = ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+	assert.NotNil(t, gotKey)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&hdlrCalled) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// The failed refresh is not retried before the refetch interval.
+	gotKey, err = ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+	assert.NotNil(t, gotKey)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hdlrCalled))
+}
+
+func TestRemoteKeySet_FailsOnceGracePeriodIsOver(t *testing.T) {
+	var hdlrCalled int32
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&hdlrCalled, 1) > 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = rw.Write([]byte(jwkeys))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetConfig{
+		MinRefreshInterval: 10 * time.Millisecond,
+		GracePeriod:        10 * time.Millisecond,
+		RefetchInterval:    time.Hour,
+	})
+
+	_, err := ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = ks.Key(context.Background(), "foo-key")
+	assert.Error(t, err)
+
+	// The error is returned without fetching the keys again before the refetch interval.
+	_, err = ks.Key(context.Background(), "foo-key")
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hdlrCalled))
+}
+
+func TestRemoteKeySet_RefetchesKeySetOnUnknownKey(t *testing.T) {
+	var wantKeys jose.JSONWebKeySet
+	err := json.Unmarshal([]byte(jwkeys), &wantKeys)
+	require.NoError(t, err)
+
+	fooKeySet, err := json.Marshal(jose.JSONWebKeySet{Keys: wantKeys.Key("foo-key")})
+	require.NoError(t, err)
+
+	var hdlrCalled int32
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Add("Cache-Control", "max-age=600")
+
+		if atomic.AddInt32(&hdlrCalled, 1) == 1 {
+			_, _ = rw.Write(fooKeySet)
+			return
+		}
+
+		_, _ = rw.Write([]byte(jwkeys))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetConfig{RefetchInterval: 200 * time.Millisecond})
+
+	// Keys are not fetched again right after being fetched.
+	gotKey, err := ks.Key(context.Background(), "bar-key")
+	require.NoError(t, err)
+	assert.Nil(t, gotKey)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hdlrCalled))
+
+	time.Sleep(250 * time.Millisecond)
+
+	gotKey, err = ks.Key(context.Background(), "bar-key")
+	require.NoError(t, err)
+	require.NotNil(t, gotKey)
+	assert.Equal(t, wantKeys.Key("bar-key")[0], *gotKey)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hdlrCalled))
+}
+
+func TestRemoteKeySet_CachesUnknownKeys(t *testing.T) {
+	var hdlrCalled int32
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hdlrCalled, 1)
+
+		rw.Header().Add("Cache-Control", "max-age=600")
+		_, _ = rw.Write([]byte(jwkeys))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetConfig{
+		RefetchInterval: 10 * time.Millisecond,
+		UnknownKeyTTL:   time.Hour,
+	})
+
+	_, err := ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+
+	time.Sleep(30 * time.Millisecond)
 
 	gotKey, err := ks.Key(context.Background(), "meh-key")
 	require.NoError(t, err)
+	assert.Nil(t, gotKey)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hdlrCalled))
 
+	time.Sleep(30 * time.Millisecond)
+
+	gotKey, err = ks.Key(context.Background(), "meh-key")
+	require.NoError(t, err)
 	assert.Nil(t, gotKey)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hdlrCalled))
+}
+
+func TestRemoteKeySet_RefreshIsNotCancelledWithRequest(t *testing.T) {
+	var hdlrCalled int32
+	release := make(chan struct{})
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hdlrCalled, 1)
+		<-release
+
+		rw.Header().Add("Cache-Control", "max-age=600")
+		_, _ = rw.Write([]byte(jwkeys))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ks.Key(ctx, "foo-key")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	close(release)
+
+	gotKey, err := ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+	assert.NotNil(t, gotKey)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hdlrCalled))
 }
 
 const jwkeys = `
@@ -28,14 +28,21 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	jwtreq "github.com/golang-jwt/jwt/request"
 	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/audit"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/denial"
 	"github.com/traefik/hub-agent-traefik/pkg/acp/jwt/expr"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/lockout"
 	"github.com/traefik/hub-agent-traefik/pkg/edge"
 )
 
+// maxDynKeySets is the maximum number of key sets created from the issuers of tokens when the JWKs URL is a path.
+const maxDynKeySets = 100
+
 // Handler is a JWT ACP Handler.
 type Handler struct {
 	name string
@@ -44,30 +51,76 @@ type Handler struct {
 	pubKey        interface{}
 	tokQryKey     string
 
-	// Either `keySet` or `dynKeySets` should be set at a time.
+	// Either `keySet`, `dynKeySets` or `discoveries` should be set at a time.
 	// If `jwksURL` is a complete URL, `keySet` is used.
 	// If `jwksURL` is a path, `dynKeySets` is used.
+	// If OIDC discovery is enabled, `discoveries` is used.
 	jwksURL      string
+	keySetCfg    RemoteKeySetConfig
 	keySet       KeySet
 	dynKeySetsMu sync.RWMutex
 	dynKeySets   map[string]*RemoteKeySet
+	discoveries  map[string]*Discovery
+
+	algorithms map[string]struct{}
+	issuers    []string
+	audiences  []string
+	leeway     time.Duration
 
 	stripAuthorization bool
-	fwdHeaders         map[string]string
+	fwdHeaders         *expr.HeaderRenderer
+	limiter            *lockout.Limiter
+	auditLogger        *audit.Logger
+	denial             *denial.Responder
 
 	validateCustomClaims expr.Predicate
+	// claimsUseRequest tells whether custom claims are validated against request attributes, or the current time.
+	claimsUseRequest bool
 }
 
 // NewHandler returns a new JWT ACP Handler.
 func NewHandler(cfg *edge.ACPJWTConfig, polName string) (*Handler, error) {
-	if cfg.PublicKey == "" && cfg.SigningSecret == "" && cfg.JWKsFile == "" && cfg.JWKsURL == "" {
-		return nil, errors.New("at least a signing secret, public key or a JWKs file or URL is required")
+	if cfg.PublicKey == "" && cfg.SigningSecret == "" && cfg.JWKsFile == "" && cfg.JWKsURL == "" && !cfg.OIDCDiscovery {
+		return nil, errors.New("at least a signing secret, public key, a JWKs file or URL or OIDC discovery is required")
+	}
+
+	if cfg.JWKsMinRefreshInterval < 0 {
+		return nil, errors.New("JWKs min refresh interval must not be negative")
+	}
+	if cfg.JWKsGracePeriod < 0 {
+		return nil, errors.New("JWKs grace period must not be negative")
+	}
+
+	keySetCfg := RemoteKeySetConfig{
+		MinRefreshInterval: cfg.JWKsMinRefreshInterval,
+		GracePeriod:        cfg.JWKsGracePeriod,
+	}
+
+	var discoveries map[string]*Discovery
+	if cfg.OIDCDiscovery {
+		if len(cfg.Issuers) == 0 {
+			return nil, errors.New("at least an issuer is required when OIDC discovery is enabled")
+		}
+		if cfg.JWKsFile != "" || cfg.JWKsURL != "" {
+			return nil, errors.New("OIDC discovery cannot be used along with a JWKs file or URL")
+		}
+
+		discoveries = make(map[string]*Discovery, len(cfg.Issuers))
+		for _, iss := range cfg.Issuers {
+			discoveries[normalizeIssuer(iss)] = NewDiscovery(iss, keySetCfg)
+		}
 	}
 
-	var (
-		pred expr.Predicate
-		err  error
-	)
+	if cfg.Leeway < 0 {
+		return nil, errors.New("leeway must not be negative")
+	}
+
+	algorithms, err := parseAlgorithms(cfg.Algorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	var pred expr.Predicate
 	if cfg.Claims != "" {
 		pred, err = expr.Parse(cfg.Claims)
 		if err != nil {
@@ -103,26 +156,47 @@ func NewHandler(cfg *edge.ACPJWTConfig, polName string) (*Handler, error) {
 		tokenQueryKey = cfg.TokenQueryKey
 	}
 
-	ks, err := keySet(cfg)
+	ks, err := keySet(cfg, keySetCfg)
 	if err != nil {
 		return nil, err
 	}
 
+	fwdHeaders, err := expr.NewHeaderRenderer(cfg.ForwardHeaders, cfg.ForwardHeadersDefaults)
+	if err != nil {
+		return nil, fmt.Errorf("create forwarded headers renderer: %w", err)
+	}
+
+	var limiter *lockout.Limiter
+	if cfg.Lockout != nil {
+		limiter, err = lockout.NewLimiter(cfg.Lockout)
+		if err != nil {
+			return nil, fmt.Errorf("create lockout limiter: %w", err)
+		}
+	}
+
 	return &Handler{
 		name:                 polName,
 		signingSecret:        signingSecret,
 		pubKey:               pubKey,
 		jwksURL:              cfg.JWKsURL,
+		keySetCfg:            keySetCfg,
 		keySet:               ks,
 		dynKeySets:           make(map[string]*RemoteKeySet),
+		discoveries:          discoveries,
+		algorithms:           algorithms,
+		issuers:              cfg.Issuers,
+		audiences:            cfg.Audiences,
+		leeway:               cfg.Leeway,
 		stripAuthorization:   cfg.StripAuthorizationHeader,
-		fwdHeaders:           cfg.ForwardHeaders,
+		fwdHeaders:           fwdHeaders,
+		limiter:              limiter,
 		tokQryKey:            tokenQueryKey,
 		validateCustomClaims: pred,
+		claimsUseRequest:     expr.DependsOnRequest(cfg.Claims),
 	}, nil
 }
 
-func keySet(src *edge.ACPJWTConfig) (KeySet, error) {
+func keySet(src *edge.ACPJWTConfig, keySetCfg RemoteKeySetConfig) (KeySet, error) {
 	if src.JWKsFile != "" {
 		if src.JWKsFile.IsPath() {
 			return NewFileKeySet(src.JWKsFile.String()), nil
@@ -136,17 +210,39 @@ func keySet(src *edge.ACPJWTConfig) (KeySet, error) {
 	}
 
 	if src.JWKsURL != "" && !strings.HasPrefix(src.JWKsURL, "/") {
-		return NewRemoteKeySet(src.JWKsURL), nil
+		return NewRemoteKeySet(src.JWKsURL, keySetCfg), nil
 	}
 
 	return nil, nil
 }
 
+// SetAuditLogger sets the logger auditing the decisions of the handler.
+func (h *Handler) SetAuditLogger(l *audit.Logger) {
+	h.auditLogger = l
+}
+
+// SetDenialResponder sets the responder writing the responses denying requests.
+func (h *Handler) SetDenialResponder(r *denial.Responder) {
+	h.denial = r
+}
+
 func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	start := time.Now()
 	logger := log.With().Str("handler_type", "JWT").Str("handler_name", h.name).Logger()
 
+	if h.limiter != nil {
+		if retryAfter, allowed := h.limiter.Allow(req, ""); !allowed {
+			logger.Debug().Msg("Too many failed authentications")
+			h.auditLogger.Log(req, audit.Decision{ACP: h.name, Reason: audit.ReasonLockedOut, Start: start})
+
+			lockout.WriteTooManyRequests(rw, retryAfter)
+			return
+		}
+	}
+
 	extractor := jwtExtractor{tokQryKey: h.tokQryKey}
-	p := &jwt.Parser{UseJSONNumber: true}
+	// Registered claims are validated afterwards, to take the leeway into account.
+	p := &jwt.Parser{UseJSONNumber: true, SkipClaimsValidation: true}
 	tok, err := jwtreq.ParseFromRequest(req, extractor, h.keyFunc(req.Context()), jwtreq.WithParser(p))
 	if err != nil {
 		var jwtErr *jwt.ValidationError
@@ -156,22 +252,52 @@ func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			logger.Debug().Err(err).Msg("Unable to parse JWT")
 		}
 
-		rw.WriteHeader(http.StatusUnauthorized)
+		reason := audit.ReasonInvalidToken
+		if errors.Is(err, errNoJWT) {
+			reason = audit.ReasonMissingCredentials
+		}
+		h.auditLogger.Log(req, audit.Decision{ACP: h.name, Reason: reason, Start: start})
+
+		// Only requests carrying a token are counted.
+		if h.limiter != nil && !errors.Is(err, errNoJWT) {
+			h.limiter.Fail(req, "")
+		}
+
+		h.denial.Deny(rw, req, http.StatusUnauthorized)
+		return
+	}
+
+	claims := tok.Claims.(jwt.MapClaims)
+	sub, _ := claims["sub"].(string)
+
+	if err = h.validateRegisteredClaims(claims, time.Now()); err != nil {
+		var claimsErr claimsError
+		errors.As(err, &claimsErr)
+
+		logger.Debug().Err(err).Str("reason", claimsErr.reason).Msg("Invalid registered claims")
+		h.auditLogger.Log(req, audit.Decision{ACP: h.name, Reason: audit.ReasonInvalidClaims, Subject: sub, Start: start})
+
+		if h.limiter != nil {
+			h.limiter.Fail(req, "")
+		}
+
+		h.denial.Deny(rw, req, http.StatusUnauthorized)
 		return
 	}
 
 	if h.validateCustomClaims != nil {
-		if !h.validateCustomClaims(tok.Claims.(jwt.MapClaims)) {
-			rw.WriteHeader(http.StatusForbidden)
+		if !h.validateCustomClaims(expr.Input{Claims: claims, Request: expr.NewRequest(req)}) {
+			h.auditLogger.Log(req, audit.Decision{ACP: h.name, Reason: audit.ReasonClaimsMismatch, Subject: sub, Start: start})
+
+			h.denial.Deny(rw, req, http.StatusForbidden)
 			return
 		}
 	}
 
-	hdrs, err := expr.PluckClaims(h.fwdHeaders, tok.Claims.(jwt.MapClaims))
-	if err != nil {
-		logger.Error().Err(err).Msg("Unable to set forwarded header")
-		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
+	// Headers which cannot be rendered are skipped, they must not prevent an authenticated request from going through.
+	hdrs, errs := h.fwdHeaders.Render(claims)
+	for _, err = range errs {
+		logger.Warn().Err(err).Msg("Unable to set forwarded header")
 	}
 
 	for name, vals := range hdrs {
@@ -184,9 +310,58 @@ func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		rw.Header().Add("Authorization", "")
 	}
 
+	h.auditLogger.Log(req, audit.Decision{ACP: h.name, Allowed: true, Reason: audit.ReasonAuthenticated, Subject: sub, Start: start})
+
 	rw.WriteHeader(http.StatusOK)
 }
 
+// Credential returns the JWT of the given request. Decisions about a JWT must not be reused once it is expired,
+// nor for other requests when custom claims are validated against request attributes, nor when failed
+// authentications lock clients out.
+func (h *Handler) Credential(req *http.Request) (string, time.Time, bool) {
+	if h.claimsUseRequest || h.limiter != nil {
+		return "", time.Time{}, false
+	}
+
+	rawJWT, err := jwtExtractor{tokQryKey: h.tokQryKey}.ExtractToken(req)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	p := &jwt.Parser{UseJSONNumber: true}
+	tok, _, err := p.ParseUnverified(rawJWT, jwt.MapClaims{})
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	exp, _, err := numericDate(tok.Claims.(jwt.MapClaims), "exp")
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	return rawJWT, exp, true
+}
+
+// Subject returns the subject of the JWT of the given request. The JWT is not verified.
+func (h *Handler) Subject(req *http.Request) string {
+	rawJWT, err := jwtExtractor{tokQryKey: h.tokQryKey}.ExtractToken(req)
+	if err != nil {
+		return ""
+	}
+
+	tok, _, err := (&jwt.Parser{}).ParseUnverified(rawJWT, jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+
+	sub, _ := tok.Claims.(jwt.MapClaims)["sub"].(string)
+
+	return sub
+}
+
+// errNoJWT is returned when no JWT is found in a request.
+var errNoJWT = errors.New("no JWT found in request")
+
 // jwtExtractor extracts JWTs from HTTP requests.
 type jwtExtractor struct {
 	tokQryKey string
@@ -201,7 +376,7 @@ func (j jwtExtractor) ExtractToken(req *http.Request) (string, error) {
 	}
 
 	if rawJWT == "" {
-		return "", errors.New("no JWT found in request")
+		return "", errNoJWT
 	}
 
 	return rawJWT, nil
@@ -210,15 +385,14 @@ func (j jwtExtractor) ExtractToken(req *http.Request) (string, error) {
 // keyFunc returns a function to find the correct key to validate its given JWT's signature.
 func (h *Handler) keyFunc(ctx context.Context) jwt.Keyfunc {
 	return func(tok *jwt.Token) (key interface{}, err error) {
-		var prefix string
-		if len(tok.Method.Alg()) > 2 {
-			prefix = tok.Method.Alg()[:2]
+		if !h.isAllowedAlgorithm(tok.Method.Alg()) {
+			return nil, fmt.Errorf("signing algorithm %q is not allowed", tok.Method.Alg())
 		}
 
 		kid, _ := tok.Header["kid"].(string)
 
-		switch prefix {
-		case "RS", "ES":
+		switch tok.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
 			if kid != "" {
 				return h.resolveKey(ctx, tok, kid)
 			}
@@ -226,9 +400,12 @@ func (h *Handler) keyFunc(ctx context.Context) jwt.Keyfunc {
 			if h.pubKey == nil {
 				return nil, errors.New("no public key configured")
 			}
+			if err = checkKeyType(h.pubKey, tok.Method); err != nil {
+				return nil, err
+			}
 			return h.pubKey, nil
 
-		case "HS":
+		case *jwt.SigningMethodHMAC:
 			if h.signingSecret == "" {
 				return nil, errors.New("no signing secret configured")
 			}
@@ -253,11 +430,12 @@ func (h *Handler) resolveKey(ctx context.Context, tok *jwt.Token, kid string) (k
 			return nil, errors.New("expected `iss` claim to be set")
 		}
 
-		if _, ok = c["iss"].(string); !ok {
+		iss, ok := c["iss"].(string)
+		if !ok {
 			return nil, errors.New("expected `iss` claim to be a string")
 		}
 
-		ks, err = h.remoteKeySet(c["iss"].(string))
+		ks, err = h.issuerKeySet(ctx, iss)
 		if err != nil {
 			return nil, err
 		}
@@ -271,9 +449,58 @@ func (h *Handler) resolveKey(ctx context.Context, tok *jwt.Token, kid string) (k
 	if k == nil {
 		return nil, fmt.Errorf("no key with id %q found", kid)
 	}
+
+	if err = checkJWK(k, tok.Method); err != nil {
+		return nil, err
+	}
 	return k.Key, nil
 }
 
+// issuerKeySet returns the key set of the given issuer.
+func (h *Handler) issuerKeySet(ctx context.Context, iss string) (KeySet, error) {
+	if h.discoveries == nil {
+		// The issuer is checked before fetching any key, which prevents fetching keys from untrusted locations.
+		if !h.isAllowedIssuer(iss) {
+			return nil, fmt.Errorf("issuer %q is not allowed", iss)
+		}
+
+		return h.remoteKeySet(iss)
+	}
+
+	// Only allowed issuers have a discovery, which prevents fetching metadata from untrusted locations.
+	discovery, ok := h.discoveries[normalizeIssuer(iss)]
+	if !ok {
+		return nil, fmt.Errorf("issuer %q is not allowed", iss)
+	}
+
+	ks, err := discovery.KeySet(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("discover provider %q: %w", iss, err)
+	}
+
+	return ks, nil
+}
+
+// isAllowedIssuer returns whether the given issuer is one of the allowed issuers.
+// Any issuer is allowed when no issuer is configured.
+func (h *Handler) isAllowedIssuer(iss string) bool {
+	if len(h.issuers) == 0 {
+		return true
+	}
+
+	for _, allowed := range h.issuers {
+		if normalizeIssuer(allowed) == normalizeIssuer(iss) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeIssuer(iss string) string {
+	return strings.TrimSuffix(iss, "/")
+}
+
 // remoteKeySet returns the remote key set for the given issuer, or creates a new one if none is found.
 func (h *Handler) remoteKeySet(iss string) (*RemoteKeySet, error) {
 	base, err := url.Parse(iss)
@@ -298,7 +525,16 @@ func (h *Handler) remoteKeySet(iss string) (*RemoteKeySet, error) {
 	h.dynKeySetsMu.Lock()
 	rks = h.dynKeySets[ksURL]
 	if rks == nil {
-		rks = NewRemoteKeySet(ksURL)
+		// Tokens can carry any issuer when no issuer is configured: an arbitrary key set is dropped when the limit
+		// is reached so the map doesn't grow indefinitely.
+		if len(h.dynKeySets) >= maxDynKeySets {
+			for u := range h.dynKeySets {
+				delete(h.dynKeySets, u)
+				break
+			}
+		}
+
+		rks = NewRemoteKeySet(ksURL, h.keySetCfg)
 		h.dynKeySets[ksURL] = rks
 	}
 	h.dynKeySetsMu.Unlock()
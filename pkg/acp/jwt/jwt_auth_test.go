@@ -19,17 +19,27 @@ package jwt
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/denial"
 	"github.com/traefik/hub-agent-traefik/pkg/edge"
 	"gopkg.in/square/go-jose.v2"
 )
@@ -83,6 +93,31 @@ func TestNew(t *testing.T) {
 			jwtCfg:  edge.ACPJWTConfig{JWKsURL: "http://example.com"},
 			wantErr: assert.NoError,
 		},
+		{
+			name:    "algorithms",
+			jwtCfg:  edge.ACPJWTConfig{SigningSecret: "foobar", Algorithms: []string{"HS256", "EdDSA"}},
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "unsupported algorithm",
+			jwtCfg:  edge.ACPJWTConfig{SigningSecret: "foobar", Algorithms: []string{"none"}},
+			wantErr: assert.Error,
+		},
+		{
+			name:    "OIDC discovery",
+			jwtCfg:  edge.ACPJWTConfig{OIDCDiscovery: true, Issuers: []string{"https://issuer.example.com"}},
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "OIDC discovery without issuers",
+			jwtCfg:  edge.ACPJWTConfig{OIDCDiscovery: true},
+			wantErr: assert.Error,
+		},
+		{
+			name:    "OIDC discovery with JWKs URL",
+			jwtCfg:  edge.ACPJWTConfig{OIDCDiscovery: true, Issuers: []string{"https://issuer.example.com"}, JWKsURL: "/jwks"},
+			wantErr: assert.Error,
+		},
 	}
 
 	for _, test := range tests {
@@ -174,6 +209,27 @@ func TestServeHTTP(t *testing.T) {
 			wantStatusCode: http.StatusOK,
 			wantHeader:     http.Header{"Nested-Property": []string{"value"}},
 		},
+		{
+			name: "templated header is forwarded",
+			jwtCfg: edge.ACPJWTConfig{
+				SigningSecret:  "bibi",
+				ForwardHeaders: map[string]string{"User": "{{.sub}}@{{.grp}}"},
+			},
+			token:          validJWT,
+			wantStatusCode: http.StatusOK,
+			wantHeader:     http.Header{"User": []string{"1234567890@admin"}},
+		},
+		{
+			name: "header which cannot be rendered is skipped or defaulted",
+			jwtCfg: edge.ACPJWTConfig{
+				SigningSecret:          "bibi",
+				ForwardHeaders:         map[string]string{"Tenant": "{{.tenant}}", "Group": "grp", "Name": "name"},
+				ForwardHeadersDefaults: map[string]string{"Group": "none"},
+			},
+			token:          validJWTWithNestedClaim,
+			wantStatusCode: http.StatusOK,
+			wantHeader:     http.Header{"Group": []string{"none"}, "Name": []string{"John Doe"}},
+		},
 	}
 
 	for _, test := range tests {
@@ -211,6 +267,112 @@ func TestServeHTTP(t *testing.T) {
 	}
 }
 
+func TestServeHTTP_lockout(t *testing.T) {
+	middleware, err := NewHandler(&edge.ACPJWTConfig{
+		SigningSecret: "bibi",
+		Lockout:       &edge.ACPLockoutConfig{MaxAttempts: 2},
+	}, "acp@my-ns")
+	require.NoError(t, err)
+
+	serve := func(token string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		middleware.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	// Requests without token are not counted.
+	assert.Equal(t, http.StatusUnauthorized, serve(""))
+	assert.Equal(t, http.StatusUnauthorized, serve(""))
+	assert.Equal(t, http.StatusOK, serve(validJWT))
+
+	assert.Equal(t, http.StatusUnauthorized, serve(expiredJWT))
+	assert.Equal(t, http.StatusUnauthorized, serve("invalid"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(validJWT))
+}
+
+func TestServeHTTP_denial(t *testing.T) {
+	middleware, err := NewHandler(&edge.ACPJWTConfig{
+		SigningSecret: "bibi",
+		Claims:        "Equals(`grp`, `admin`)",
+	}, "acp@my-ns")
+	require.NoError(t, err)
+
+	responder, err := denial.NewResponder(&edge.ACPDenialConfig{
+		RedirectURL: "https://login.example.com/",
+		Headers:     map[string]string{"WWW-Authenticate": `Bearer error="invalid_token"`},
+	})
+	require.NoError(t, err)
+	middleware.SetDenialResponder(responder)
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.Header.Set("X-Forwarded-Host", "app.example.com")
+		req.Header.Set("X-Forwarded-Uri", "/foo")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		middleware.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	for _, token := range []string{"invalid", expiredJWT, missingGroupJWT} {
+		rec := serve(token)
+
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, "https://login.example.com/?redirect=http%3A%2F%2Fapp.example.com%2Ffoo", rec.Header().Get("Location"))
+		assert.Equal(t, `Bearer error="invalid_token"`, rec.Header().Get("WWW-Authenticate"))
+	}
+
+	rec := serve(validJWT)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+}
+
+func TestHandler_Credential(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.Header.Set("Authorization", "Bearer "+validJWT)
+
+	handler, err := NewHandler(&edge.ACPJWTConfig{SigningSecret: "bibi", Claims: "Equals(`grp`, `admin`)"}, "acp@my-ns")
+	require.NoError(t, err)
+
+	cred, _, ok := handler.Credential(req)
+	assert.True(t, ok)
+	assert.Equal(t, validJWT, cred)
+
+	// Decisions depending on the request cannot be cached.
+	handler, err = NewHandler(&edge.ACPJWTConfig{SigningSecret: "bibi", Claims: "Method(`GET`)"}, "acp@my-ns")
+	require.NoError(t, err)
+
+	_, _, ok = handler.Credential(req)
+	assert.False(t, ok)
+
+	// Nor decisions which could bypass a lockout.
+	handler, err = NewHandler(&edge.ACPJWTConfig{SigningSecret: "bibi", Lockout: &edge.ACPLockoutConfig{MaxAttempts: 2}}, "acp@my-ns")
+	require.NoError(t, err)
+
+	_, _, ok = handler.Credential(req)
+	assert.False(t, ok)
+}
+
+func TestHandler_Subject(t *testing.T) {
+	handler, err := NewHandler(&edge.ACPJWTConfig{SigningSecret: "bibi"}, "acp@my-ns")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	assert.Empty(t, handler.Subject(req))
+
+	req.Header.Set("Authorization", "Bearer "+validJWT)
+	assert.Equal(t, "1234567890", handler.Subject(req))
+}
+
 func TestExtractJWT(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -266,6 +428,10 @@ func TestExtractJWT(t *testing.T) {
 }
 
 func TestKeyFunc(t *testing.T) {
+	rsaKey := &rsa.PublicKey{}
+	ecKey := &ecdsa.PublicKey{Curve: elliptic.P256()}
+	edKey := ed25519.PublicKey{}
+
 	tests := []struct {
 		name    string
 		handler *Handler
@@ -291,7 +457,16 @@ func TestKeyFunc(t *testing.T) {
 		{
 			name:    "unsupported signing algorithm",
 			handler: &Handler{},
-			tok:     &jwt.Token{Method: jwt.SigningMethodPS512},
+			tok:     &jwt.Token{Method: jwt.SigningMethodNone},
+			wantErr: assert.Error,
+		},
+		{
+			name: "signing algorithm not allowed",
+			handler: &Handler{
+				signingSecret: "signing-secret",
+				algorithms:    map[string]struct{}{"RS256": {}},
+			},
+			tok:     &jwt.Token{Method: jwt.SigningMethodHS512},
 			wantErr: assert.Error,
 		},
 		{
@@ -303,21 +478,47 @@ func TestKeyFunc(t *testing.T) {
 		{
 			name: "public key found",
 			handler: &Handler{
-				pubKey: rsa.PublicKey{},
+				pubKey: rsaKey,
 			},
 			tok:     &jwt.Token{Method: jwt.SigningMethodRS512},
-			wantKey: rsa.PublicKey{},
+			wantKey: rsaKey,
+			wantErr: assert.NoError,
+		},
+		{
+			name: "RSA-PSS public key found",
+			handler: &Handler{
+				pubKey: rsaKey,
+			},
+			tok:     &jwt.Token{Method: jwt.SigningMethodPS512},
+			wantKey: rsaKey,
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Ed25519 public key found",
+			handler: &Handler{
+				pubKey: edKey,
+			},
+			tok:     &jwt.Token{Method: jwt.SigningMethodEdDSA},
+			wantKey: edKey,
 			wantErr: assert.NoError,
 		},
+		{
+			name: "public key type does not match the signing algorithm",
+			handler: &Handler{
+				pubKey: edKey,
+			},
+			tok:     &jwt.Token{Method: jwt.SigningMethodRS256},
+			wantErr: assert.Error,
+		},
 		{
 			name: "jwks key found",
 			handler: &Handler{
 				keySet: &RemoteKeySet{
 					expiry: time.Now().Add(60 * time.Second),
-					keys: jose.JSONWebKeySet{
+					keys: &jose.JSONWebKeySet{
 						Keys: []jose.JSONWebKey{
 							{
-								Key:   rsa.PublicKey{},
+								Key:   rsaKey,
 								KeyID: "foo",
 							},
 						},
@@ -325,15 +526,73 @@ func TestKeyFunc(t *testing.T) {
 				},
 			},
 			tok:     &jwt.Token{Method: jwt.SigningMethodRS512, Header: map[string]interface{}{"kid": "foo"}},
-			wantKey: rsa.PublicKey{},
+			wantKey: rsaKey,
 			wantErr: assert.NoError,
 		},
 		{
-			name: "jwks key not found",
+			name: "jwks key algorithm does not match",
 			handler: &Handler{
 				keySet: &RemoteKeySet{
 					expiry: time.Now().Add(60 * time.Second),
-					keys: jose.JSONWebKeySet{
+					keys: &jose.JSONWebKeySet{
+						Keys: []jose.JSONWebKey{
+							{
+								Key:       rsaKey,
+								KeyID:     "foo",
+								Algorithm: "RS256",
+							},
+						},
+					},
+				},
+			},
+			tok:     &jwt.Token{Method: jwt.SigningMethodPS256, Header: map[string]interface{}{"kid": "foo"}},
+			wantErr: assert.Error,
+		},
+		{
+			name: "jwks key type does not match",
+			handler: &Handler{
+				keySet: &RemoteKeySet{
+					expiry: time.Now().Add(60 * time.Second),
+					keys: &jose.JSONWebKeySet{
+						Keys: []jose.JSONWebKey{
+							{
+								Key:   ecKey,
+								KeyID: "foo",
+							},
+						},
+					},
+				},
+			},
+			tok:     &jwt.Token{Method: jwt.SigningMethodES512, Header: map[string]interface{}{"kid": "foo"}},
+			wantErr: assert.Error,
+		},
+		{
+			name: "jwks key not meant for signatures",
+			handler: &Handler{
+				keySet: &RemoteKeySet{
+					expiry: time.Now().Add(60 * time.Second),
+					keys: &jose.JSONWebKeySet{
+						Keys: []jose.JSONWebKey{
+							{
+								Key:   rsaKey,
+								KeyID: "foo",
+								Use:   "enc",
+							},
+						},
+					},
+				},
+			},
+			tok:     &jwt.Token{Method: jwt.SigningMethodRS256, Header: map[string]interface{}{"kid": "foo"}},
+			wantErr: assert.Error,
+		},
+		{
+			name: "jwks key not found",
+			handler: &Handler{
+				keySet: &RemoteKeySet{
+					expiry:          time.Now().Add(60 * time.Second),
+					lastFetch:       time.Now(),
+					refetchInterval: time.Minute,
+					keys: &jose.JSONWebKeySet{
 						Keys: []jose.JSONWebKey{},
 					},
 				},
@@ -447,3 +706,217 @@ func Test_JWTAuthNew(t *testing.T) {
 		})
 	}
 }
+
+func TestServeHTTP_OIDCDiscovery(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode(ProviderMetadata{Issuer: srv.URL, JWKsURI: srv.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "key-id", Algorithm: "RS256", Use: "sig"}},
+		})
+	})
+
+	sign := func(t *testing.T, iss string) string {
+		t.Helper()
+
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": iss, "sub": "john"})
+		tok.Header["kid"] = "key-id"
+
+		signed, err := tok.SignedString(key)
+		require.NoError(t, err)
+
+		return signed
+	}
+
+	tests := []struct {
+		name           string
+		jwtCfg         edge.ACPJWTConfig
+		token          string
+		wantStatusCode int
+	}{
+		{
+			name:           "allowed issuer",
+			jwtCfg:         edge.ACPJWTConfig{OIDCDiscovery: true, Issuers: []string{srv.URL}},
+			token:          sign(t, srv.URL),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "issuer not allowed",
+			jwtCfg:         edge.ACPJWTConfig{OIDCDiscovery: true, Issuers: []string{"https://other.example.com"}},
+			token:          sign(t, srv.URL),
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler, err := NewHandler(&test.jwtCfg, "acp@my-ns")
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.Header.Set("Authorization", "Bearer "+test.token)
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.wantStatusCode, rec.Code)
+		})
+	}
+}
+
+func TestServeHTTP_issuerKeySet(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		allowed        bool
+		wantStatusCode int
+		wantFetches    int32
+	}{
+		{
+			name:           "allowed issuer",
+			allowed:        true,
+			wantStatusCode: http.StatusOK,
+			wantFetches:    1,
+		},
+		{
+			name:           "keys of a not allowed issuer are not fetched",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var fetches int32
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				atomic.AddInt32(&fetches, 1)
+
+				_ = json.NewEncoder(rw).Encode(jose.JSONWebKeySet{
+					Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "key-id", Algorithm: "RS256", Use: "sig"}},
+				})
+			}))
+			t.Cleanup(srv.Close)
+
+			issuers := []string{"https://other.example.com"}
+			if test.allowed {
+				issuers = []string{srv.URL}
+			}
+
+			handler, err := NewHandler(&edge.ACPJWTConfig{JWKsURL: "/keys", Issuers: issuers}, "acp@my-ns")
+			require.NoError(t, err)
+
+			tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": srv.URL, "sub": "john"})
+			tok.Header["kid"] = "key-id"
+			signed, err := tok.SignedString(key)
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.Header.Set("Authorization", "Bearer "+signed)
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.wantStatusCode, rec.Code)
+			assert.Equal(t, test.wantFetches, atomic.LoadInt32(&fetches))
+		})
+	}
+}
+
+func TestHandler_remoteKeySet_bounded(t *testing.T) {
+	handler, err := NewHandler(&edge.ACPJWTConfig{JWKsURL: "/keys"}, "acp@my-ns")
+	require.NoError(t, err)
+
+	for i := 0; i < 2*maxDynKeySets; i++ {
+		_, err = handler.remoteKeySet("https://issuer-" + strconv.Itoa(i) + ".example.com")
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, handler.dynKeySets, maxDynKeySets)
+}
+
+func TestServeHTTP_EdDSA(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{Key: pub, KeyID: "key-id", Algorithm: "EdDSA", Use: "sig"}},
+	})
+	require.NoError(t, err)
+
+	sign := func(t *testing.T, kid string) string {
+		t.Helper()
+
+		tok := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{"sub": "john"})
+		if kid != "" {
+			tok.Header["kid"] = kid
+		}
+
+		signed, err := tok.SignedString(priv)
+		require.NoError(t, err)
+
+		return signed
+	}
+
+	tests := []struct {
+		name           string
+		jwtCfg         edge.ACPJWTConfig
+		token          string
+		wantStatusCode int
+	}{
+		{
+			name:           "PEM public key",
+			jwtCfg:         edge.ACPJWTConfig{PublicKey: pemKey},
+			token:          sign(t, ""),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "JWKs file",
+			jwtCfg:         edge.ACPJWTConfig{JWKsFile: edge.FileOrContent(jwks)},
+			token:          sign(t, "key-id"),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "EdDSA not allowed",
+			jwtCfg:         edge.ACPJWTConfig{PublicKey: pemKey, Algorithms: []string{"RS256"}},
+			token:          sign(t, ""),
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler, err := NewHandler(&test.jwtCfg, "acp@my-ns")
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.Header.Set("Authorization", "Bearer "+test.token)
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.wantStatusCode, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lockout
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/traefik/hub-agent-traefik/pkg/acp/ipfilter"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+// maxEntries is the maximum number of client IPs and usernames tracked by a Limiter.
+// Once reached, the least recently failing ones are forgotten.
+const maxEntries = 10000
+
+const defaultPeriod = time.Minute
+
+// Limiter limits failed authentications per client IP and per username.
+type Limiter struct {
+	maxAttempts int
+	period      time.Duration
+	duration    time.Duration
+	strategy    *ipfilter.Strategy
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type entry struct {
+	key         string
+	failures    int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// NewLimiter returns a new Limiter.
+func NewLimiter(cfg *edge.ACPLockoutConfig) (*Limiter, error) {
+	if cfg.MaxAttempts <= 0 {
+		return nil, errors.New("max attempts must be greater than 0")
+	}
+	if cfg.Period < 0 {
+		return nil, errors.New("period must not be negative")
+	}
+	if cfg.LockoutDuration < 0 {
+		return nil, errors.New("lockout duration must not be negative")
+	}
+
+	strategy, err := ipfilter.NewStrategy(cfg.IPStrategy)
+	if err != nil {
+		return nil, fmt.Errorf("create IP strategy: %w", err)
+	}
+
+	period := cfg.Period
+	if period == 0 {
+		period = defaultPeriod
+	}
+
+	duration := cfg.LockoutDuration
+	if duration == 0 {
+		duration = period
+	}
+
+	return &Limiter{
+		maxAttempts: cfg.MaxAttempts,
+		period:      period,
+		duration:    duration,
+		strategy:    strategy,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}, nil
+}
+
+// Allow returns whether an authentication attempt from the given request, for the given username, if any, is allowed.
+// If not, it also returns how long the client must wait before trying again.
+func (l *Limiter) Allow(req *http.Request, username string) (time.Duration, bool) {
+	return l.allow(l.keys(req, username), time.Now())
+}
+
+// Fail records a failed authentication from the given request, for the given username, if any.
+func (l *Limiter) Fail(req *http.Request, username string) {
+	l.fail(l.keys(req, username), time.Now())
+}
+
+func (l *Limiter) allow(keys []string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var retryAfter time.Duration
+	for _, key := range keys {
+		elem, ok := l.entries[key]
+		if !ok {
+			continue
+		}
+
+		if d := elem.Value.(*entry).lockedUntil.Sub(now); d > retryAfter {
+			retryAfter = d
+		}
+	}
+
+	return retryAfter, retryAfter <= 0
+}
+
+func (l *Limiter) fail(keys []string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		e := l.entry(key)
+
+		if now.Sub(e.windowStart) >= l.period {
+			e.failures = 0
+			e.windowStart = now
+		}
+
+		e.failures++
+		if e.failures >= l.maxAttempts {
+			e.failures = 0
+			e.windowStart = now
+			e.lockedUntil = now.Add(l.duration)
+		}
+	}
+}
+
+// Succeed records a successful authentication for the given username, which clears its failures.
+// Failures of the client IP are kept, so a client owning valid credentials cannot use them to guess others.
+func (l *Limiter) Succeed(username string) {
+	if username == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := userKey(username)
+	if elem, ok := l.entries[key]; ok {
+		l.lru.Remove(elem)
+		delete(l.entries, key)
+	}
+}
+
+// entry returns the entry of the given key, creating it if needed. It must be called with the lock held.
+func (l *Limiter) entry(key string) *entry {
+	if elem, ok := l.entries[key]; ok {
+		l.lru.MoveToFront(elem)
+		return elem.Value.(*entry)
+	}
+
+	e := &entry{key: key}
+	l.entries[key] = l.lru.PushFront(e)
+
+	for l.lru.Len() > maxEntries {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.entries, oldest.Value.(*entry).key)
+	}
+
+	return e
+}
+
+func (l *Limiter) keys(req *http.Request, username string) []string {
+	var keys []string
+	if ip := l.strategy.ClientIP(req); ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	if username != "" {
+		keys = append(keys, userKey(username))
+	}
+
+	return keys
+}
+
+func userKey(username string) string {
+	return "user:" + username
+}
+
+// WriteTooManyRequests writes a 429 response telling the client to retry after the given duration.
+func WriteTooManyRequests(rw http.ResponseWriter, retryAfter time.Duration) {
+	secs := int64(retryAfter / time.Second)
+	if retryAfter%time.Second > 0 {
+		secs++
+	}
+
+	rw.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	rw.WriteHeader(http.StatusTooManyRequests)
+}
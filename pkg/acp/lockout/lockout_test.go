@@ -0,0 +1,172 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package lockout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+func TestNewLimiter(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cfg     edge.ACPLockoutConfig
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			desc:    "max attempts only",
+			cfg:     edge.ACPLockoutConfig{MaxAttempts: 5},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "no max attempts",
+			cfg:     edge.ACPLockoutConfig{Period: time.Minute},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "negative period",
+			cfg:     edge.ACPLockoutConfig{MaxAttempts: 5, Period: -time.Minute},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "negative lockout duration",
+			cfg:     edge.ACPLockoutConfig{MaxAttempts: 5, LockoutDuration: -time.Minute},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "invalid IP strategy",
+			cfg:     edge.ACPLockoutConfig{MaxAttempts: 5, IPStrategy: &edge.ACPIPStrategy{Depth: -1}},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewLimiter(&test.cfg)
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestLimiter_lockout(t *testing.T) {
+	l, err := NewLimiter(&edge.ACPLockoutConfig{MaxAttempts: 3, Period: time.Minute, LockoutDuration: 5 * time.Minute})
+	require.NoError(t, err)
+
+	keys := []string{"ip:10.0.0.1"}
+	now := time.Now()
+
+	l.fail(keys, now)
+	l.fail(keys, now.Add(time.Second))
+
+	_, ok := l.allow(keys, now.Add(2*time.Second))
+	assert.True(t, ok)
+
+	l.fail(keys, now.Add(2*time.Second))
+
+	retryAfter, ok := l.allow(keys, now.Add(3*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, 5*time.Minute-time.Second, retryAfter)
+
+	_, ok = l.allow([]string{"ip:10.0.0.2"}, now.Add(3*time.Second))
+	assert.True(t, ok)
+
+	_, ok = l.allow(keys, now.Add(2*time.Second+5*time.Minute))
+	assert.True(t, ok)
+}
+
+func TestLimiter_period(t *testing.T) {
+	l, err := NewLimiter(&edge.ACPLockoutConfig{MaxAttempts: 2, Period: time.Minute})
+	require.NoError(t, err)
+
+	keys := []string{"ip:10.0.0.1"}
+	now := time.Now()
+
+	l.fail(keys, now)
+	l.fail(keys, now.Add(time.Minute))
+
+	_, ok := l.allow(keys, now.Add(time.Minute))
+	assert.True(t, ok)
+
+	l.fail(keys, now.Add(90*time.Second))
+
+	retryAfter, ok := l.allow(keys, now.Add(90*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, time.Minute, retryAfter)
+}
+
+func TestLimiter_username(t *testing.T) {
+	l, err := NewLimiter(&edge.ACPLockoutConfig{MaxAttempts: 2})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	otherReq := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	otherReq.Header.Set("X-Forwarded-For", "10.0.0.2")
+
+	l.Fail(req, "bob")
+	l.Fail(otherReq, "bob")
+
+	// Both IPs failed once, but bob failed twice.
+	_, ok := l.Allow(req, "alice")
+	assert.True(t, ok)
+	_, ok = l.Allow(otherReq, "bob")
+	assert.False(t, ok)
+
+	l.Fail(req, "alice")
+	l.Succeed("alice")
+
+	// The IP failures are not cleared by a successful authentication.
+	_, ok = l.Allow(req, "alice")
+	assert.False(t, ok)
+}
+
+func TestLimiter_maxEntries(t *testing.T) {
+	l, err := NewLimiter(&edge.ACPLockoutConfig{MaxAttempts: 1})
+	require.NoError(t, err)
+
+	now := time.Now()
+	for i := 0; i <= maxEntries; i++ {
+		l.fail([]string{"user:" + string(rune(i))}, now)
+	}
+
+	assert.Len(t, l.entries, maxEntries)
+
+	// The least recently failing entry is forgotten.
+	_, ok := l.allow([]string{"user:" + string(rune(0))}, now)
+	assert.True(t, ok)
+	_, ok = l.allow([]string{"user:" + string(rune(maxEntries))}, now)
+	assert.False(t, ok)
+}
+
+func TestWriteTooManyRequests(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	WriteTooManyRequests(rec, 1500*time.Millisecond)
+
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+}
@@ -0,0 +1,79 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package mtls
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+)
+
+// certificateFields returns the fields of the given certificate that can be used by claim predicates and forwarded headers.
+// For instance, `subject.commonName`, `sans.dns` or `fingerprint`.
+func certificateFields(cert *x509.Certificate) map[string]interface{} {
+	uris := make([]interface{}, 0, len(cert.URIs))
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	ips := make([]interface{}, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	return map[string]interface{}{
+		"subject": nameFields(cert.Subject),
+		"issuer":  nameFields(cert.Issuer),
+		"sans": map[string]interface{}{
+			"dns":   strs(cert.DNSNames),
+			"email": strs(cert.EmailAddresses),
+			"uri":   uris,
+			"ip":    ips,
+		},
+		"serialNumber": cert.SerialNumber.String(),
+		"fingerprint":  hex.EncodeToString(fingerprint[:]),
+		"notBefore":    json.Number(strconv.FormatInt(cert.NotBefore.Unix(), 10)),
+		"notAfter":     json.Number(strconv.FormatInt(cert.NotAfter.Unix(), 10)),
+	}
+}
+
+func nameFields(name pkix.Name) map[string]interface{} {
+	return map[string]interface{}{
+		"commonName":         name.CommonName,
+		"serialNumber":       name.SerialNumber,
+		"organization":       strs(name.Organization),
+		"organizationalUnit": strs(name.OrganizationalUnit),
+		"country":            strs(name.Country),
+		"province":           strs(name.Province),
+		"locality":           strs(name.Locality),
+	}
+}
+
+func strs(values []string) []interface{} {
+	res := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		res = append(res, v)
+	}
+
+	return res
+}
@@ -0,0 +1,187 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package mtls
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/jwt/expr"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+// clientCertHeader is the header in which Traefik's PassTLSClientCert middleware forwards the client certificate chain.
+const clientCertHeader = "X-Forwarded-Tls-Client-Cert"
+
+// Handler is a mutual TLS ACP Handler.
+// It validates the client certificate chain forwarded by Traefik against a CA bundle.
+type Handler struct {
+	name string
+
+	roots *x509.CertPool
+
+	fwdHeaders           map[string]string
+	validateCustomClaims expr.Predicate
+	// claimsUseRequest tells whether custom claims are validated against request attributes, or the current time.
+	claimsUseRequest bool
+}
+
+// NewHandler returns a new mutual TLS ACP Handler.
+func NewHandler(cfg *edge.ACPMTLSConfig, polName string) (*Handler, error) {
+	if cfg.CABundle == "" {
+		return nil, errors.New("a CA bundle is required")
+	}
+
+	bundle, err := cfg.CABundle.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("no valid certificate found in CA bundle")
+	}
+
+	var pred expr.Predicate
+	if cfg.Claims != "" {
+		pred, err = expr.Parse(cfg.Claims)
+		if err != nil {
+			return nil, fmt.Errorf("make predicate: %w", err)
+		}
+	}
+
+	return &Handler{
+		name:                 polName,
+		roots:                roots,
+		fwdHeaders:           cfg.ForwardHeaders,
+		validateCustomClaims: pred,
+		claimsUseRequest:     expr.DependsOnRequest(cfg.Claims),
+	}, nil
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	logger := log.With().Str("handler_type", "MTLS").Str("handler_name", h.name).Logger()
+
+	chain, err := parseCertificates(req.Header.Get(clientCertHeader))
+	if err != nil {
+		logger.Debug().Err(err).Msg("Unable to parse client certificate")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err = h.verify(chain, time.Now()); err != nil {
+		logger.Debug().Err(err).Msg("Invalid client certificate")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	fields := certificateFields(chain[0])
+
+	if h.validateCustomClaims != nil {
+		if !h.validateCustomClaims(expr.Input{Claims: fields, Request: expr.NewRequest(req)}) {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	hdrs, err := expr.PluckClaims(h.fwdHeaders, fields)
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to set forwarded header")
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	for name, vals := range hdrs {
+		for _, val := range vals {
+			rw.Header().Add(name, val)
+		}
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// Credential returns the client certificate chain of the given request. Decisions about a certificate must not be
+// reused once it is expired, nor for other requests when custom claims are validated against request attributes.
+func (h *Handler) Credential(req *http.Request) (string, time.Time, bool) {
+	if h.claimsUseRequest {
+		return "", time.Time{}, false
+	}
+
+	header := req.Header.Get(clientCertHeader)
+
+	chain, err := parseCertificates(header)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	return header, chain[0].NotAfter, true
+}
+
+// verify verifies the given chain, whose first certificate is the client certificate, against the CA bundle.
+func (h *Handler) verify(chain []*x509.Certificate, now time.Time) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         h.roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	return err
+}
+
+// parseCertificates parses the certificates forwarded by Traefik. The header holds the URL-escaped,
+// comma-separated list of base64-encoded DER certificates, starting with the client certificate.
+func parseCertificates(header string) ([]*x509.Certificate, error) {
+	if header == "" {
+		return nil, errors.New("no client certificate found in request")
+	}
+
+	raw, err := url.QueryUnescape(header)
+	if err != nil {
+		return nil, fmt.Errorf("unescape header: %w", err)
+	}
+
+	var chain []*x509.Certificate
+	for _, encoded := range strings.Split(raw, ",") {
+		der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("decode certificate: %w", err)
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate: %w", err)
+		}
+
+		chain = append(chain, cert)
+	}
+
+	return chain, nil
+}
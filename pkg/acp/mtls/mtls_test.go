@@ -0,0 +1,273 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+func TestNewHandler(t *testing.T) {
+	ca := newCertificate(t, certTemplate{cn: "root", isCA: true}, nil)
+
+	bundlePath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bundlePath, ca.pem(), 0o600))
+
+	tests := []struct {
+		desc    string
+		cfg     edge.ACPMTLSConfig
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			desc:    "CA bundle content",
+			cfg:     edge.ACPMTLSConfig{CABundle: edge.FileOrContent(ca.pem())},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "CA bundle file",
+			cfg:     edge.ACPMTLSConfig{CABundle: edge.FileOrContent(bundlePath)},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "no CA bundle",
+			cfg:     edge.ACPMTLSConfig{},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "invalid CA bundle",
+			cfg:     edge.ACPMTLSConfig{CABundle: "not a certificate"},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "invalid claims",
+			cfg:     edge.ACPMTLSConfig{CABundle: edge.FileOrContent(ca.pem()), Claims: "Equals(`subject.commonName`"},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewHandler(&test.cfg, "acp@my-ns")
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	root := newCertificate(t, certTemplate{cn: "root", isCA: true}, nil)
+	intermediate := newCertificate(t, certTemplate{cn: "intermediate", isCA: true}, &root)
+	client := newCertificate(t, certTemplate{cn: "partner", org: "ACME", dnsNames: []string{"partner.example.com"}, clientAuth: true}, &intermediate)
+	direct := newCertificate(t, certTemplate{cn: "direct", clientAuth: true}, &root)
+	serverOnly := newCertificate(t, certTemplate{cn: "server"}, &root)
+	expired := newCertificate(t, certTemplate{cn: "expired", clientAuth: true, expired: true}, &root)
+
+	untrustedRoot := newCertificate(t, certTemplate{cn: "untrusted", isCA: true}, nil)
+	untrusted := newCertificate(t, certTemplate{cn: "untrusted-client", clientAuth: true}, &untrustedRoot)
+
+	tests := []struct {
+		desc           string
+		cfg            edge.ACPMTLSConfig
+		header         string
+		wantStatusCode int
+		wantHeaders    http.Header
+	}{
+		{
+			desc:           "client certificate signed by the CA",
+			header:         forwardedHeader(direct),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc: "client certificate signed by an intermediate",
+			cfg: edge.ACPMTLSConfig{
+				ForwardHeaders: map[string]string{"X-Client-CN": "subject.commonName", "X-Client-DNS": "sans.dns"},
+			},
+			header:         forwardedHeader(client, intermediate),
+			wantStatusCode: http.StatusOK,
+			wantHeaders:    http.Header{"X-Client-Cn": {"partner"}, "X-Client-Dns": {"partner.example.com"}},
+		},
+		{
+			desc:           "missing intermediate",
+			header:         forwardedHeader(client),
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "untrusted client certificate",
+			header:         forwardedHeader(untrusted),
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "certificate not meant for client authentication",
+			header:         forwardedHeader(serverOnly),
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "expired client certificate",
+			header:         forwardedHeader(expired),
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "no client certificate",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "malformed header",
+			header:         "not-a-certificate",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:           "claims are valid",
+			cfg:            edge.ACPMTLSConfig{Claims: "Contains(`subject.organization`, `ACME`) && Contains(`sans.dns`, `partner.example.com`)"},
+			header:         forwardedHeader(client, intermediate),
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			desc:           "claims are invalid",
+			cfg:            edge.ACPMTLSConfig{Claims: "Equals(`subject.commonName`, `someone-else`)"},
+			header:         forwardedHeader(client, intermediate),
+			wantStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			test.cfg.CABundle = edge.FileOrContent(root.pem())
+
+			handler, err := NewHandler(&test.cfg, "acp@my-ns")
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			if test.header != "" {
+				req.Header.Set(clientCertHeader, test.header)
+			}
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.wantStatusCode, rec.Code)
+			for name, vals := range test.wantHeaders {
+				assert.Equal(t, vals, rec.Header().Values(name))
+			}
+		})
+	}
+}
+
+type certTemplate struct {
+	cn         string
+	org        string
+	dnsNames   []string
+	isCA       bool
+	clientAuth bool
+	expired    bool
+}
+
+type certificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func (c certificate) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+// newCertificate creates a certificate from the given template, signed by the given parent or self-signed.
+func newCertificate(t *testing.T, tmpl certTemplate, parent *certificate) certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(time.Hour)
+	if tmpl.expired {
+		notAfter = time.Now().Add(-time.Minute)
+	}
+
+	x509Tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: tmpl.cn},
+		DNSNames:              tmpl.dnsNames,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  tmpl.isCA,
+	}
+	if tmpl.org != "" {
+		x509Tmpl.Subject.Organization = []string{tmpl.org}
+	}
+
+	switch {
+	case tmpl.isCA:
+		x509Tmpl.KeyUsage = x509.KeyUsageCertSign
+	case tmpl.clientAuth:
+		x509Tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		x509Tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	default:
+		x509Tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		x509Tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+
+	signerCert, signerKey := x509Tmpl, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, x509Tmpl, signerCert, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return certificate{cert: cert, key: key}
+}
+
+// forwardedHeader encodes the given certificates the way Traefik's PassTLSClientCert middleware does.
+func forwardedHeader(certs ...certificate) string {
+	var encoded []string
+	for _, c := range certs {
+		encoded = append(encoded, base64.StdEncoding.EncodeToString(c.cert.Raw))
+	}
+
+	return url.QueryEscape(strings.Join(encoded, ","))
+}
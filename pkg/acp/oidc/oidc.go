@@ -0,0 +1,466 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwtgo "github.com/golang-jwt/jwt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/jwt/expr"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+)
+
+const (
+	defaultRedirectPath  = "/callback"
+	defaultSessionName   = "hub-session"
+	defaultSessionExpiry = 24 * time.Hour
+	stateExpiry          = 10 * time.Minute
+)
+
+// Handler is an OpenID Connect ACP Handler.
+// It authenticates users with the authorization code flow and keeps them
+// authenticated with a signed and encrypted session cookie.
+type Handler struct {
+	name string
+
+	provider     *provider
+	clientID     string
+	clientSecret string
+	scopes       []string
+	redirectPath string
+
+	codec         *cookieCodec
+	sessionName   string
+	sessionPath   string
+	sessionDomain string
+	sessionSecure bool
+	sameSite      http.SameSite
+	sessionExpiry time.Duration
+
+	fwdHeaders           map[string]string
+	validateCustomClaims expr.Predicate
+}
+
+// NewHandler returns a new OpenID Connect ACP Handler.
+func NewHandler(cfg *edge.ACPOIDCConfig, polName string) (*Handler, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("an issuer is required")
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("a client ID is required")
+	}
+
+	if _, err := url.ParseRequestURI(cfg.Issuer); err != nil {
+		return nil, fmt.Errorf("invalid issuer: %w", err)
+	}
+
+	var (
+		pred expr.Predicate
+		err  error
+	)
+	if cfg.Claims != "" {
+		pred, err = expr.Parse(cfg.Claims)
+		if err != nil {
+			return nil, fmt.Errorf("make predicate: %w", err)
+		}
+	}
+
+	codec, err := newCookieCodec(cfg.Session.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	redirectPath := defaultRedirectPath
+	if cfg.RedirectPath != "" {
+		redirectPath = cfg.RedirectPath
+	}
+	if !strings.HasPrefix(redirectPath, "/") {
+		return nil, fmt.Errorf("redirect path %q must start with a /", redirectPath)
+	}
+
+	sameSite, err := parseSameSite(cfg.Session.SameSite)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionName := defaultSessionName
+	if cfg.Session.Name != "" {
+		sessionName = cfg.Session.Name
+	}
+
+	sessionPath := "/"
+	if cfg.Session.Path != "" {
+		sessionPath = cfg.Session.Path
+	}
+
+	sessionExpiry := defaultSessionExpiry
+	if cfg.Session.Expiry > 0 {
+		sessionExpiry = cfg.Session.Expiry
+	}
+
+	return &Handler{
+		name:                 polName,
+		provider:             newProvider(cfg.Issuer, &http.Client{Timeout: 5 * time.Second}),
+		clientID:             cfg.ClientID,
+		clientSecret:         cfg.ClientSecret,
+		scopes:               scopes(cfg.Scopes),
+		redirectPath:         redirectPath,
+		codec:                codec,
+		sessionName:          sessionName,
+		sessionPath:          sessionPath,
+		sessionDomain:        cfg.Session.Domain,
+		sessionSecure:        cfg.Session.Secure,
+		sameSite:             sameSite,
+		sessionExpiry:        sessionExpiry,
+		fwdHeaders:           cfg.ForwardHeaders,
+		validateCustomClaims: pred,
+	}, nil
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	logger := log.With().Str("handler_type", "OIDC").Str("handler_name", h.name).Logger()
+
+	origURL, err := originalURL(req)
+	if err != nil {
+		logger.Debug().Err(err).Msg("Unable to build the original request URL")
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if origURL.Path == h.redirectPath {
+		h.handleCallback(rw, req, origURL, logger)
+		return
+	}
+
+	claims, err := h.sessionClaims(req)
+	if err != nil {
+		logger.Debug().Err(err).Msg("No valid session, redirecting to the provider")
+		h.redirectToProvider(rw, req, origURL, logger)
+		return
+	}
+
+	if h.validateCustomClaims != nil {
+		if !h.validateCustomClaims(expr.Input{Claims: claims, Request: expr.NewRequest(req)}) {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	hdrs, err := expr.PluckClaims(h.fwdHeaders, claims)
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to set forwarded header")
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	for name, vals := range hdrs {
+		for _, val := range vals {
+			rw.Header().Add(name, val)
+		}
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// sessionClaims returns the claims stored in the session cookie of the given request.
+func (h *Handler) sessionClaims(req *http.Request) (map[string]interface{}, error) {
+	cookie, err := req.Cookie(h.sessionName)
+	if err != nil {
+		return nil, err
+	}
+
+	var sess session
+	if err = h.codec.decode(cookie.Value, &sess); err != nil {
+		return nil, fmt.Errorf("decode session: %w", err)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(sess.Claims))
+	dec.UseNumber()
+
+	var claims map[string]interface{}
+	if err = dec.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("decode session claims: %w", err)
+	}
+
+	return claims, nil
+}
+
+// redirectToProvider starts the authorization code flow by redirecting the user to the provider.
+func (h *Handler) redirectToProvider(rw http.ResponseWriter, req *http.Request, origURL *url.URL, logger zerolog.Logger) {
+	metadata, _, err := h.provider.discover(req.Context())
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to discover the OpenID provider")
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to generate state")
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to generate nonce")
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	expiry := time.Now().Add(stateExpiry)
+	value, err := h.codec.encode(authState{State: state, Nonce: nonce, RedirectURL: origURL.String()}, expiry)
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to encode state")
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid authorization endpoint")
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", h.clientID)
+	q.Set("redirect_uri", h.redirectURI(origURL))
+	q.Set("scope", strings.Join(h.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	authURL.RawQuery = q.Encode()
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     h.stateCookieName(),
+		Value:    value,
+		Path:     "/",
+		Domain:   h.sessionDomain,
+		Expires:  expiry,
+		Secure:   h.sessionSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(rw, req, authURL.String(), http.StatusFound)
+}
+
+// handleCallback ends the authorization code flow and opens a session for the authenticated user.
+func (h *Handler) handleCallback(rw http.ResponseWriter, req *http.Request, origURL *url.URL, logger zerolog.Logger) {
+	q := origURL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		logger.Debug().Str("error", errCode).Str("error_description", q.Get("error_description")).Msg("Authentication failed on the provider")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := req.Cookie(h.stateCookieName())
+	if err != nil {
+		logger.Debug().Err(err).Msg("Missing state cookie")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var state authState
+	if err = h.codec.decode(cookie.Value, &state); err != nil {
+		logger.Debug().Err(err).Msg("Invalid state cookie")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state.State)) != 1 {
+		logger.Debug().Msg("State mismatch")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := h.authenticate(req.Context(), q.Get("code"), h.redirectURI(origURL), state.Nonce)
+	if err != nil {
+		logger.Debug().Err(err).Msg("Unable to authenticate")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to serialize claims")
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	expiry := time.Now().Add(h.sessionExpiry)
+	value, err := h.codec.encode(session{Claims: string(rawClaims)}, expiry)
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to encode session")
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     h.sessionName,
+		Value:    value,
+		Path:     h.sessionPath,
+		Domain:   h.sessionDomain,
+		Expires:  expiry,
+		Secure:   h.sessionSecure,
+		HttpOnly: true,
+		SameSite: h.sameSite,
+	})
+	http.SetCookie(rw, &http.Cookie{
+		Name:     h.stateCookieName(),
+		Path:     "/",
+		Domain:   h.sessionDomain,
+		MaxAge:   -1,
+		Secure:   h.sessionSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(rw, req, state.RedirectURL, http.StatusFound)
+}
+
+// authenticate exchanges the given code for an ID token, verifies it and returns its claims.
+func (h *Handler) authenticate(ctx context.Context, code, redirectURI, nonce string) (jwtgo.MapClaims, error) {
+	if code == "" {
+		return nil, errors.New("missing authorization code")
+	}
+
+	metadata, keySet, err := h.provider.discover(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("discover provider: %w", err)
+	}
+
+	tok, err := h.provider.exchange(ctx, metadata.TokenEndpoint, h.clientID, h.clientSecret, code, redirectURI)
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+
+	p := &jwtgo.Parser{
+		UseJSONNumber: true,
+		ValidMethods:  []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"},
+	}
+	idToken, err := p.Parse(tok.IDToken, func(t *jwtgo.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		k, errKey := keySet.Key(ctx, kid)
+		if errKey != nil {
+			return nil, fmt.Errorf("error searching for JSON web key: %w", errKey)
+		}
+		if k == nil {
+			return nil, fmt.Errorf("no key with id %q found", kid)
+		}
+		return k.Key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parse ID token: %w", err)
+	}
+
+	claims := idToken.Claims.(jwtgo.MapClaims)
+	if !claims.VerifyIssuer(metadata.Issuer, true) {
+		return nil, errors.New("unexpected ID token issuer")
+	}
+	if !claims.VerifyAudience(h.clientID, true) {
+		return nil, errors.New("unexpected ID token audience")
+	}
+
+	tokNonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(tokNonce), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+func (h *Handler) redirectURI(origURL *url.URL) string {
+	u := url.URL{Scheme: origURL.Scheme, Host: origURL.Host, Path: h.redirectPath}
+	return u.String()
+}
+
+func (h *Handler) stateCookieName() string {
+	return h.sessionName + "-state"
+}
+
+// originalURL rebuilds the URL requested by the client from the headers set by Traefik's ForwardAuth middleware.
+func originalURL(req *http.Request) (*url.URL, error) {
+	uri := req.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
+
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Scheme = req.Header.Get("X-Forwarded-Proto")
+	if u.Scheme == "" {
+		u.Scheme = "https"
+	}
+
+	u.Host = req.Header.Get("X-Forwarded-Host")
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+
+	return u, nil
+}
+
+func scopes(cfgScopes []string) []string {
+	for _, scope := range cfgScopes {
+		if scope == "openid" {
+			return cfgScopes
+		}
+	}
+
+	return append([]string{"openid"}, cfgScopes...)
+}
+
+func parseSameSite(sameSite string) (http.SameSite, error) {
+	switch strings.ToLower(sameSite) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unsupported same site value %q", sameSite)
+	}
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
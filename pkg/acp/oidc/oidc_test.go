@@ -0,0 +1,348 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jwtgo "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/jwt"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const testSecret = "a-very-long-secret-used-to-protect-sessions"
+
+func TestNewHandler(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cfg     edge.ACPOIDCConfig
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			desc:    "valid configuration",
+			cfg:     edge.ACPOIDCConfig{Issuer: "https://issuer.example.com", ClientID: "client", Session: edge.ACPOIDCSessionConfig{Secret: testSecret}},
+			wantErr: assert.NoError,
+		},
+		{
+			desc:    "missing issuer",
+			cfg:     edge.ACPOIDCConfig{ClientID: "client", Session: edge.ACPOIDCSessionConfig{Secret: testSecret}},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "missing client ID",
+			cfg:     edge.ACPOIDCConfig{Issuer: "https://issuer.example.com", Session: edge.ACPOIDCSessionConfig{Secret: testSecret}},
+			wantErr: assert.Error,
+		},
+		{
+			desc:    "session secret too short",
+			cfg:     edge.ACPOIDCConfig{Issuer: "https://issuer.example.com", ClientID: "client", Session: edge.ACPOIDCSessionConfig{Secret: "short"}},
+			wantErr: assert.Error,
+		},
+		{
+			desc: "invalid redirect path",
+			cfg: edge.ACPOIDCConfig{
+				Issuer:       "https://issuer.example.com",
+				ClientID:     "client",
+				RedirectPath: "callback",
+				Session:      edge.ACPOIDCSessionConfig{Secret: testSecret},
+			},
+			wantErr: assert.Error,
+		},
+		{
+			desc: "invalid same site",
+			cfg: edge.ACPOIDCConfig{
+				Issuer:   "https://issuer.example.com",
+				ClientID: "client",
+				Session:  edge.ACPOIDCSessionConfig{Secret: testSecret, SameSite: "sometimes"},
+			},
+			wantErr: assert.Error,
+		},
+		{
+			desc: "invalid claims expression",
+			cfg: edge.ACPOIDCConfig{
+				Issuer:   "https://issuer.example.com",
+				ClientID: "client",
+				Claims:   "Equals(",
+				Session:  edge.ACPOIDCSessionConfig{Secret: testSecret},
+			},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewHandler(&test.cfg, "acp")
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_authorizationCodeFlow(t *testing.T) {
+	idp := newFakeProvider(t)
+
+	h, err := NewHandler(&edge.ACPOIDCConfig{
+		Issuer:         idp.URL,
+		ClientID:       "client-id",
+		ClientSecret:   "client-secret",
+		Scopes:         []string{"email"},
+		ForwardHeaders: map[string]string{"User": "sub"},
+		Claims:         "Equals(`grp`, `admin`)",
+		Session:        edge.ACPOIDCSessionConfig{Secret: testSecret},
+	}, "acp")
+	require.NoError(t, err)
+
+	// The user is not authenticated yet and gets redirected to the provider.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, forwardedRequest(t, "/foo?bar=baz"))
+
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, idp.URL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal(t, "code", location.Query().Get("response_type"))
+	assert.Equal(t, "client-id", location.Query().Get("client_id"))
+	assert.Equal(t, "https://app.example.com/callback", location.Query().Get("redirect_uri"))
+	assert.Equal(t, "openid email", location.Query().Get("scope"))
+
+	state := location.Query().Get("state")
+	require.NotEmpty(t, state)
+	idp.nonce = location.Query().Get("nonce")
+
+	stateCookie := findCookie(t, rec.Result().Cookies(), "hub-session-state")
+
+	// The provider redirects the user back with a code.
+	req := forwardedRequest(t, "/callback?code=good-code&state="+url.QueryEscape(state))
+	req.AddCookie(stateCookie)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "https://app.example.com/foo?bar=baz", rec.Header().Get("Location"))
+
+	sessionCookie := findCookie(t, rec.Result().Cookies(), "hub-session")
+
+	// The user is now authenticated.
+	req = forwardedRequest(t, "/foo?bar=baz")
+	req.AddCookie(sessionCookie)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "john", rec.Header().Get("User"))
+}
+
+func TestHandler_ServeHTTP_callbackFailures(t *testing.T) {
+	idp := newFakeProvider(t)
+
+	h, err := NewHandler(&edge.ACPOIDCConfig{
+		Issuer:       idp.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Session:      edge.ACPOIDCSessionConfig{Secret: testSecret},
+	}, "acp")
+	require.NoError(t, err)
+
+	stateCookie := func(t *testing.T, state, nonce string) *http.Cookie {
+		t.Helper()
+
+		value, err := h.codec.encode(authState{State: state, Nonce: nonce, RedirectURL: "https://app.example.com/"}, time.Now().Add(time.Minute))
+		require.NoError(t, err)
+
+		return &http.Cookie{Name: "hub-session-state", Value: value}
+	}
+
+	tests := []struct {
+		desc   string
+		uri    string
+		cookie *http.Cookie
+		nonce  string
+	}{
+		{
+			desc:  "missing state cookie",
+			uri:   "/callback?code=good-code&state=state",
+			nonce: "nonce",
+		},
+		{
+			desc:   "state mismatch",
+			uri:    "/callback?code=good-code&state=other",
+			cookie: stateCookie(t, "state", "nonce"),
+			nonce:  "nonce",
+		},
+		{
+			desc:   "nonce mismatch",
+			uri:    "/callback?code=good-code&state=state",
+			cookie: stateCookie(t, "state", "nonce"),
+			nonce:  "other",
+		},
+		{
+			desc:   "invalid code",
+			uri:    "/callback?code=bad-code&state=state",
+			cookie: stateCookie(t, "state", "nonce"),
+			nonce:  "nonce",
+		},
+		{
+			desc:   "provider error",
+			uri:    "/callback?error=access_denied&state=state",
+			cookie: stateCookie(t, "state", "nonce"),
+			nonce:  "nonce",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			idp.nonce = test.nonce
+
+			req := forwardedRequest(t, test.uri)
+			if test.cookie != nil {
+				req.AddCookie(test.cookie)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_forbidden(t *testing.T) {
+	h, err := NewHandler(&edge.ACPOIDCConfig{
+		Issuer:   "https://issuer.example.com",
+		ClientID: "client-id",
+		Claims:   "Equals(`grp`, `admin`)",
+		Session:  edge.ACPOIDCSessionConfig{Secret: testSecret},
+	}, "acp")
+	require.NoError(t, err)
+
+	value, err := h.codec.encode(session{Claims: `{"sub":"john","grp":"dev"}`}, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+
+	req := forwardedRequest(t, "/")
+	req.AddCookie(&http.Cookie{Name: "hub-session", Value: value})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+type fakeProvider struct {
+	*httptest.Server
+
+	key   *rsa.PrivateKey
+	nonce string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &fakeProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode(jwt.ProviderMetadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKsURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "key-id", Algorithm: "RS256", Use: "sig"}},
+		})
+	})
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, req *http.Request) {
+		clientID, clientSecret, ok := req.BasicAuth()
+		if !ok || clientID != "client-id" || clientSecret != "client-secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if req.FormValue("code") != "good-code" || req.FormValue("redirect_uri") != "https://app.example.com/callback" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		tok := jwtgo.NewWithClaims(jwtgo.SigningMethodRS256, jwtgo.MapClaims{
+			"iss":   p.URL,
+			"aud":   "client-id",
+			"sub":   "john",
+			"grp":   "admin",
+			"nonce": p.nonce,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		})
+		tok.Header["kid"] = "key-id"
+
+		idToken, err := tok.SignedString(key)
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_ = json.NewEncoder(rw).Encode(tokenResponse{AccessToken: "access-token", TokenType: "Bearer", IDToken: idToken})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func forwardedRequest(t *testing.T, uri string) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "http://agent/acp", http.NoBody)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "app.example.com")
+	req.Header.Set("X-Forwarded-Uri", uri)
+
+	return req
+}
+
+func findCookie(t *testing.T, cookies []*http.Cookie, name string) *http.Cookie {
+	t.Helper()
+
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+
+	require.Failf(t, "cookie not found", "cookie %q not found", name)
+	return nil
+}
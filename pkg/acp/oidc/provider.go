@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/traefik/hub-agent-traefik/pkg/acp/jwt"
+)
+
+// provider is an OpenID Provider.
+type provider struct {
+	discovery *jwt.Discovery
+	client    *http.Client
+}
+
+func newProvider(issuer string, client *http.Client) *provider {
+	return &provider{
+		discovery: jwt.NewDiscovery(issuer, jwt.RemoteKeySetConfig{}),
+		client:    client,
+	}
+}
+
+// discover returns the provider metadata and key set.
+func (p *provider) discover(ctx context.Context) (*jwt.ProviderMetadata, *jwt.RemoteKeySet, error) {
+	metadata, err := p.discovery.Metadata(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" {
+		return nil, nil, errors.New("incomplete provider metadata")
+	}
+
+	keySet, err := p.discovery.KeySet(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return metadata, keySet, nil
+}
+
+// tokenResponse is the response of a successful token request.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+// exchange exchanges an authorization code for tokens.
+func (p *provider) exchange(ctx context.Context, endpoint, clientID, clientSecret, code, redirectURI string) (*tokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status code %q: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var tok tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("decode token response: %w", err)
+	}
+
+	if tok.IDToken == "" {
+		return nil, errors.New("token response does not contain an ID token")
+	}
+
+	return &tok, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	josejwt "gopkg.in/square/go-jose.v2/jwt"
+)
+
+const minSecretLength = 32
+
+// session is the content of the session cookie.
+type session struct {
+	// Claims holds the raw JSON claims of the ID token.
+	Claims string `json:"claims"`
+}
+
+// authState is the content of the state cookie set while the user authenticates against the provider.
+type authState struct {
+	State       string `json:"state"`
+	Nonce       string `json:"nonce"`
+	RedirectURL string `json:"redirectUrl"`
+}
+
+// payload wraps the data stored in a cookie.
+type payload struct {
+	Data string `json:"dat"`
+}
+
+// cookieCodec signs and encrypts cookie values.
+type cookieCodec struct {
+	signKey   []byte
+	encKey    []byte
+	signer    jose.Signer
+	encrypter jose.Encrypter
+}
+
+func newCookieCodec(secret string) (*cookieCodec, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("session secret must be at least %d characters long", minSecretLength)
+	}
+
+	signKey := deriveKey(secret, "session-signing")
+	encKey := deriveKey(secret, "session-encryption")
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: signKey}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return nil, fmt.Errorf("create signer: %w", err)
+	}
+
+	encrypter, err := jose.NewEncrypter(
+		jose.A256GCM,
+		jose.Recipient{Algorithm: jose.DIRECT, Key: encKey},
+		(&jose.EncrypterOptions{}).WithType("JWT").WithContentType("JWT"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create encrypter: %w", err)
+	}
+
+	return &cookieCodec{
+		signKey:   signKey,
+		encKey:    encKey,
+		signer:    signer,
+		encrypter: encrypter,
+	}, nil
+}
+
+// encode serializes v in a signed and encrypted token valid until the given expiry.
+func (c *cookieCodec) encode(v interface{}, expiry time.Time) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshal cookie data: %w", err)
+	}
+
+	return josejwt.SignedAndEncrypted(c.signer, c.encrypter).
+		Claims(josejwt.Claims{Expiry: josejwt.NewNumericDate(expiry)}).
+		Claims(payload{Data: string(data)}).
+		CompactSerialize()
+}
+
+// decode decrypts and verifies the given token and deserializes its content into v.
+func (c *cookieCodec) decode(raw string, v interface{}) error {
+	nested, err := josejwt.ParseSignedAndEncrypted(raw)
+	if err != nil {
+		return fmt.Errorf("parse token: %w", err)
+	}
+
+	tok, err := nested.Decrypt(c.encKey)
+	if err != nil {
+		return fmt.Errorf("decrypt token: %w", err)
+	}
+
+	var (
+		claims josejwt.Claims
+		p      payload
+	)
+	if err = tok.Claims(c.signKey, &claims, &p); err != nil {
+		return fmt.Errorf("verify token: %w", err)
+	}
+
+	if claims.Expiry == nil {
+		return errors.New("missing expiry")
+	}
+	if err = claims.ValidateWithLeeway(josejwt.Expected{Time: time.Now()}, 0); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(strings.NewReader(p.Data))
+	dec.UseNumber()
+
+	if err = dec.Decode(v); err != nil {
+		return fmt.Errorf("decode cookie data: %w", err)
+	}
+
+	return nil
+}
+
+func deriveKey(secret, label string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(label))
+
+	return mac.Sum(nil)
+}
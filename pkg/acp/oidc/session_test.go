@@ -0,0 +1,77 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package oidc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieCodec(t *testing.T) {
+	codec, err := newCookieCodec(testSecret)
+	require.NoError(t, err)
+
+	value, err := codec.encode(session{Claims: `{"sub":"john"}`}, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+
+	var got session
+	err = codec.decode(value, &got)
+	require.NoError(t, err)
+
+	assert.Equal(t, session{Claims: `{"sub":"john"}`}, got)
+}
+
+func TestCookieCodec_decodeFailures(t *testing.T) {
+	codec, err := newCookieCodec(testSecret)
+	require.NoError(t, err)
+
+	otherCodec, err := newCookieCodec(testSecret + "-other")
+	require.NoError(t, err)
+
+	valid, err := codec.encode(session{Claims: `{}`}, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+
+	expired, err := codec.encode(session{Claims: `{}`}, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+
+	foreign, err := otherCodec.encode(session{Claims: `{}`}, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc  string
+		value string
+	}{
+		{desc: "garbage", value: "garbage"},
+		{desc: "tampered", value: valid[:len(valid)-4] + "AAAA"},
+		{desc: "expired", value: expired},
+		{desc: "encrypted with another secret", value: foreign},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var got session
+			assert.Error(t, codec.decode(test.value, &got))
+		})
+	}
+}
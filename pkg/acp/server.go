@@ -23,37 +23,71 @@ import (
 	"fmt"
 	stdlog "log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/apikey"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/audit"
 	"github.com/traefik/hub-agent-traefik/pkg/acp/basicauth"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/composite"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/denial"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/introspection"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/ipfilter"
 	"github.com/traefik/hub-agent-traefik/pkg/acp/jwt"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/mtls"
+	"github.com/traefik/hub-agent-traefik/pkg/acp/oidc"
 	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"github.com/traefik/hub-agent-traefik/pkg/health"
+	"github.com/traefik/hub-agent-traefik/pkg/telemetry"
 )
 
 // Server serves ACP endpoints.
 type Server struct {
 	listenAddr string
 	handler    *httpHandler
+	cache      *decisionCache
+	health     *health.Checker
+	audit      *audit.Logger
+
+	mu       sync.Mutex
+	handlers map[string]acpHandler
+}
+
+// acpHandler is an ACP handler along with the version of the ACP it was built from.
+type acpHandler struct {
+	version string
+	handler http.Handler
 }
 
-// NewServer creates a new ACP Server.
-func NewServer(listenAddr string) *Server {
+// NewServer creates a new ACP Server. Its readiness and liveness endpoints report the status of the given checker.
+// Decisions of the JWT and basic auth ACPs are audited by the given logger, if any, including the ones served from
+// the decision cache.
+func NewServer(listenAddr string, cacheCfg DecisionCacheConfig, checker *health.Checker, auditLogger *audit.Logger) *Server {
 	return &Server{
 		listenAddr: listenAddr,
 		handler:    newHTTPHandler(),
+		cache:      newDecisionCache(cacheCfg),
+		health:     checker,
+		audit:      auditLogger,
 	}
 }
 
-// UpdateHandler updates auth routes served by the Server.
+// UpdateHandler updates auth routes served by the Server. Handlers of the ACPs whose version didn't change are kept,
+// along with their state such as lockouts, cached introspections or fetched keys.
 func (s *Server) UpdateHandler(acps []edge.ACP) error {
-	routes, err := buildRoutes(acps)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	routes, handlers, err := buildRoutes(acps, s.handlers, s.cache, s.audit)
 	if err != nil {
 		return fmt.Errorf("build routes: %w", err)
 	}
 
 	s.handler.Update(routes)
+	s.handlers = handlers
+	s.cache.retain(acps)
 
 	return nil
 }
@@ -62,12 +96,8 @@ func (s *Server) UpdateHandler(acps []edge.ACP) error {
 func (s *Server) Run(ctx context.Context) error {
 	mux := http.NewServeMux()
 
-	mux.Handle("/_live", http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
-		rw.WriteHeader(http.StatusOK)
-	}))
-	mux.Handle("/_ready", http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
-		rw.WriteHeader(http.StatusOK)
-	}))
+	mux.Handle("/_live", s.health.LiveHandler())
+	mux.Handle("/_ready", s.health.ReadyHandler())
 
 	mux.Handle("/", s.handler)
 
@@ -106,36 +136,197 @@ func (s *Server) Run(ctx context.Context) error {
 	}
 }
 
-func buildRoutes(acps []edge.ACP) (http.Handler, error) {
+// buildRoutes builds the routes of the given ACPs. Previous handlers are reused for the ACPs whose version is unchanged.
+// It also returns the handlers of the ACPs, to be given on the next build.
+func buildRoutes(acps []edge.ACP, previous map[string]acpHandler, cache *decisionCache, auditLogger *audit.Logger) (http.Handler, map[string]acpHandler, error) {
 	mux := http.NewServeMux()
 
+	built := make(map[string]acpHandler, len(acps))
+	handlers := make(map[string]http.Handler, len(acps))
+	var composites []edge.ACP
+
+	register := func(acp edge.ACP, path string, h http.Handler) {
+		built[acp.Name] = acpHandler{version: acp.Version, handler: h}
+
+		var cacheAuditLogger *audit.Logger
+		if acp.JWT != nil || acp.BasicAuth != nil {
+			cacheAuditLogger = auditLogger
+		}
+		h = cache.wrap(acp, h, cacheAuditLogger)
+
+		// Composite ACPs use the non instrumented handlers, so decisions are only counted for the requested ACP.
+		// They also enforce the decisions of the ACPs they reference, whatever their mode.
+		mux.Handle(path, enforce(acp, instrument(acp.Name, h)))
+		handlers[acp.Name] = h
+	}
+
 	for _, acp := range acps {
+		if acp.Mode != "" && acp.Mode != edge.ACPModeEnforce && acp.Mode != edge.ACPModeAudit {
+			return nil, nil, fmt.Errorf("unknown mode %q for ACP %q", acp.Mode, acp.Name)
+		}
+
+		// Only the JWT and basic auth handlers know how to write the configured denial responses.
+		if acp.Denial != nil && acp.JWT == nil && acp.BasicAuth == nil {
+			return nil, nil, fmt.Errorf("denial is not supported by ACP %q", acp.Name)
+		}
+
+		if prev, ok := previous[acp.Name]; ok && acp.Composite == nil && acp.Version != "" && prev.version == acp.Version {
+			register(acp, "/"+acp.Name, prev.handler)
+			continue
+		}
+
 		switch {
 		case acp.JWT != nil:
 			jwtHandler, err := jwt.NewHandler(acp.JWT, acp.Name)
 			if err != nil {
-				return nil, fmt.Errorf("create %q JWT ACP handler: %w", acp.Name, err)
+				return nil, nil, fmt.Errorf("create %q JWT ACP handler: %w", acp.Name, err)
 			}
+			jwtHandler.SetAuditLogger(auditLogger)
+
+			responder, err := denialResponder(acp)
+			if err != nil {
+				return nil, nil, fmt.Errorf("create %q JWT ACP handler: %w", acp.Name, err)
+			}
+			jwtHandler.SetDenialResponder(responder)
 
 			path := "/" + acp.Name
 
 			log.Debug().Str("acp_name", acp.Name).Str("path", path).Msg("Registering JWT ACP handler")
 
-			mux.Handle(path, jwtHandler)
+			register(acp, path, jwtHandler)
 
 		case acp.BasicAuth != nil:
 			h, err := basicauth.NewHandler(acp.BasicAuth, acp.Name)
 			if err != nil {
-				return nil, fmt.Errorf("create %q basic auth ACP handler: %w", acp.Name, err)
+				return nil, nil, fmt.Errorf("create %q basic auth ACP handler: %w", acp.Name, err)
+			}
+			h.SetAuditLogger(auditLogger)
+
+			responder, err := denialResponder(acp)
+			if err != nil {
+				return nil, nil, fmt.Errorf("create %q basic auth ACP handler: %w", acp.Name, err)
 			}
+			h.SetDenialResponder(responder)
 			path := "/" + acp.Name
 			log.Debug().Str("acp_name", acp.Name).Str("path", path).Msg("Registering basic auth ACP handler")
-			mux.Handle(path, h)
+			register(acp, path, h)
+
+		case acp.OIDC != nil:
+			h, err := oidc.NewHandler(acp.OIDC, acp.Name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("create %q OIDC ACP handler: %w", acp.Name, err)
+			}
+			path := "/" + acp.Name
+			log.Debug().Str("acp_name", acp.Name).Str("path", path).Msg("Registering OIDC ACP handler")
+			register(acp, path, h)
+
+		case acp.Introspection != nil:
+			h, err := introspection.NewHandler(acp.Introspection, acp.Name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("create %q introspection ACP handler: %w", acp.Name, err)
+			}
+			path := "/" + acp.Name
+			log.Debug().Str("acp_name", acp.Name).Str("path", path).Msg("Registering introspection ACP handler")
+			register(acp, path, h)
+
+		case acp.APIKey != nil:
+			h, err := apikey.NewHandler(acp.APIKey, acp.Name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("create %q API key ACP handler: %w", acp.Name, err)
+			}
+			path := "/" + acp.Name
+			log.Debug().Str("acp_name", acp.Name).Str("path", path).Msg("Registering API key ACP handler")
+			register(acp, path, h)
+
+		case acp.MTLS != nil:
+			h, err := mtls.NewHandler(acp.MTLS, acp.Name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("create %q mTLS ACP handler: %w", acp.Name, err)
+			}
+			path := "/" + acp.Name
+			log.Debug().Str("acp_name", acp.Name).Str("path", path).Msg("Registering mTLS ACP handler")
+			register(acp, path, h)
+
+		case acp.IPFilter != nil:
+			h, err := ipfilter.NewHandler(acp.IPFilter, acp.Name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("create %q IP filter ACP handler: %w", acp.Name, err)
+			}
+			path := "/" + acp.Name
+			log.Debug().Str("acp_name", acp.Name).Str("path", path).Msg("Registering IP filter ACP handler")
+			register(acp, path, h)
+
+		case acp.Composite != nil:
+			// Composite ACPs are built once the ACPs they reference are known.
+			composites = append(composites, acp)
 
 		default:
-			return nil, errors.New("unknown ACP handler type")
+			return nil, nil, errors.New("unknown ACP handler type")
+		}
+	}
+
+	for _, acp := range composites {
+		h, err := composite.NewHandler(acp.Composite, acp.Name, handlers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create %q composite ACP handler: %w", acp.Name, err)
 		}
+		path := "/" + acp.Name
+		log.Debug().Str("acp_name", acp.Name).Str("path", path).Msg("Registering composite ACP handler")
+		mux.Handle(path, enforce(acp, instrument(acp.Name, h)))
 	}
 
-	return mux, nil
+	return mux, built, nil
+}
+
+// enforce returns a handler enforcing the decisions of the given ACP handler, unless the ACP is in audit mode.
+func enforce(acp edge.ACP, h http.Handler) http.Handler {
+	if acp.Mode != edge.ACPModeAudit {
+		return h
+	}
+
+	return &auditModeHandler{name: acp.Name, handler: h}
+}
+
+// denialResponder returns the responder writing the responses of the given ACP denying requests, if it has one.
+func denialResponder(acp edge.ACP) (*denial.Responder, error) {
+	if acp.Denial == nil {
+		return nil, nil
+	}
+
+	r, err := denial.NewResponder(acp.Denial)
+	if err != nil {
+		return nil, fmt.Errorf("create denial responder: %w", err)
+	}
+
+	return r, nil
+}
+
+// instrument returns a handler counting the decisions of the given ACP handler.
+func instrument(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rec := &decisionRecorder{ResponseWriter: rw}
+		h.ServeHTTP(rec, req)
+
+		telemetry.ACPDecisions.Inc(name, outcome(rec.code))
+	})
+}
+
+// outcome returns the outcome of an auth request answered with the given status code.
+func outcome(code int) string {
+	switch {
+	case code >= 200 && code < 300:
+		return "allowed"
+	case code >= 300 && code < 400:
+		return "redirected"
+	case code == http.StatusUnauthorized:
+		return "unauthorized"
+	case code == http.StatusForbidden:
+		return "forbidden"
+	case code == http.StatusTooManyRequests:
+		return "rate_limited"
+	case code >= 500:
+		return "error"
+	default:
+		return "denied"
+	}
 }
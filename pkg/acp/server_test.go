@@ -0,0 +1,200 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package acp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestServer_UpdateHandler_keepsLockout(t *testing.T) {
+	srv := NewServer("", DecisionCacheConfig{}, nil, nil)
+
+	acp := edge.ACP{
+		Name:    "acp",
+		Version: "1",
+		BasicAuth: &edge.ACPBasicAuthConfig{
+			Users:   []string{"test:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/"},
+			Lockout: &edge.ACPLockoutConfig{MaxAttempts: 1, Period: time.Minute, LockoutDuration: time.Hour},
+		},
+	}
+
+	serve := func(password string) int {
+		req := httptest.NewRequest(http.MethodGet, "/acp", http.NoBody)
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		req.SetBasicAuth("test", password)
+		rec := httptest.NewRecorder()
+
+		srv.handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	require.NoError(t, srv.UpdateHandler([]edge.ACP{acp}))
+	assert.Equal(t, http.StatusUnauthorized, serve("wrong"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("test"))
+
+	// The ACP is unchanged: the client stays locked out.
+	require.NoError(t, srv.UpdateHandler([]edge.ACP{acp}))
+	assert.Equal(t, http.StatusTooManyRequests, serve("test"))
+
+	acp.Version = "2"
+	require.NoError(t, srv.UpdateHandler([]edge.ACP{acp}))
+	assert.Equal(t, http.StatusOK, serve("test"))
+}
+
+func TestServer_UpdateHandler_keepsIntrospectionCache(t *testing.T) {
+	var calls int32
+	idp := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{"active": true, "sub": "john"})
+	}))
+	t.Cleanup(idp.Close)
+
+	srv := NewServer("", DecisionCacheConfig{}, nil, nil)
+
+	acps := []edge.ACP{
+		{
+			Name:    "acp",
+			Version: "1",
+			Introspection: &edge.ACPIntrospectionConfig{
+				Endpoint: idp.URL,
+				CacheTTL: time.Hour,
+			},
+		},
+	}
+
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/acp", http.NoBody)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+
+		srv.handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	require.NoError(t, srv.UpdateHandler(acps))
+	assert.Equal(t, http.StatusOK, serve())
+
+	require.NoError(t, srv.UpdateHandler(acps))
+	assert.Equal(t, http.StatusOK, serve())
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestServer_UpdateHandler_keepsJWKs(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "key-id", Algorithm: "RS256", Use: "sig"}},
+		})
+	}))
+	t.Cleanup(idp.Close)
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "john"})
+	tok.Header["kid"] = "key-id"
+	signed, err := tok.SignedString(key)
+	require.NoError(t, err)
+
+	srv := NewServer("", DecisionCacheConfig{}, nil, nil)
+
+	acps := []edge.ACP{
+		{
+			Name:    "acp",
+			Version: "1",
+			JWT:     &edge.ACPJWTConfig{JWKsURL: idp.URL},
+		},
+	}
+
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/acp", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+signed)
+		rec := httptest.NewRecorder()
+
+		srv.handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	require.NoError(t, srv.UpdateHandler(acps))
+	assert.Equal(t, http.StatusOK, serve())
+
+	// The IdP is unreachable: the keys fetched before the updates keep being used.
+	idp.Close()
+
+	require.NoError(t, srv.UpdateHandler(acps))
+	assert.Equal(t, http.StatusOK, serve())
+
+	require.NoError(t, srv.UpdateHandler(acps))
+	assert.Equal(t, http.StatusOK, serve())
+}
+
+func TestBuildRoutes_denial(t *testing.T) {
+	denial := &edge.ACPDenialConfig{Body: "denied"}
+
+	tests := []struct {
+		desc    string
+		acp     edge.ACP
+		wantErr bool
+	}{
+		{
+			desc: "JWT",
+			acp:  edge.ACP{Name: "acp", JWT: &edge.ACPJWTConfig{SigningSecret: "secret"}, Denial: denial},
+		},
+		{
+			desc: "basic auth",
+			acp:  edge.ACP{Name: "acp", BasicAuth: &edge.ACPBasicAuthConfig{Users: []string{"user:$apr1$D2kU4Bx4$Cv0kmVd3m9TTQGoN8vn5/0"}}, Denial: denial},
+		},
+		{
+			desc:    "IP filter",
+			acp:     edge.ACP{Name: "acp", IPFilter: &edge.ACPIPFilterConfig{AllowedRanges: []string{"10.0.0.0/8"}}, Denial: denial},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, _, err := buildRoutes([]edge.ACP{test.acp}, nil, nil, nil)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -19,6 +19,14 @@ package edge
 
 import "time"
 
+// ACP modes.
+const (
+	// ACPModeEnforce denies the requests the ACP doesn't allow. It is the default mode.
+	ACPModeEnforce = "enforce"
+	// ACPModeAudit computes the decisions of the ACP without enforcing them: all requests are allowed.
+	ACPModeAudit = "audit"
+)
+
 // ACP is an Access Control Policy definition.
 type ACP struct {
 	ID          string `json:"id"`
@@ -27,14 +35,35 @@ type ACP struct {
 
 	Version string `json:"version"`
 
-	Name      string              `json:"name"`
-	JWT       *ACPJWTConfig       `json:"jwt"`
-	BasicAuth *ACPBasicAuthConfig `json:"basicAuth"`
+	Name          string                  `json:"name"`
+	Mode          string                  `json:"mode"`
+	JWT           *ACPJWTConfig           `json:"jwt"`
+	BasicAuth     *ACPBasicAuthConfig     `json:"basicAuth"`
+	OIDC          *ACPOIDCConfig          `json:"oidc"`
+	Introspection *ACPIntrospectionConfig `json:"introspection"`
+	APIKey        *ACPAPIKeyConfig        `json:"apiKey"`
+	MTLS          *ACPMTLSConfig          `json:"mtls"`
+	Composite     *ACPCompositeConfig     `json:"composite"`
+	IPFilter      *ACPIPFilterConfig      `json:"ipFilter"`
+
+	Denial *ACPDenialConfig `json:"denial"`
 
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// ACPDenialConfig configures the responses of the JWT and basic auth ACP handlers denying requests.
+// It replaces the basic auth challenge: a WWW-Authenticate header must be added to keep it.
+type ACPDenialConfig struct {
+	// RedirectURL is the URL requests are redirected to. The URL of the denied request is given in the
+	// RedirectParam query parameter.
+	RedirectURL   string            `json:"redirectUrl"`
+	RedirectParam string            `json:"redirectParam"`
+	Body          string            `json:"body"`
+	ContentType   string            `json:"contentType"`
+	Headers       map[string]string `json:"headers"`
+}
+
 // ACPJWTConfig configures a JWT ACP handler.
 type ACPJWTConfig struct {
 	SigningSecret              string            `json:"signingSecret"`
@@ -42,16 +71,120 @@ type ACPJWTConfig struct {
 	PublicKey                  string            `json:"publicKey"`
 	JWKsFile                   FileOrContent     `json:"jwksFile"`
 	JWKsURL                    string            `json:"jwksUrl"`
+	JWKsMinRefreshInterval     time.Duration     `json:"jwksMinRefreshInterval"`
+	JWKsGracePeriod            time.Duration     `json:"jwksGracePeriod"`
+	OIDCDiscovery              bool              `json:"oidcDiscovery"`
+	Algorithms                 []string          `json:"algorithms"`
+	Issuers                    []string          `json:"issuers"`
+	Audiences                  []string          `json:"audiences"`
+	Leeway                     time.Duration     `json:"leeway"`
 	StripAuthorizationHeader   bool              `json:"stripAuthorizationHeader"`
 	ForwardHeaders             map[string]string `json:"forwardHeaders"`
+	ForwardHeadersDefaults     map[string]string `json:"forwardHeadersDefaults"`
 	TokenQueryKey              string            `json:"tokenQueryKey"`
 	Claims                     string            `json:"claims"`
+	Lockout                    *ACPLockoutConfig `json:"lockout"`
 }
 
 // ACPBasicAuthConfig configures a basic auth ACP handler.
 type ACPBasicAuthConfig struct {
-	Users                    []string `json:"users"`
-	Realm                    string   `json:"realm"`
-	StripAuthorizationHeader bool     `json:"stripAuthorizationHeader"`
-	ForwardUsernameHeader    string   `json:"forwardUsernameHeader"`
+	Users                    []string          `json:"users"`
+	Realm                    string            `json:"realm"`
+	StripAuthorizationHeader bool              `json:"stripAuthorizationHeader"`
+	ForwardUsernameHeader    string            `json:"forwardUsernameHeader"`
+	Lockout                  *ACPLockoutConfig `json:"lockout"`
+}
+
+// ACPLockoutConfig configures how failed authentications are limited. Once MaxAttempts authentications failed
+// within Period for a client IP, or a username, further attempts are rejected for LockoutDuration.
+type ACPLockoutConfig struct {
+	MaxAttempts     int            `json:"maxAttempts"`
+	Period          time.Duration  `json:"period"`
+	LockoutDuration time.Duration  `json:"lockoutDuration"`
+	IPStrategy      *ACPIPStrategy `json:"ipStrategy"`
+}
+
+// ACPOIDCConfig configures an OpenID Connect ACP handler.
+type ACPOIDCConfig struct {
+	Issuer         string               `json:"issuer"`
+	ClientID       string               `json:"clientId"`
+	ClientSecret   string               `json:"clientSecret"`
+	Scopes         []string             `json:"scopes"`
+	RedirectPath   string               `json:"redirectPath"`
+	Session        ACPOIDCSessionConfig `json:"session"`
+	ForwardHeaders map[string]string    `json:"forwardHeaders"`
+	Claims         string               `json:"claims"`
+}
+
+// ACPOIDCSessionConfig configures the session cookie of an OpenID Connect ACP handler.
+type ACPOIDCSessionConfig struct {
+	Secret   string        `json:"secret"`
+	Name     string        `json:"name"`
+	Path     string        `json:"path"`
+	Domain   string        `json:"domain"`
+	SameSite string        `json:"sameSite"`
+	Secure   bool          `json:"secure"`
+	Expiry   time.Duration `json:"expiry"`
+}
+
+// ACPIntrospectionConfig configures an OAuth 2.0 token introspection ACP handler.
+type ACPIntrospectionConfig struct {
+	Endpoint                 string            `json:"endpoint"`
+	ClientID                 string            `json:"clientId"`
+	ClientSecret             string            `json:"clientSecret"`
+	TokenTypeHint            string            `json:"tokenTypeHint"`
+	CacheTTL                 time.Duration     `json:"cacheTtl"`
+	NegativeCacheTTL         time.Duration     `json:"negativeCacheTtl"`
+	StripAuthorizationHeader bool              `json:"stripAuthorizationHeader"`
+	ForwardHeaders           map[string]string `json:"forwardHeaders"`
+	TokenQueryKey            string            `json:"tokenQueryKey"`
+	Claims                   string            `json:"claims"`
+}
+
+// ACPAPIKeyConfig configures an API key ACP handler.
+type ACPAPIKeyConfig struct {
+	Header         string            `json:"header"`
+	Query          string            `json:"query"`
+	Keys           []ACPAPIKey       `json:"keys"`
+	ForwardHeaders map[string]string `json:"forwardHeaders"`
+}
+
+// ACPAPIKey is an API key allowed by an API key ACP handler.
+type ACPAPIKey struct {
+	ID string `json:"id"`
+	// Value is the salted hash of the key, formatted as `$sha256$<base64 salt>$<base64 hash>`.
+	Value    string            `json:"value"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// ACPMTLSConfig configures a mutual TLS ACP handler.
+type ACPMTLSConfig struct {
+	CABundle       FileOrContent     `json:"caBundle"`
+	ForwardHeaders map[string]string `json:"forwardHeaders"`
+	Claims         string            `json:"claims"`
+}
+
+// Composite ACP operators.
+const (
+	ACPCompositeOperatorAnd = "and"
+	ACPCompositeOperatorOr  = "or"
+)
+
+// ACPCompositeConfig configures a composite ACP handler, which combines other ACPs, referenced by name.
+type ACPCompositeConfig struct {
+	Operator string   `json:"operator"`
+	ACPs     []string `json:"acps"`
+}
+
+// ACPIPFilterConfig configures an IP filter ACP handler.
+type ACPIPFilterConfig struct {
+	AllowedRanges []string       `json:"allowedRanges"`
+	DeniedRanges  []string       `json:"deniedRanges"`
+	IPStrategy    *ACPIPStrategy `json:"ipStrategy"`
+}
+
+// ACPIPStrategy configures how the client IP is extracted from the X-Forwarded-For header.
+type ACPIPStrategy struct {
+	Depth       int      `json:"depth"`
+	ExcludedIPs []string `json:"excludedIps"`
 }
@@ -32,6 +32,15 @@ type Ingress struct {
 	Service Service  `json:"service"`
 	ACP     *ACPInfo `json:"acp,omitempty"`
 
+	// Services, when set, replaces Service to split the traffic between several weighted services.
+	Services     []Service     `json:"services,omitempty"`
+	LoadBalancer *LoadBalancer `json:"loadBalancer,omitempty"`
+
+	// CustomDomains are the domains the Ingress is exposed on, in addition to Domain.
+	CustomDomains []string `json:"customDomains,omitempty"`
+	// Routes send the requests matching their path prefix to their own service, instead of Service.
+	Routes []Route `json:"routes,omitempty"`
+
 	Version   string    `json:"version"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
@@ -43,6 +52,43 @@ type Service struct {
 	Name    string `json:"name"`
 	Network string `json:"network"`
 	Port    int    `json:"port"`
+	// Weight is the share of the traffic sent to the service when an Ingress has several services.
+	// It defaults to 1.
+	Weight int `json:"weight,omitempty"`
+}
+
+// Route routes the requests of an Ingress matching a path prefix, and optionally headers, to a service.
+type Route struct {
+	PathPrefix string `json:"pathPrefix"`
+	// Headers are the headers, and their values, requests must have to match the route.
+	Headers map[string]string `json:"headers,omitempty"`
+	// StripPrefix tells whether the path prefix is removed from the requests forwarded to the service.
+	StripPrefix bool    `json:"stripPrefix,omitempty"`
+	Service     Service `json:"service"`
+}
+
+// LoadBalancer configures how the traffic of an Ingress is load balanced between the replicas of its services.
+type LoadBalancer struct {
+	Sticky      *Sticky      `json:"sticky,omitempty"`
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+}
+
+// Sticky configures the cookie used to send the requests of a client to the same replica.
+type Sticky struct {
+	CookieName string `json:"cookieName,omitempty"`
+	Secure     bool   `json:"secure,omitempty"`
+	HTTPOnly   bool   `json:"httpOnly,omitempty"`
+	SameSite   string `json:"sameSite,omitempty"`
+}
+
+// HealthCheck configures the active health checks removing unhealthy replicas from the load balancer.
+type HealthCheck struct {
+	Path     string            `json:"path"`
+	Port     int               `json:"port,omitempty"`
+	Interval time.Duration     `json:"interval,omitempty"`
+	Timeout  time.Duration     `json:"timeout,omitempty"`
+	Hostname string            `json:"hostname,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
 }
 
 // ACPInfo represents an ACP for an Ingress.
@@ -38,16 +38,24 @@ type Watcher struct {
 	interval time.Duration
 
 	listeners []Listener
+	heartbeat func()
 }
 
 // NewWatcher return a new Watcher.
 func NewWatcher(c *Client, interval time.Duration) *Watcher {
 	return &Watcher{
-		client:   c,
-		interval: interval,
+		client:    c,
+		interval:  interval,
+		heartbeat: func() {},
 	}
 }
 
+// SetHeartbeat sets the function called each time the watcher loop runs.
+// It must be called before running the watcher.
+func (w *Watcher) SetHeartbeat(fn func()) {
+	w.heartbeat = fn
+}
+
 // AddListener adds a listener.
 func (w *Watcher) AddListener(listener Listener) {
 	w.listeners = append(w.listeners, listener)
@@ -74,6 +82,8 @@ func (w *Watcher) Run(ctx context.Context) {
 				log.Error().Err(err).Msg("Unable to reload hub-agent-traefik configuration after receiving SIGHUP")
 			}
 		case <-t.C:
+			w.heartbeat()
+
 			if err := w.reload(ctx); err != nil {
 				log.Error().Err(err).Msg("Unable to reload hub-agent-traefik configuration")
 			}
@@ -0,0 +1,232 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const checkTimeout = 5 * time.Second
+
+// Check checks the readiness of a component. It returns an error when the component is not ready.
+type Check func(ctx context.Context) error
+
+// Checker reports the readiness and the liveness of the agent.
+//
+// The agent is ready once all its components are. A component is either reported ready by the agent, or checked
+// on each readiness request. The agent is alive as long as all its loops keep running.
+type Checker struct {
+	mu         sync.Mutex
+	components map[string]*component
+	loops      map[string]*loop
+}
+
+type component struct {
+	ready bool
+	check Check
+}
+
+type loop struct {
+	maxSilence time.Duration
+	lastBeat   time.Time
+}
+
+// ComponentStatus is the status of a component.
+type ComponentStatus struct {
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReadinessStatus is the readiness status of the agent.
+type ReadinessStatus struct {
+	Ready      bool                       `json:"ready"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// LoopStatus is the status of a loop.
+type LoopStatus struct {
+	Alive    bool      `json:"alive"`
+	LastBeat time.Time `json:"lastBeat"`
+}
+
+// LivenessStatus is the liveness status of the agent.
+type LivenessStatus struct {
+	Alive bool                  `json:"alive"`
+	Loops map[string]LoopStatus `json:"loops"`
+}
+
+// NewChecker returns a new Checker.
+func NewChecker() *Checker {
+	return &Checker{
+		components: make(map[string]*component),
+		loops:      make(map[string]*loop),
+	}
+}
+
+// Register registers a component which is not ready until SetReady is called.
+func (c *Checker) Register(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.components[name] = &component{}
+}
+
+// SetReady marks the given component as ready.
+func (c *Checker) SetReady(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	comp, ok := c.components[name]
+	if !ok {
+		log.Error().Str("component", name).Msg("Unable to set the readiness of an unregistered component")
+		return
+	}
+
+	comp.ready = true
+}
+
+// AddCheck registers a component whose readiness is checked on each readiness request.
+func (c *Checker) AddCheck(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.components[name] = &component{check: check}
+}
+
+// Loop registers a loop, which must call the returned function at least every maxSilence to be considered alive.
+func (c *Checker) Loop(name string, maxSilence time.Duration) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l := &loop{maxSilence: maxSilence, lastBeat: time.Now()}
+	c.loops[name] = l
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		l.lastBeat = time.Now()
+	}
+}
+
+// Readiness returns the readiness status of the agent.
+func (c *Checker) Readiness(ctx context.Context) ReadinessStatus {
+	status := ReadinessStatus{Ready: true, Components: make(map[string]ComponentStatus)}
+
+	checks := make(map[string]Check)
+
+	c.mu.Lock()
+	for name, comp := range c.components {
+		if comp.check != nil {
+			checks[name] = comp.check
+			continue
+		}
+
+		compStatus := ComponentStatus{Ready: comp.ready}
+		if !comp.ready {
+			compStatus.Error = "not ready yet"
+		}
+		status.Components[name] = compStatus
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			compStatus := ComponentStatus{Ready: true}
+			if err := check(ctx); err != nil {
+				compStatus = ComponentStatus{Error: err.Error()}
+			}
+
+			mu.Lock()
+			status.Components[name] = compStatus
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	for _, compStatus := range status.Components {
+		status.Ready = status.Ready && compStatus.Ready
+	}
+
+	return status
+}
+
+// Liveness returns the liveness status of the agent.
+func (c *Checker) Liveness(now time.Time) LivenessStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := LivenessStatus{Alive: true, Loops: make(map[string]LoopStatus)}
+	for name, l := range c.loops {
+		alive := now.Sub(l.lastBeat) <= l.maxSilence
+
+		status.Loops[name] = LoopStatus{Alive: alive, LastBeat: l.lastBeat}
+		status.Alive = status.Alive && alive
+	}
+
+	return status
+}
+
+// ReadyHandler returns a handler answering with the readiness status of the agent.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		status := c.Readiness(req.Context())
+
+		writeStatus(rw, status.Ready, status)
+	})
+}
+
+// LiveHandler returns a handler answering with the liveness status of the agent.
+func (c *Checker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		status := c.Liveness(time.Now())
+
+		writeStatus(rw, status.Alive, status)
+	})
+}
+
+func writeStatus(rw http.ResponseWriter, ok bool, status interface{}) {
+	code := http.StatusOK
+	if !ok {
+		code = http.StatusServiceUnavailable
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+
+	if err := json.NewEncoder(rw).Encode(status); err != nil {
+		log.Error().Err(err).Msg("Unable to write health status")
+	}
+}
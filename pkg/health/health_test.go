@@ -0,0 +1,121 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker_ReadyHandler(t *testing.T) {
+	checker := NewChecker()
+	checker.Register("acps")
+	checker.AddCheck("traefik", func(_ context.Context) error {
+		return nil
+	})
+
+	tunnelsErr := errors.New("no tunnel connected")
+	checker.AddCheck("tunnels", func(_ context.Context) error {
+		return tunnelsErr
+	})
+
+	status, code := serveReady(t, checker)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, ReadinessStatus{
+		Ready: false,
+		Components: map[string]ComponentStatus{
+			"acps":    {Error: "not ready yet"},
+			"traefik": {Ready: true},
+			"tunnels": {Error: "no tunnel connected"},
+		},
+	}, status)
+
+	checker.SetReady("acps")
+	tunnelsErr = nil
+
+	status, code = serveReady(t, checker)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ReadinessStatus{
+		Ready: true,
+		Components: map[string]ComponentStatus{
+			"acps":    {Ready: true},
+			"traefik": {Ready: true},
+			"tunnels": {Ready: true},
+		},
+	}, status)
+}
+
+func TestChecker_Liveness(t *testing.T) {
+	checker := NewChecker()
+
+	beatScraper := checker.Loop("scraper", time.Minute)
+	beatWatcher := checker.Loop("watcher", 2*time.Minute)
+
+	now := time.Now()
+
+	status := checker.Liveness(now)
+	assert.True(t, status.Alive)
+
+	status = checker.Liveness(now.Add(90 * time.Second))
+	assert.False(t, status.Alive)
+	assert.False(t, status.Loops["scraper"].Alive)
+	assert.True(t, status.Loops["watcher"].Alive)
+
+	beatScraper()
+	beatWatcher()
+
+	status = checker.Liveness(time.Now().Add(30 * time.Second))
+	assert.True(t, status.Alive)
+}
+
+func TestChecker_LiveHandler(t *testing.T) {
+	checker := NewChecker()
+	checker.Loop("scraper", 0)
+
+	time.Sleep(time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	checker.LiveHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_live", http.NoBody))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var status LivenessStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.False(t, status.Loops["scraper"].Alive)
+}
+
+func serveReady(t *testing.T, checker *Checker) (ReadinessStatus, int) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	checker.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_ready", http.NoBody))
+
+	var status ReadinessStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+
+	return status, rec.Code
+}
@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/telemetry"
 )
 
 const scrapeInterval = time.Minute
@@ -37,6 +38,8 @@ type Manager struct {
 	sendMu     sync.Mutex
 	sendIntvl  time.Duration
 	sendTables []string
+
+	scraperHeartbeat func()
 }
 
 // NewManager returns a manager.
@@ -47,9 +50,17 @@ func NewManager(client *Client, store *Store, scraper *Scraper) *Manager {
 		scraper:    scraper,
 		sendIntvl:  time.Minute,
 		sendTables: []string{"1m", "10m", "1h", "1d"},
+
+		scraperHeartbeat: func() {},
 	}
 }
 
+// SetScraperHeartbeat sets the function called each time the scraper loop runs.
+// It must be called before running the manager.
+func (m *Manager) SetScraperHeartbeat(fn func()) {
+	m.scraperHeartbeat = fn
+}
+
 // SetConfig updates the configuration of the metrics manager.
 func (m *Manager) SetConfig(sendInterval time.Duration, sendTables []string) {
 	m.sendMu.Lock()
@@ -88,6 +99,7 @@ func (m *Manager) runSender(ctx context.Context) {
 
 		case <-time.After(m.getSendInterval()):
 			if err := m.send(ctx, m.getSendTables()); err != nil {
+				telemetry.MetricsSendFailures.Inc()
 				log.Error().Err(err).Msg("Unable to send metrics")
 			}
 		}
@@ -148,6 +160,7 @@ func (m *Manager) startScraper(ctx context.Context) {
 	}
 
 	ref := Aggregate(mtrcs)
+	m.scraperHeartbeat()
 
 	tick := time.NewTicker(scrapeInterval)
 	defer tick.Stop()
@@ -183,6 +196,7 @@ func (m *Manager) startScraper(ctx context.Context) {
 			m.store.Insert(pnts)
 
 			ref = mtrcSet
+			m.scraperHeartbeat()
 		}
 	}
 }
@@ -208,9 +208,10 @@ func (d Docker) getTraefikNetworks(ctx context.Context) ([]string, error) {
 	return networkNames, nil
 }
 
-// GetIP gets container IP.
-func (d Docker) GetIP(ctx context.Context, serviceName, network string) (string, error) {
-	containerName := serviceName
+// GetIPs gets the IPs of the containers of a service. Docker Compose services have as many IPs as running and
+// healthy containers.
+func (d Docker) GetIPs(ctx context.Context, serviceName, network string) ([]string, error) {
+	containerNames := []string{serviceName}
 
 	splitted := strings.Split(strings.TrimPrefix(serviceName, "/"), "~")
 	if len(splitted) == 2 {
@@ -222,19 +223,61 @@ func (d Docker) GetIP(ctx context.Context, serviceName, network string) (string,
 			Value: fmt.Sprintf("%s=%s", labelDockerComposeService, splitted[1]),
 		})})
 		if err != nil {
-			return "", fmt.Errorf("list containers: %w", err)
+			return nil, fmt.Errorf("list containers: %w", err)
 		}
 
 		if len(containers) > 0 {
-			containerName = containers[0].ID
+			containerNames = make([]string, 0, len(containers))
+			for _, container := range containers {
+				containerNames = append(containerNames, container.ID)
+			}
+			// Containers are sorted to always generate the same configuration.
+			sort.Strings(containerNames)
+		}
+	}
+
+	var (
+		ips     []string
+		lastErr error
+	)
+	for _, containerName := range containerNames {
+		ip, err := d.getIP(ctx, containerName, network)
+		if err != nil {
+			// Containers can be removed while being inspected, the other ones are still used.
+			log.Debug().Err(err).Str("container_name", containerName).Msg("Unable to get container IP")
+			lastErr = err
+			continue
+		}
+
+		if ip != "" {
+			ips = append(ips, ip)
 		}
 	}
 
+	if len(ips) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	return ips, nil
+}
+
+// getIP gets container IP. It returns an empty string for containers which are not running or not healthy.
+func (d Docker) getIP(ctx context.Context, containerName, network string) (string, error) {
 	container, err := d.client.ContainerInspect(ctx, containerName)
 	if err != nil {
 		return "", err
 	}
 
+	if container.State != nil {
+		if !container.State.Running {
+			return "", nil
+		}
+
+		if container.State.Health != nil && container.State.Health.Status != types.Healthy {
+			return "", nil
+		}
+	}
+
 	if container.HostConfig.NetworkMode.IsHost() {
 		if network != "HOST" {
 			return "", fmt.Errorf("the network mode %s is different from HOST", network)
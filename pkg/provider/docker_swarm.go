@@ -263,34 +263,71 @@ func (d DockerSwarm) getAllNetworks(ctx context.Context) ([]dockertypes.NetworkR
 	return d.client.NetworkList(ctx, dockertypes.NetworkListOptions{Filters: networkListArgs})
 }
 
-// GetIP gets service IP.
-func (d DockerSwarm) GetIP(ctx context.Context, serviceName, network string) (string, error) {
-	logger := log.Ctx(ctx)
-
+// GetIPs gets the IPs of a service. Services using the VIP endpoint mode have a single virtual IP, load balanced by
+// Docker, whereas services using the DNSRR endpoint mode have as many IPs as running tasks.
+func (d DockerSwarm) GetIPs(ctx context.Context, serviceName, network string) ([]string, error) {
 	service, _, err := d.client.ServiceInspectWithRaw(ctx, serviceName, dockertypes.ServiceInspectOptions{})
 	if err != nil {
-		return "", fmt.Errorf("service inspect: %w", err)
-	}
-
-	networks, err := d.getAllNetworks(ctx)
-	if err != nil {
-		return "", fmt.Errorf("get networks: %w", err)
+		return nil, fmt.Errorf("service inspect: %w", err)
 	}
 
-	networkMap := toNetworkMap(networks)
-
 	if service.Spec.EndpointSpec == nil {
-		return "", nil
+		return nil, nil
 	}
 
 	switch service.Spec.EndpointSpec.Mode {
 	case swarmtypes.ResolutionModeDNSRR:
-		logger.Debug().Msgf("Ignored %s endpoint-mode not supported", swarmtypes.ResolutionModeDNSRR)
+		return d.getTaskIPs(ctx, service, network)
 	case swarmtypes.ResolutionModeVIP:
-		return getServiceIP(ctx, service, networkMap, network), nil
+		networks, err := d.getAllNetworks(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get networks: %w", err)
+		}
+
+		if ip := getServiceIP(ctx, service, toNetworkMap(networks), network); ip != "" {
+			return []string{ip}, nil
+		}
 	}
 
-	return "", nil
+	return nil, nil
+}
+
+// getTaskIPs gets the IPs of the running tasks of a service in the given network.
+func (d DockerSwarm) getTaskIPs(ctx context.Context, service swarmtypes.Service, network string) ([]string, error) {
+	tasks, err := d.client.TaskList(ctx, dockertypes.TaskListOptions{Filters: filters.NewArgs(
+		filters.Arg("service", service.ID),
+		filters.Arg("desired-state", string(swarmtypes.TaskStateRunning)),
+	)})
+	if err != nil {
+		return nil, fmt.Errorf("list tasks: %w", err)
+	}
+
+	var ips []string
+	for _, task := range tasks {
+		if task.Status.State != swarmtypes.TaskStateRunning {
+			continue
+		}
+
+		for _, attachment := range task.NetworksAttachments {
+			if attachment.Network.Spec.Name != network {
+				continue
+			}
+
+			for _, addr := range attachment.Addresses {
+				ip, _, err := net.ParseCIDR(addr)
+				if err != nil || ip == nil {
+					continue
+				}
+
+				ips = append(ips, ip.String())
+			}
+		}
+	}
+
+	// Tasks are sorted to always generate the same configuration.
+	sort.Strings(ips)
+
+	return ips, nil
 }
 
 func getServiceIP(ctx context.Context, service swarmtypes.Service, networkMap map[string]*dockertypes.NetworkResource, network string) string {
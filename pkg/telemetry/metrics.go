@@ -0,0 +1,51 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package telemetry
+
+// DefaultRegistry is the registry holding the metrics of the agent.
+var DefaultRegistry = NewRegistry()
+
+// Metrics of the agent.
+var (
+	ACPDecisions = DefaultRegistry.NewCounterVec("hub_agent_acp_decisions_total",
+		"Number of auth requests handled by ACPs, by ACP and outcome.", "acp", "outcome")
+
+	JWKSFetchDuration = DefaultRegistry.NewHistogramVec("hub_agent_jwks_fetch_duration_seconds",
+		"Duration of JWKS fetches, by result.", DefBuckets, "result")
+
+	TunnelSessions = DefaultRegistry.NewGauge("hub_agent_tunnel_sessions",
+		"Number of open tunnel sessions.")
+
+	MetricsSendFailures = DefaultRegistry.NewCounterVec("hub_agent_metrics_send_failures_total",
+		"Number of failed attempts to send metrics to the platform.")
+
+	TopologyWriteDuration = DefaultRegistry.NewHistogramVec("hub_agent_topology_write_duration_seconds",
+		"Duration of topology pushes, by result.", DefBuckets, "result")
+
+	TraefikConfigPushDuration = DefaultRegistry.NewHistogramVec("hub_agent_traefik_config_push_duration_seconds",
+		"Duration of dynamic configuration pushes to Traefik, by result.", DefBuckets, "result")
+)
+
+// Result returns the result label value of an operation which returned the given error.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "success"
+}
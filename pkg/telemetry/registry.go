@@ -0,0 +1,318 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package telemetry
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto" //nolint:staticcheck // Required by the client_model types.
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/log"
+)
+
+// DefBuckets are the default histogram buckets, suited to measure network call durations in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	collect() *dto.MetricFamily
+}
+
+// Registry holds metrics and exposes them in the Prometheus exposition format.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry returns a new Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounterVec registers a new counter partitioned by the given labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, labels)}
+	r.register(c)
+
+	return c
+}
+
+// NewGauge registers a new gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	r.register(g)
+
+	return g
+}
+
+// NewHistogramVec registers a new histogram with the given buckets, partitioned by the given labels.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec(name, help, labels), buckets: buckets}
+	r.register(h)
+
+	return h
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Gather returns the metric families of all the registered metrics, sorted by name.
+// Vectors without any value yet are skipped, as a metric family must have at least one metric.
+func (r *Registry) Gather() []*dto.MetricFamily {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	families := make([]*dto.MetricFamily, 0, len(collectors))
+	for _, c := range collectors {
+		family := c.collect()
+		if len(family.GetMetric()) == 0 {
+			continue
+		}
+
+		families = append(families, family)
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+
+	return families
+}
+
+// ServeHTTP writes all the registered metrics in the format negotiated with the client.
+func (r *Registry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	format := expfmt.Negotiate(req.Header)
+	rw.Header().Set("Content-Type", string(format))
+
+	enc := expfmt.NewEncoder(rw, format)
+	for _, family := range r.Gather() {
+		if err := enc.Encode(family); err != nil {
+			log.Error().Err(err).Str("metric", family.GetName()).Msg("Unable to encode metric")
+			return
+		}
+	}
+}
+
+// vec holds the values of a metric, partitioned by label values.
+type vec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]interface{}),
+	}
+}
+
+// value returns the value for the given label values, creating it with newValue if needed.
+// It must be called with the lock held.
+func (v *vec) value(lvs []string, newValue func() interface{}) interface{} {
+	if len(lvs) != len(v.labels) {
+		// This is a programming error, there is no point in going further.
+		panic("metric " + v.name + ": inconsistent label cardinality")
+	}
+
+	key := strings.Join(lvs, "\xff")
+
+	val, ok := v.values[key]
+	if !ok {
+		val = newValue()
+		v.values[key] = val
+	}
+
+	return val
+}
+
+// each calls fn for each value, sorted by label values. It must be called with the lock held.
+func (v *vec) each(fn func(labels []*dto.LabelPair, val interface{})) {
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var lvs []string
+		if len(v.labels) > 0 {
+			lvs = strings.Split(key, "\xff")
+		}
+
+		pairs := make([]*dto.LabelPair, 0, len(v.labels))
+		for i, name := range v.labels {
+			pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(lvs[i])})
+		}
+
+		fn(pairs, v.values[key])
+	}
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec
+}
+
+// Inc increments the counter for the given label values.
+func (c *CounterVec) Inc(lvs ...string) {
+	c.Add(1, lvs...)
+}
+
+// Add adds the given value, which must be positive, to the counter for the given label values.
+func (c *CounterVec) Add(delta float64, lvs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	*c.value(lvs, func() interface{} { return new(float64) }).(*float64) += delta
+}
+
+func (c *CounterVec) collect() *dto.MetricFamily {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	family := &dto.MetricFamily{
+		Name: proto.String(c.name),
+		Help: proto.String(c.help),
+		Type: dto.MetricType_COUNTER.Enum(),
+	}
+
+	c.each(func(labels []*dto.LabelPair, val interface{}) {
+		family.Metric = append(family.Metric, &dto.Metric{
+			Label:   labels,
+			Counter: &dto.Counter{Value: proto.Float64(*val.(*float64))},
+		})
+	})
+
+	return family
+}
+
+// Gauge is a metric whose value can go up and down.
+type Gauge struct {
+	name string
+	help string
+
+	mu  sync.Mutex
+	val float64
+}
+
+// Set sets the gauge value.
+func (g *Gauge) Set(val float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.val = val
+}
+
+func (g *Gauge) collect() *dto.MetricFamily {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return &dto.MetricFamily{
+		Name: proto.String(g.name),
+		Help: proto.String(g.help),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{
+			{Gauge: &dto.Gauge{Value: proto.Float64(g.val)}},
+		},
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec
+
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds an observation to the histogram for the given label values.
+func (h *HistogramVec) Observe(val float64, lvs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist := h.value(lvs, func() interface{} {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	}).(*histogram)
+
+	// Bucket counts are made cumulative when collected.
+	if i := sort.SearchFloat64s(h.buckets, val); i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.count++
+	hist.sum += val
+}
+
+func (h *HistogramVec) collect() *dto.MetricFamily {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	family := &dto.MetricFamily{
+		Name: proto.String(h.name),
+		Help: proto.String(h.help),
+		Type: dto.MetricType_HISTOGRAM.Enum(),
+	}
+
+	h.each(func(labels []*dto.LabelPair, val interface{}) {
+		hist := val.(*histogram)
+
+		var cumulative uint64
+		buckets := make([]*dto.Bucket, 0, len(h.buckets)+1)
+		for i, upperBound := range h.buckets {
+			cumulative += hist.counts[i]
+			buckets = append(buckets, &dto.Bucket{
+				UpperBound:      proto.Float64(upperBound),
+				CumulativeCount: proto.Uint64(cumulative),
+			})
+		}
+		buckets = append(buckets, &dto.Bucket{
+			UpperBound:      proto.Float64(math.Inf(1)),
+			CumulativeCount: proto.Uint64(hist.count),
+		})
+
+		family.Metric = append(family.Metric, &dto.Metric{
+			Label: labels,
+			Histogram: &dto.Histogram{
+				SampleCount: proto.Uint64(hist.count),
+				SampleSum:   proto.Float64(hist.sum),
+				Bucket:      buckets,
+			},
+		})
+	})
+
+	return family
+}
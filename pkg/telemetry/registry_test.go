@@ -0,0 +1,104 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package telemetry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+
+	decisions := registry.NewCounterVec("test_decisions_total", "Test decisions.", "acp", "outcome")
+	decisions.Inc("my-acp", "allowed")
+	decisions.Inc("my-acp", "allowed")
+	decisions.Inc("my-acp", "forbidden")
+
+	sessions := registry.NewGauge("test_sessions", "Test sessions.")
+	sessions.Set(3)
+
+	durations := registry.NewHistogramVec("test_duration_seconds", "Test durations.", []float64{0.1, 1}, "result")
+	durations.Observe(0.05, Result(nil))
+	durations.Observe(0.5, Result(nil))
+	durations.Observe(2, Result(errors.New("boom")))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody)
+
+	registry.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+
+	want := `# HELP test_decisions_total Test decisions.
+# TYPE test_decisions_total counter
+test_decisions_total{acp="my-acp",outcome="allowed"} 2
+test_decisions_total{acp="my-acp",outcome="forbidden"} 1
+# HELP test_duration_seconds Test durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{result="error",le="0.1"} 0
+test_duration_seconds_bucket{result="error",le="1"} 0
+test_duration_seconds_bucket{result="error",le="+Inf"} 1
+test_duration_seconds_sum{result="error"} 2
+test_duration_seconds_count{result="error"} 1
+test_duration_seconds_bucket{result="success",le="0.1"} 1
+test_duration_seconds_bucket{result="success",le="1"} 2
+test_duration_seconds_bucket{result="success",le="+Inf"} 2
+test_duration_seconds_sum{result="success"} 0.55
+test_duration_seconds_count{result="success"} 2
+# HELP test_sessions Test sessions.
+# TYPE test_sessions gauge
+test_sessions 3
+`
+	assert.Equal(t, want, rec.Body.String())
+}
+
+func TestRegistry_ServeHTTP_emptyVec(t *testing.T) {
+	registry := NewRegistry()
+
+	registry.NewCounterVec("test_empty_total", "Test empty.", "acp")
+	registry.NewHistogramVec("test_empty_seconds", "Test empty.", DefBuckets, "result")
+
+	sessions := registry.NewGauge("test_sessions", "Test sessions.")
+	sessions.Set(1)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody)
+
+	registry.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	want := `# HELP test_sessions Test sessions.
+# TYPE test_sessions gauge
+test_sessions 1
+`
+	assert.Equal(t, want, rec.Body.String())
+}
+
+func TestCounterVec_inconsistentLabels(t *testing.T) {
+	counter := NewRegistry().NewCounterVec("test_total", "Test.", "acp")
+
+	assert.Panics(t, func() { counter.Inc() })
+}
@@ -0,0 +1,84 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Server serves the metrics of the agent.
+type Server struct {
+	listenAddr string
+	registry   *Registry
+}
+
+// NewServer creates a new Server exposing the metrics of the given registry.
+func NewServer(listenAddr string, registry *Registry) *Server {
+	return &Server{
+		listenAddr: listenAddr,
+		registry:   registry,
+	}
+}
+
+// Run runs the metrics server.
+func (s *Server) Run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.registry)
+
+	server := &http.Server{
+		Addr:     s.listenAddr,
+		Handler:  mux,
+		ErrorLog: stdlog.New(log.Logger.Level(zerolog.DebugLevel), "", 0),
+	}
+
+	srvDone := make(chan struct{})
+
+	go func() {
+		log.Info().Str("addr", s.listenAddr).Msg("Starting metrics server")
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Err(err).Msg("Unable to listen and serve metrics requests")
+		}
+		close(srvDone)
+	}()
+
+	select {
+	case <-ctx.Done():
+		gracefulCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		//nolint:contextcheck // False positive.
+		if err := server.Shutdown(gracefulCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to shutdown metrics server gracefully")
+			if err = server.Close(); err != nil {
+				return fmt.Errorf("close metrics server: %w", err)
+			}
+		}
+
+		return nil
+	case <-srvDone:
+		return errors.New("metrics server stopped")
+	}
+}
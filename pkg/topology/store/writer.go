@@ -35,11 +35,17 @@ import (
 	"github.com/ldez/go-git-cmd-wrapper/v2/pull"
 	"github.com/ldez/go-git-cmd-wrapper/v2/push"
 	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/telemetry"
 	"github.com/traefik/hub-agent-traefik/pkg/topology"
 )
 
 // Write writes the given cluster state in the current git repository.
-func (s *Store) Write(ctx context.Context, st *topology.Cluster) error {
+func (s *Store) Write(ctx context.Context, st *topology.Cluster) (err error) {
+	start := time.Now()
+	defer func() {
+		telemetry.TopologyWriteDuration.Observe(time.Since(start).Seconds(), telemetry.Result(err))
+	}()
+
 	output, err := git.Branch(branch.List, branch.Format("%(refname:short)"), git.CmdExecutor(s.gitExecutor))
 	if err != nil {
 		return fmt.Errorf("list branches: %w %s", err, output)
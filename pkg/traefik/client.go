@@ -33,6 +33,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 	dto "github.com/prometheus/client_model/go"
@@ -40,6 +41,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/traefik/genconf/dynamic"
 	"github.com/traefik/hub-agent-traefik/pkg/logger"
+	"github.com/traefik/hub-agent-traefik/pkg/telemetry"
 )
 
 const hostname = "proxy.traefik"
@@ -135,7 +137,12 @@ type configRequest struct {
 }
 
 // PushDynamic pushes a dynamic configuration.
-func (c *Client) PushDynamic(ctx context.Context, unixNano int64, cfg *dynamic.Configuration) error {
+func (c *Client) PushDynamic(ctx context.Context, unixNano int64, cfg *dynamic.Configuration) (err error) {
+	start := time.Now()
+	defer func() {
+		telemetry.TraefikConfigPushDuration.Observe(time.Since(start).Seconds(), telemetry.Result(err))
+	}()
+
 	endpoint, err := c.baseURL.Parse(path.Join(c.baseURL.Path, "config"))
 	if err != nil {
 		return err
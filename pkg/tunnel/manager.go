@@ -27,11 +27,13 @@ import (
 	"net/url"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/hashicorp/yamux"
 	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/telemetry"
 )
 
 // Backend is able to call hub-tunnel API.
@@ -48,6 +50,13 @@ type Manager struct {
 
 	tunnelsMu sync.Mutex
 	tunnels   map[string]*tunnel
+
+	// endpoints is the number of tunnels configured for this cluster, and connected the number of tunnels connected.
+	// They are accessed atomically, so readiness checks never wait for the tunnels to be updated.
+	endpoints int32
+	connected int32
+
+	heartbeat func()
 }
 
 type tunnel struct {
@@ -72,9 +81,25 @@ func NewManager(tunnels Backend, traefikAddr, token string, interval time.Durati
 		token:       token,
 		interval:    interval,
 		tunnels:     make(map[string]*tunnel),
+		heartbeat:   func() {},
 	}
 }
 
+// SetHeartbeat sets the function called each time the manager loop runs.
+// It must be called before running the manager.
+func (m *Manager) SetHeartbeat(fn func()) {
+	m.heartbeat = fn
+}
+
+// Ready returns an error if tunnels are configured for this cluster but none of them is connected.
+func (m *Manager) Ready() error {
+	if atomic.LoadInt32(&m.endpoints) == 0 || atomic.LoadInt32(&m.connected) > 0 {
+		return nil
+	}
+
+	return errors.New("no tunnel connected")
+}
+
 // Run runs the manager.
 // While running, the manager fetches every minute the tunnels available for
 // this cluster and create/delete tunnels accordingly.
@@ -89,6 +114,8 @@ func (m *Manager) Run(ctx context.Context) {
 	for {
 		select {
 		case <-ticker.C:
+			m.heartbeat()
+
 			if err := m.updateTunnels(ctx); err != nil {
 				log.Error().Err(err).Msg("Unable to update tunnels")
 				continue
@@ -114,14 +141,16 @@ func (m *Manager) stop() {
 }
 
 func (m *Manager) updateTunnels(ctx context.Context) error {
-	m.tunnelsMu.Lock()
-	defer m.tunnelsMu.Unlock()
-
 	endpoints, err := m.client.ListClusterTunnelEndpoints(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to list tunnels: %w", err)
 	}
 
+	atomic.StoreInt32(&m.endpoints, int32(len(endpoints)))
+
+	m.tunnelsMu.Lock()
+	defer m.tunnelsMu.Unlock()
+
 	currentTunnels := make(map[string]struct{})
 	for _, endpoint := range endpoints {
 		logger := log.With().
@@ -157,6 +186,8 @@ func (m *Manager) updateTunnels(ctx context.Context) error {
 		}
 	}
 
+	telemetry.TunnelSessions.Set(float64(len(m.tunnels)))
+
 	return nil
 }
 
@@ -165,18 +196,21 @@ func (m *Manager) launchTunnel(endpoint Endpoint) {
 	m.tunnels[endpoint.TunnelID] = t
 
 	go func(t *tunnel, tunnelID string) {
-		err := t.launch(tunnelID, m.token, m.traefikAddr)
+		err := t.launch(tunnelID, m.token, m.traefikAddr, &m.connected)
 		if err != nil {
 			log.Error().Err(err).Str("tunnel_id", tunnelID).Msg("Launch tunnel")
 		}
 
 		m.tunnelsMu.Lock()
 		delete(m.tunnels, tunnelID)
+		telemetry.TunnelSessions.Set(float64(len(m.tunnels)))
 		m.tunnelsMu.Unlock()
 	}(t, endpoint.TunnelID)
 }
 
-func (t *tunnel) launch(tunnelID, token, traefikAddr string) error {
+// launch connects the tunnel and proxies the connections it accepts to Traefik. The given counter of connected
+// tunnels is incremented while the tunnel is connected.
+func (t *tunnel) launch(tunnelID, token, traefikAddr string, connected *int32) error {
 	u, err := url.Parse(t.BrokerEndpoint)
 	if err != nil {
 		return fmt.Errorf("parse broker endpoint: %w", err)
@@ -217,6 +251,9 @@ func (t *tunnel) launch(tunnelID, token, traefikAddr string) error {
 
 	t.Client = &closeAwareListener{Listener: client}
 
+	atomic.AddInt32(connected, 1)
+	defer atomic.AddInt32(connected, -1)
+
 	for {
 		brokerConn, acceptErr := t.Client.Accept()
 		if acceptErr != nil {
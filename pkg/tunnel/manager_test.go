@@ -95,6 +95,8 @@ func TestManager_updateTunnels(t *testing.T) {
 	case <-wait:
 	}
 
+	assert.NoError(t, manager.Ready())
+
 	manager.tunnelsMu.Lock()
 	assert.Len(t, manager.tunnels, 3)
 	assert.Equal(t, "ws://"+currentBrokerURL.Host, manager.tunnels["current-tunnel"].BrokerEndpoint)
@@ -114,6 +116,25 @@ func TestManager_updateTunnels(t *testing.T) {
 	manager.tunnelsMu.Unlock()
 }
 
+func TestManager_Ready(t *testing.T) {
+	manager := NewManager(newBackendMock(t), "", "token", time.Minute)
+
+	// No tunnel is configured for this cluster.
+	assert.NoError(t, manager.Ready())
+
+	manager.endpoints = 1
+	assert.Error(t, manager.Ready())
+
+	manager.connected = 1
+	assert.NoError(t, manager.Ready())
+
+	// Readiness checks don't wait for the tunnels to be updated.
+	manager.tunnelsMu.Lock()
+	defer manager.tunnelsMu.Unlock()
+
+	assert.NoError(t, manager.Ready())
+}
+
 func Test_proxy(t *testing.T) {
 	echoListener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
 	require.NoError(t, err)